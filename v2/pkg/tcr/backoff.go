@@ -0,0 +1,190 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// BackoffConstant waits InitialDelay between every attempt.
+	BackoffConstant = "constant"
+
+	// BackoffExponential multiplies the delay by Multiplier after every attempt, up to MaxDelay.
+	BackoffExponential = "exponential"
+
+	// BackoffDecorrelatedJitter picks a random delay between InitialDelay and three times the previous delay, up to MaxDelay.
+	BackoffDecorrelatedJitter = "decorrelatedjitter"
+
+	// defaultBackoffDelay is the delay between recovery attempts when neither the BackoffPolicy nor SleepOnErrorInterval set one.
+	defaultBackoffDelay = 100 * time.Millisecond
+)
+
+// BackoffPolicy decides how long the ConnectionPool waits between recovery attempts and when it gives up.
+type BackoffPolicy struct {
+	Strategy     string  `json:"Strategy" yaml:"Strategy"`         // constant (default), exponential, or decorrelatedjitter
+	InitialDelay uint32  `json:"InitialDelay" yaml:"InitialDelay"` // milliseconds, defaults to PoolConfig.SleepOnErrorInterval (or 100)
+	MaxDelay     uint32  `json:"MaxDelay" yaml:"MaxDelay"`         // milliseconds, zero is uncapped
+	Multiplier   float64 `json:"Multiplier" yaml:"Multiplier"`     // exponential only, defaults to 2
+	Jitter       bool    `json:"Jitter" yaml:"Jitter"`             // randomizes constant and exponential delays between half and the full delay
+	MaxAttempts  uint32  `json:"MaxAttempts" yaml:"MaxAttempts"`   // zero retries forever
+}
+
+// RecoveryExhaustedError is sent to the ConnectionPool error handler, and returned, when a recovery loop runs out of attempts.
+type RecoveryExhaustedError struct {
+	Resource     string // connection, channel, or transient channel
+	ConnectionID uint64
+	Attempts     uint32
+	Err          error // the last error seen
+}
+
+func (ree *RecoveryExhaustedError) Error() string {
+	return fmt.Sprintf("%s recovery on connection %d gave up after %d attempts: %s", ree.Resource, ree.ConnectionID, ree.Attempts, ree.Err)
+}
+
+// Unwrap returns the last error seen.
+func (ree *RecoveryExhaustedError) Unwrap() error {
+	return ree.Err
+}
+
+func validateBackoffPolicy(policy *BackoffPolicy) error {
+
+	if policy == nil {
+		return nil
+	}
+
+	switch policy.Strategy {
+	case "", BackoffConstant, BackoffExponential, BackoffDecorrelatedJitter:
+	default:
+		return fmt.Errorf("backoff strategy %q is not supported", policy.Strategy)
+	}
+
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		return fmt.Errorf("backoff multiplier can't be less than 1")
+	}
+
+	return nil
+}
+
+// backoff tracks the attempts of a single recovery loop.
+type backoff struct {
+	strategy    string
+	initial     time.Duration
+	max         time.Duration
+	multiplier  float64
+	jitter      bool
+	maxAttempts uint32
+	attempts    uint32
+	previous    time.Duration
+}
+
+// newBackoff starts a recovery loop, a nil policy behaves like the original fixed SleepOnErrorInterval retries.
+// Without any delay configured it waits defaultBackoffDelay, so recovery never spins.
+func (cp *ConnectionPool) newBackoff() *backoff {
+
+	b := &backoff{
+		strategy:   BackoffConstant,
+		initial:    cp.sleepOnErrorInterval,
+		multiplier: 2,
+	}
+	if b.initial <= 0 {
+		b.initial = defaultBackoffDelay
+	}
+
+	policy := cp.Config.BackoffPolicy
+	if policy == nil {
+		return b
+	}
+
+	if policy.Strategy != "" {
+		b.strategy = policy.Strategy
+	}
+	if policy.InitialDelay > 0 {
+		b.initial = time.Duration(policy.InitialDelay) * time.Millisecond
+	}
+	if policy.Multiplier > 0 {
+		b.multiplier = policy.Multiplier
+	}
+
+	b.max = time.Duration(policy.MaxDelay) * time.Millisecond
+	b.jitter = policy.Jitter
+	b.maxAttempts = policy.MaxAttempts
+
+	return b
+}
+
+// next records a failed attempt and returns the delay before the next one, false when attempts are exhausted.
+func (b *backoff) next() (time.Duration, bool) {
+
+	b.attempts++
+	if b.maxAttempts > 0 && b.attempts >= b.maxAttempts {
+		return 0, false
+	}
+
+	var delay time.Duration
+	switch b.strategy {
+	case BackoffExponential:
+		delay = time.Duration(float64(b.initial) * math.Pow(b.multiplier, float64(b.attempts-1)))
+		if delay < 0 { // overflow
+			delay = time.Duration(math.MaxInt64)
+		}
+	case BackoffDecorrelatedJitter:
+		previous := b.previous
+		if previous < b.initial {
+			previous = b.initial
+		}
+		delay = b.initial
+		if spread := 3*previous - b.initial; spread > 0 {
+			delay += time.Duration(rand.Int63n(int64(spread)))
+		}
+	default:
+		delay = b.initial
+	}
+
+	if b.max > 0 && delay > b.max {
+		delay = b.max
+	}
+
+	if b.jitter && b.strategy != BackoffDecorrelatedJitter && delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+
+	b.previous = delay
+	return delay, true
+}
+
+// retryAfter reports err to the error handler and waits out the next delay.
+// Returns the context error when the context is done, or a *RecoveryExhaustedError once out of attempts.
+func (cp *ConnectionPool) retryAfter(ctx context.Context, b *backoff, resource string, connectionID uint64, err error) error {
+
+	if cp.errorHandler != nil {
+		cp.errorHandler(err)
+	}
+
+	delay, ok := b.next()
+	if !ok {
+		return cp.exhausted(b, resource, connectionID, err)
+	}
+
+	return sleepContext(ctx, delay)
+}
+
+// exhausted builds the error for a recovery loop that ran out of attempts, reports it to the error handler, and emits RecoveryFailed.
+func (cp *ConnectionPool) exhausted(b *backoff, resource string, connectionID uint64, err error) error {
+
+	exhaustedErr := &RecoveryExhaustedError{
+		Resource:     resource,
+		ConnectionID: connectionID,
+		Attempts:     b.attempts,
+		Err:          err,
+	}
+
+	if cp.errorHandler != nil {
+		cp.errorHandler(exhaustedErr)
+	}
+
+	cp.emit(ConnectionEvent{Type: RecoveryFailed, ConnectionID: connectionID, Err: exhaustedErr})
+	return exhaustedErr
+}
@@ -0,0 +1,239 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultMaxRetryCount is how many times a nacked letter is republished when the PublisherConfig has no MaxRetryCount.
+const defaultMaxRetryCount = 5
+
+// BatchResult holds the outcome of every letter of a batch publish.
+type BatchResult struct {
+	Receipts    []*PublishReceipt // one per letter, in the order of the batch
+	Acked       int
+	Failed      int
+	Unconfirmed int // published, but the batch was cut short before their confirmation (ErrUnconfirmed)
+}
+
+// Failures returns the receipts of the letters that failed, they were not published.
+func (br BatchResult) Failures() []*PublishReceipt {
+
+	return br.receipts(br.Failed, func(receipt *PublishReceipt) bool {
+		return !receipt.Success && !errors.Is(receipt.Error, ErrUnconfirmed)
+	})
+}
+
+// UnconfirmedReceipts returns the receipts of the letters published without a confirmation, republishing them may duplicate them.
+func (br BatchResult) UnconfirmedReceipts() []*PublishReceipt {
+
+	return br.receipts(br.Unconfirmed, func(receipt *PublishReceipt) bool {
+		return errors.Is(receipt.Error, ErrUnconfirmed)
+	})
+}
+
+func (br BatchResult) receipts(count int, match func(*PublishReceipt) bool) []*PublishReceipt {
+
+	receipts := make([]*PublishReceipt, 0, count)
+	for _, receipt := range br.Receipts {
+		if receipt != nil && match(receipt) {
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	return receipts
+}
+
+// batchPublish is a letter of the batch awaiting its confirmation.
+type batchPublish struct {
+	index        int
+	confirmation *DeferredConfirmation
+}
+
+// PublishBatchWithConfirmation publishes the letters on the pooled ackable channels without waiting for each confirmation
+// in turn, then waits for all of them. Nacked letters (or ones whose channel closed before confirming) are republished
+// until their RetryCount reaches the MaxRetryCount of the PublisherConfig. Mandatory letters returned unroutable fail
+// with ErrUnroutable. Letters still unconfirmed after the publish timeout of the Publisher fail without being republished.
+//
+// The result has a receipt for every letter. An error is only returned when the batch was cut short, by the context or
+// by failing to get a channel. The letters it never got to have failed receipts carrying that error, the letters it
+// published and was still awaiting the confirmation of are unconfirmed (ErrUnconfirmed). The letters are not modified,
+// their RetryCount is where the count of republishes starts.
+func (pub *Publisher) PublishBatchWithConfirmation(ctx context.Context, letters []*Letter) (BatchResult, error) {
+
+	result := BatchResult{Receipts: make([]*PublishReceipt, len(letters))}
+
+	// The letters as published, the claim checked ones are swapped for their copies.
+	letters = append([]*Letter(nil), letters...)
+
+	receipts := make([]*PublishReceipt, len(letters)) // recorded in the result once the letter is done
+	retries := make([]uint32, len(letters))
+	pending := make([]int, len(letters))
+	for i := range letters {
+		receipts[i] = newPublishReceipt(letters[i])
+		retries[i] = letters[i].RetryCount
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+
+		published, err := pub.publishBatch(ctx, letters, receipts, pending)
+		if err != nil {
+			pub.failBatch(&result, letters, receipts, published, err)
+			return result, err
+		}
+
+		timedOut := make(chan struct{}) // closed when the publish timeout passes, times out every confirmation still awaited
+		var timer *time.Timer
+		if pub.publishTimeOutDuration > 0 {
+			timer = time.AfterFunc(pub.publishTimeOutDuration, func() { close(timedOut) })
+		}
+
+		pending = pending[:0]
+		for i, publish := range published {
+			letter := letters[publish.index]
+			receipt := receipts[publish.index]
+
+			select {
+			case <-publish.confirmation.Done():
+			case <-timedOut:
+				pub.observeConfirmationTimeout(letter)
+				pub.circuitResult(ErrConfirmTimeout)
+				pub.recordBatchReceipt(&result, publish.index, letter, receipt, newConfirmTimeoutError(letter))
+				continue
+			case <-ctx.Done():
+				err = fmt.Errorf("publish batch: %w", ctx.Err())
+				pub.failBatch(&result, letters, receipts, published[i:], err)
+				return result, err
+			}
+
+			acked := publish.confirmation.Acked()
+			receipt.confirmed(publish.confirmation.ConfirmedAt())
+			pub.observeConfirmation(letter, acked, receipt.ConfirmLatency)
+			pub.circuitConfirmation(publish.confirmation)
+			if returned := publish.confirmation.Returned(); returned != nil {
+				pub.recordBatchReceipt(&result, publish.index, letter, receipt, newUnroutableError(letter, returned))
+				continue
+			}
+
+			if acked {
+				pub.recordBatchReceipt(&result, publish.index, letter, receipt, nil)
+				continue
+			}
+
+			if retries[publish.index] >= pub.maxRetryCount {
+				err := publish.confirmation.Err()
+				if err == nil {
+					err = newNackedError(letter)
+				}
+				pub.recordBatchReceipt(&result, publish.index, letter, receipt, err)
+				continue
+			}
+
+			retries[publish.index]++
+			pub.observePublishRetry(letter)
+			pending = append(pending, publish.index)
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	return result, nil
+}
+
+// publishBatch publishes the pending letters of the batch, round robin across the pooled ackable channels.
+// When it can't get a channel, it returns the letters published so far alongside the error.
+func (pub *Publisher) publishBatch(
+	ctx context.Context,
+	letters []*Letter,
+	receipts []*PublishReceipt,
+	pending []int) ([]*batchPublish, error) {
+
+	published := make([]*batchPublish, 0, len(pending))
+	for _, index := range pending {
+		letter := letters[index]
+
+		for {
+			chanHost, claimChecked, err := pub.getPublishChannel(ctx, letter, true)
+			letter, letters[index] = claimChecked, claimChecked // offloaded once, the republishes reuse the copy
+			if err != nil {
+				pub.observePublish(letter, err)
+				return published, err
+			}
+
+			receipts[index].attempt(chanHost)
+			confirmation, err := chanHost.PublishWithDeferredConfirmation(
+				letter.Envelope.Exchange,
+				letter.Envelope.RoutingKey,
+				letter.Envelope.Mandatory,
+				letter.Envelope.Immediate,
+				newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
+			)
+			pub.observePublish(letter, err)
+			if err != nil {
+				pub.circuitResult(err)
+				pub.observePublishRetry(letter)
+				pub.ConnectionPool.ReturnChannel(chanHost, true)
+				continue // Take it again! From the top!
+			}
+
+			pub.ConnectionPool.ReturnChannel(chanHost, false)
+			published = append(published, &batchPublish{index: index, confirmation: confirmation})
+			break
+		}
+	}
+
+	return published, nil
+}
+
+func (pub *Publisher) recordBatchReceipt(result *BatchResult, index int, letter *Letter, receipt *PublishReceipt, err error) {
+
+	receipt.finish(letter, err)
+	switch {
+	case err == nil:
+		result.Acked++
+	case errors.Is(err, ErrUnconfirmed):
+		result.Unconfirmed++
+	default:
+		result.Failed++
+	}
+
+	result.Receipts[index] = receipt
+}
+
+// failBatch records the letters of a batch cut short by err. The awaited letters are unconfirmed, unless their
+// confirmation came in already, and every other letter without a receipt yet failed with err.
+func (pub *Publisher) failBatch(
+	result *BatchResult,
+	letters []*Letter,
+	receipts []*PublishReceipt,
+	awaited []*batchPublish,
+	err error) {
+
+	for _, publish := range awaited {
+		letter, receipt, confirmation := letters[publish.index], receipts[publish.index], publish.confirmation
+
+		select {
+		case <-confirmation.Done():
+			receipt.confirmed(confirmation.ConfirmedAt())
+			if returned := confirmation.Returned(); returned != nil {
+				pub.recordBatchReceipt(result, publish.index, letter, receipt, newUnroutableError(letter, returned))
+			} else if confirmation.Acked() {
+				pub.recordBatchReceipt(result, publish.index, letter, receipt, nil)
+			}
+		default:
+			pub.recordBatchReceipt(result, publish.index, letter, receipt,
+				fmt.Errorf("%w for LetterID: %s (%s)", ErrUnconfirmed, letter.LetterID.String(), err.Error()))
+		}
+	}
+
+	for index, receipt := range result.Receipts {
+		if receipt == nil {
+			pub.recordBatchReceipt(result, index, letters[index], receipts[index], err)
+		}
+	}
+}
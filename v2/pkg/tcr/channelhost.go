@@ -9,14 +9,20 @@ import (
 
 // ChannelHost is an internal representation of amqp.Channel.
 type ChannelHost struct {
-	Channel       *amqp.Channel
+	Channel       *amqp.Channel // nil when the PoolConfig supplies a Transport other than streadway/amqp
 	ID            uint64
 	ConnectionID  uint64
 	Ackable       bool
 	CachedChannel bool
+	// Deprecated: Confirmations are consumed internally to match them to their publishes by delivery tag, reading
+	// them steals confirmations from the Publisher. Use PublishWithDeferredConfirmation instead.
 	Confirmations chan amqp.Confirmation
 	Errors        chan *amqp.Error
 	connHost      *ConnectionHost
+	channel       AMQPChannel     // the Transport's channel, Channel is only set for the default Transport
+	confirms      *confirmTracker // matches Confirmations to publishes by delivery tag
+	conn          AMQPConnection  // the connection the channel was made on
+	broken        bool            // recovery gave up on the channel, it is rebuilt on its next checkout
 	chanLock      *sync.Mutex
 }
 
@@ -27,7 +33,7 @@ func NewChannelHost(
 	connectionID uint64,
 	ackable, cached bool) (*ChannelHost, error) {
 
-	if connHost.Connection.IsClosed() {
+	if connHost.currentConnection().IsClosed() {
 		return nil, errors.New("can't open a channel - connection is already closed")
 	}
 
@@ -50,7 +56,14 @@ func NewChannelHost(
 
 // Close allows for manual close of Amqp Channel kept internally.
 func (ch *ChannelHost) Close() {
-	ch.Channel.Close()
+	ch.TransportChannel().Close()
+}
+
+// TransportChannel returns the channel as opened by the Transport, works with any Transport unlike Channel.
+func (ch *ChannelHost) TransportChannel() AMQPChannel {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+	return ch.channel
 }
 
 // MakeChannel tries to create (or re-create) the channel from the ConnectionHost its attached to.
@@ -58,48 +71,113 @@ func (ch *ChannelHost) MakeChannel() (err error) {
 	ch.chanLock.Lock()
 	defer ch.chanLock.Unlock()
 
-	ch.Channel, err = ch.connHost.Connection.Channel()
+	conn := ch.connHost.currentConnection()
+	ch.channel, err = conn.Channel()
 	if err != nil {
 		return err
 	}
+	ch.Channel = unwrapChannel(ch.channel)
+	ch.conn = conn
 
+	returns := ch.channel.NotifyReturn(make(chan amqp.Return, 100))
 	if ch.Ackable {
-		err = ch.Channel.Confirm(false)
+		err = ch.channel.Confirm(false)
 		if err != nil {
 			return err
 		}
 
 		ch.Confirmations = make(chan amqp.Confirmation, 100)
-		ch.Channel.NotifyPublish(ch.Confirmations)
+		ch.confirms = newConfirmTracker(ch.channel.NotifyPublish(ch.Confirmations), returns, ch.connHost.emitReturn)
+	} else {
+		go ch.forwardReturns(returns)
 	}
 
 	ch.Errors = make(chan *amqp.Error, 100)
-	ch.Channel.NotifyClose(ch.Errors)
+	ch.channel.NotifyClose(ch.Errors)
 
 	return nil
 }
 
-// FlushConfirms removes all previous confirmations pending processing.
-func (ch *ChannelHost) FlushConfirms() {
+// stale returns true when the ConnectionHost has moved on to a new connection since the channel was made (ex. recycled).
+func (ch *ChannelHost) stale() bool {
 	ch.chanLock.Lock()
 	defer ch.chanLock.Unlock()
+	return ch.conn != ch.connHost.currentConnection()
+}
 
-	for {
-		if ch.connHost.Connection.IsClosed() {
-			return
-		}
+func (ch *ChannelHost) setBroken(broken bool) {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+	ch.broken = broken
+}
 
-		// Some weird use case where the Channel is being flooded with confirms after connection disruption
-		// It lead to an infinite loop when this method was called.
-		select {
-		case <-ch.Confirmations:
-			return // return prevents the infinite loop here
-		default:
-			return
-		}
+func (ch *ChannelHost) isBroken() bool {
+	ch.chanLock.Lock()
+	defer ch.chanLock.Unlock()
+	return ch.broken
+}
+
+// closed returns true when the channel has been closed, along with the reason taken off Errors (nil on a client
+// initiated close, Errors is then closed without an error).
+func (ch *ChannelHost) closed() (bool, *amqp.Error) {
+	ch.chanLock.Lock()
+	errs := ch.Errors
+	ch.chanLock.Unlock()
+
+	select {
+	case reason := <-errs:
+		return true, reason
+	default:
+		return false, nil
+	}
+}
+
+// PublishWithDeferredConfirmation publishes on a confirm mode (ackable) channel without waiting for the confirmation.
+// The DeferredConfirmation resolves when the server acks or nacks this publish, so many publishes can be awaiting
+// their confirmations on the channel at once. Confirmations are consumed internally, don't read them from Confirmations
+// and don't publish with confirmations straight on the Channel.
+func (ch *ChannelHost) PublishWithDeferredConfirmation(
+	exchange, routingKey string,
+	mandatory, immediate bool,
+	msg amqp.Publishing) (*DeferredConfirmation, error) {
+
+	ch.chanLock.Lock()
+	channel, confirms := ch.channel, ch.confirms
+	ch.chanLock.Unlock()
+
+	if confirms == nil {
+		return nil, errors.New("can't await a confirmation - channel is not in confirm mode")
+	}
+
+	return confirms.publish(channel, exchange, routingKey, mandatory, immediate, msg)
+}
+
+// forwardReturns hands the returns of a channel without confirmations to the ConnectionPool until the channel closes.
+func (ch *ChannelHost) forwardReturns(returns <-chan amqp.Return) {
+	for ret := range returns {
+		ch.connHost.emitReturn(NewReturnMessage(&ret))
+	}
+}
+
+// PendingConfirmations returns how many publishes on the channel are awaiting their confirmation.
+func (ch *ChannelHost) PendingConfirmations() int {
+	ch.chanLock.Lock()
+	confirms := ch.confirms
+	ch.chanLock.Unlock()
+
+	if confirms == nil {
+		return 0
 	}
+
+	return confirms.inFlight()
 }
 
+// FlushConfirms is a no-op kept for compatibility. Confirmations are matched to their publishes by delivery tag,
+// so a late confirmation can no longer be mistaken for the next publish's.
+//
+// Deprecated: there is nothing to flush, the call can be removed.
+func (ch *ChannelHost) FlushConfirms() {}
+
 // PauseForFlowControl allows you to wait and sleep while receiving flow control messages.
 func (ch *ChannelHost) PauseForFlowControl() {
 
@@ -0,0 +1,370 @@
+package tcr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// ChunkIndexHeader is the position (from 0) of a chunk among the chunks of its letter.
+	ChunkIndexHeader = "x-tcr-chunk-index"
+
+	// ChunkCountHeader is how many chunks the body of the letter was split into.
+	ChunkCountHeader = "x-tcr-chunk-count"
+
+	defaultChunkTimeout  = 30 * time.Second
+	defaultChunkMaxBytes = 64 << 20
+	defaultChunkMaxCount = 1 << 16
+)
+
+var (
+	// ErrChunksExpired is reported when the chunks of a letter are not all received before the timeout of a ChunkedConsumer.
+	ErrChunksExpired = errors.New("chunks expired before all of them were received")
+
+	// ErrChunkBufferFull is reported when buffering a chunk would take a ChunkedConsumer over its memory cap.
+	ErrChunkBufferFull = errors.New("chunk buffer is full")
+)
+
+// PublishChunksWithConfirmation splits the body of the letter into chunks of chunkSize bytes and publishes them with
+// confirmations as a batch, see PublishBatchWithConfirmation. Every chunk shares the LetterID and Envelope of the
+// letter, with the ChunkIndexHeader and ChunkCountHeader added, for a ChunkedConsumer to reassemble them.
+// The receipts of the result are in the order of the chunks.
+func (pub *Publisher) PublishChunksWithConfirmation(ctx context.Context, letter *Letter, chunkSize int) (BatchResult, error) {
+
+	if chunkSize <= 0 {
+		return BatchResult{}, fmt.Errorf("chunk size has to be positive, not %d", chunkSize)
+	}
+
+	count := (len(letter.Body) + chunkSize - 1) / chunkSize
+	if count == 0 {
+		count = 1 // an empty body is still a message
+	}
+	if count > math.MaxInt32 {
+		return BatchResult{}, fmt.Errorf("LetterID: %s would need %d chunks, too many for chunks of %d bytes", letter.LetterID.String(), count, chunkSize)
+	}
+
+	chunks := make([]*Letter, count)
+	for index := range chunks {
+		start := index * chunkSize
+		end := start + chunkSize
+		if end > len(letter.Body) {
+			end = len(letter.Body)
+		}
+
+		envelope := *letter.Envelope
+		envelope.Headers = make(amqp.Table, len(letter.Envelope.Headers)+2) // the headers of each chunk differ
+		for key, value := range letter.Envelope.Headers {
+			envelope.Headers[key] = value
+		}
+		envelope.Headers[ChunkIndexHeader] = int32(index)
+		envelope.Headers[ChunkCountHeader] = int32(count)
+
+		chunks[index] = &Letter{
+			LetterID: letter.LetterID,
+			Body:     letter.Body[start:end],
+			Envelope: &envelope,
+		}
+	}
+
+	return pub.PublishBatchWithConfirmation(ctx, chunks)
+}
+
+// ChunkedConsumer consumes with a Consumer, reassembling the chunks published by PublishChunksWithConfirmation.
+// The chunks of a letter are buffered until all of them are received, then the handler gets one ReceivedMessage with
+// the whole body. Messages that aren't chunks go straight to the handler.
+//
+// The Consumer needs a QosCountOverride (prefetch) larger than the chunk count of a letter, unacked chunks count
+// against it until the letter is complete.
+type ChunkedConsumer struct {
+	Consumer      *Consumer
+	Timeout       time.Duration // how long the chunks of a letter are buffered, waiting for the rest of them
+	MaxBytes      int           // the memory cap, in bytes, of the chunks buffered across letters
+	MaxChunks     int           // the most chunks of a letter, a chunk counting more is rejected before buffering
+	buffers       map[string]*chunkBuffer
+	bufferedBytes int
+	chunkLock     *sync.Mutex
+}
+
+// chunkBuffer holds the chunks of a letter received so far.
+type chunkBuffer struct {
+	chunks   []*ReceivedMessage
+	received int
+	size     int
+	timer    *time.Timer
+}
+
+// NewChunkedConsumer creates a ChunkedConsumer on the Consumer. A timeout of 0 waits 30 seconds for the chunks of a
+// letter, and a maxBytes of 0 buffers up to 64MB of chunks. Letters of up to 65536 chunks are accepted (MaxChunks).
+func NewChunkedConsumer(consumer *Consumer, timeout time.Duration, maxBytes int) *ChunkedConsumer {
+
+	if timeout <= 0 {
+		timeout = defaultChunkTimeout
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = defaultChunkMaxBytes
+	}
+
+	return &ChunkedConsumer{
+		Consumer:  consumer,
+		Timeout:   timeout,
+		MaxBytes:  maxBytes,
+		MaxChunks: defaultChunkMaxCount,
+		buffers:   make(map[string]*chunkBuffer),
+		chunkLock: &sync.Mutex{},
+	}
+}
+
+// StartConsuming starts the Consumer handing every complete message to the handler. The ReceivedMessage can't be acked
+// by the handler: when ackable, all of its chunks are acked once the handler returns nil, or nacked and requeued when
+// it returns an error.
+//
+// Chunks that expire, or that don't fit under MaxBytes, are reported to the Errors of the Consumer. Expired chunks are
+// rejected without requeueing, as the rest of them may never come. Chunks over the cap are requeued, unless the letter
+// is larger than the cap on its own.
+func (cc *ChunkedConsumer) StartConsuming(handler func(*ReceivedMessage) error) {
+
+	cc.Consumer.StartConsumingWithAction(func(message *ReceivedMessage) {
+
+		index, count, err := readChunkHeaders(message.Delivery.Headers, cc.maxChunks())
+		if err != nil {
+			cc.report(fmt.Errorf("consumer %s received an invalid chunk of MessageID: %s: %w", cc.Consumer.ConsumerName, message.MessageID, err))
+			if message.IsAckable {
+				_ = message.Reject(false) // it would never reassemble
+			}
+			return
+		}
+
+		if count == 0 {
+			cc.handle(handler, NewReceivedMessage(false, message.Delivery), []*ReceivedMessage{message})
+			return
+		}
+
+		if chunks := cc.buffer(message, index, count); chunks != nil {
+			cc.handle(handler, assembleChunks(chunks), chunks)
+		}
+	})
+}
+
+// maxChunks returns the most chunks a letter can count, no more than MaxBytes as every chunk but an empty letter's
+// carries at least a byte.
+func (cc *ChunkedConsumer) maxChunks() int {
+
+	maxChunks := cc.MaxChunks
+	if maxChunks <= 0 {
+		maxChunks = defaultChunkMaxCount
+	}
+	if maxChunks > cc.MaxBytes {
+		maxChunks = cc.MaxBytes
+	}
+
+	return maxChunks
+}
+
+// handle hands the message to the handler, then settles its chunks according to the outcome.
+func (cc *ChunkedConsumer) handle(handler func(*ReceivedMessage) error, message *ReceivedMessage, chunks []*ReceivedMessage) {
+
+	if err := handler(message); err != nil {
+		cc.report(fmt.Errorf("consumer %s failed to handle MessageID: %s: %w", cc.Consumer.ConsumerName, message.MessageID, err))
+		settleChunks(chunks, func(chunk *ReceivedMessage) error { return chunk.Nack(true) })
+		return
+	}
+
+	settleChunks(chunks, func(chunk *ReceivedMessage) error { return chunk.Acknowledge() })
+}
+
+// buffer adds the chunk to the buffer of its letter, returning every chunk in order once the letter is complete.
+func (cc *ChunkedConsumer) buffer(message *ReceivedMessage, index, count int) []*ReceivedMessage {
+
+	cc.chunkLock.Lock()
+
+	key := message.MessageID
+	buffer, ok := cc.buffers[key]
+	if ok && len(buffer.chunks) != count {
+		cc.chunkLock.Unlock()
+		cc.report(fmt.Errorf("consumer %s received a chunk of MessageID: %s counting %d chunks instead of %d", cc.Consumer.ConsumerName, key, count, len(buffer.chunks)))
+		if message.IsAckable {
+			_ = message.Reject(false)
+		}
+		return nil
+	}
+
+	size := len(message.Body)
+	if cc.bufferedBytes+size > cc.MaxBytes {
+		var dropped []*ReceivedMessage
+		tooLarge := size > cc.MaxBytes
+		if ok {
+			dropped = cc.dropBuffer(key, buffer)
+			tooLarge = tooLarge || buffer.size+size > cc.MaxBytes
+		}
+		cc.chunkLock.Unlock()
+
+		cc.report(fmt.Errorf("consumer %s can't buffer chunk %d of MessageID: %s: %w", cc.Consumer.ConsumerName, index, key, ErrChunkBufferFull))
+		settleChunks(append(dropped, message), func(chunk *ReceivedMessage) error { return chunk.Nack(!tooLarge) })
+		return nil
+	}
+
+	if !ok {
+		buffer = &chunkBuffer{chunks: make([]*ReceivedMessage, count)}
+		buffer.timer = time.AfterFunc(cc.Timeout, func() { cc.expire(key, buffer) })
+		cc.buffers[key] = buffer
+	}
+
+	duplicate := buffer.chunks[index]
+	if duplicate != nil { // republished, or redelivered after a requeue
+		buffer.size -= len(duplicate.Body)
+		cc.bufferedBytes -= len(duplicate.Body)
+	} else {
+		buffer.received++
+	}
+
+	buffer.chunks[index] = message
+	buffer.size += size
+	cc.bufferedBytes += size
+
+	var chunks []*ReceivedMessage
+	if buffer.received == count {
+		buffer.timer.Stop()
+		chunks = cc.dropBuffer(key, buffer)
+	}
+	cc.chunkLock.Unlock()
+
+	if duplicate != nil && duplicate.IsAckable {
+		_ = duplicate.Acknowledge() // the copy buffered in its place holds the same data
+	}
+
+	return chunks
+}
+
+// dropBuffer forgets the buffer of the letter, returning its chunks. The chunkLock has to be held.
+func (cc *ChunkedConsumer) dropBuffer(key string, buffer *chunkBuffer) []*ReceivedMessage {
+
+	delete(cc.buffers, key)
+	cc.bufferedBytes -= buffer.size
+	buffer.timer.Stop()
+
+	chunks := make([]*ReceivedMessage, 0, buffer.received)
+	for _, chunk := range buffer.chunks {
+		if chunk != nil {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	return chunks
+}
+
+// expire gives up on the buffer of a letter when its chunks didn't all arrive in time.
+func (cc *ChunkedConsumer) expire(key string, buffer *chunkBuffer) {
+
+	cc.chunkLock.Lock()
+	if cc.buffers[key] != buffer {
+		cc.chunkLock.Unlock()
+		return // completed or dropped in the meantime
+	}
+	chunks := cc.dropBuffer(key, buffer)
+	missing := len(buffer.chunks) - buffer.received
+	cc.chunkLock.Unlock()
+
+	cc.report(fmt.Errorf("consumer %s is missing %d chunks of MessageID: %s: %w", cc.Consumer.ConsumerName, missing, key, ErrChunksExpired))
+	settleChunks(chunks, func(chunk *ReceivedMessage) error { return chunk.Reject(false) })
+}
+
+func (cc *ChunkedConsumer) report(err error) {
+
+	con := cc.Consumer
+	if observer := con.getObserver(); observer != nil {
+		observer.ObserveConsumerError(con.ConsumerName, err)
+	}
+	con.errors <- err
+}
+
+// settleChunks acks, nacks, or rejects the ackable chunks.
+func settleChunks(chunks []*ReceivedMessage, settle func(*ReceivedMessage) error) {
+	for _, chunk := range chunks {
+		if chunk.IsAckable {
+			_ = settle(chunk)
+		}
+	}
+}
+
+// assembleChunks joins the bodies of the chunks into the message of the first one, without the chunk headers.
+// The message isn't ackable, its chunks are settled by the ChunkedConsumer.
+func assembleChunks(chunks []*ReceivedMessage) *ReceivedMessage {
+
+	body := &bytes.Buffer{}
+	for _, chunk := range chunks {
+		body.Write(chunk.Body)
+	}
+
+	delivery := chunks[0].Delivery
+	delivery.Body = body.Bytes()
+	delivery.Headers = make(amqp.Table, len(chunks[0].Delivery.Headers))
+	for key, value := range chunks[0].Delivery.Headers {
+		if key != ChunkIndexHeader && key != ChunkCountHeader {
+			delivery.Headers[key] = value
+		}
+	}
+
+	return NewReceivedMessage(false, delivery)
+}
+
+// readChunkHeaders returns the index and count of a chunk, a count of 0 when the message isn't one.
+// A count over maxChunks is not valid, the headers come off the wire and nothing is allocated for them beforehand.
+func readChunkHeaders(headers amqp.Table, maxChunks int) (int, int, error) {
+
+	countValue, ok := headers[ChunkCountHeader]
+	if !ok {
+		return 0, 0, nil
+	}
+
+	count, ok := headerInt(countValue)
+	if !ok || count < 1 {
+		return 0, 0, fmt.Errorf("%s %v is not valid", ChunkCountHeader, countValue)
+	}
+	if count > maxChunks {
+		return 0, 0, fmt.Errorf("%s %d is over the %d chunks accepted", ChunkCountHeader, count, maxChunks)
+	}
+
+	index, ok := headerInt(headers[ChunkIndexHeader])
+	if !ok || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("%s %v is not valid for %d chunks", ChunkIndexHeader, headers[ChunkIndexHeader], count)
+	}
+
+	return index, count, nil
+}
+
+func headerInt(value interface{}) (int, bool) {
+
+	switch number := value.(type) {
+	case int:
+		return number, true
+	case int8:
+		return int(number), true
+	case int16:
+		return int(number), true
+	case int32:
+		return int(number), true
+	case int64:
+		return int(number), true
+	case uint8:
+		return int(number), true
+	case uint16:
+		return int(number), true
+	case uint32:
+		return int(number), true
+	case float64: // ex. headers decoded from JSON
+		if number != math.Trunc(number) || number < math.MinInt32 || number > math.MaxInt32 {
+			return 0, false
+		}
+		return int(number), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,319 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitOpenInterval     = 5 * time.Second
+	defaultCircuitHalfOpenProbes   = 1
+)
+
+// CircuitState is the state of the circuit breaker of a Publisher.
+type CircuitState string
+
+const (
+	// CircuitClosed lets every publish through.
+	CircuitClosed CircuitState = "closed"
+
+	// CircuitOpen rejects every publish with ErrCircuitOpen.
+	CircuitOpen CircuitState = "open"
+
+	// CircuitHalfOpen lets a few probe publishes through, closing the circuit when they succeed and opening it again when one fails.
+	CircuitHalfOpen CircuitState = "halfopen"
+)
+
+// CircuitEvent describes a state change of the circuit breaker of a Publisher.
+type CircuitEvent struct {
+	From     CircuitState
+	To       CircuitState
+	Failures int   // consecutive failures counted when the circuit opened
+	Err      error // the failure that opened the circuit
+	Time     time.Time
+}
+
+// circuitBreaker counts consecutive publish failures (errors, nacks, and confirmation timeouts) and rejects publishing while open.
+type circuitBreaker struct {
+	threshold       int
+	window          time.Duration
+	openInterval    time.Duration
+	probes          int
+	state           CircuitState
+	failures        int
+	firstFailure    time.Time
+	openedAt        time.Time // when the circuit opened, or when half-open probes were last handed out
+	probesStarted   int
+	probesSucceeded int
+	changed         chan struct{} // closed on every state change
+	lock            *sync.Mutex
+}
+
+func newCircuitBreaker(config *CircuitBreakerConfig) *circuitBreaker {
+
+	if config == nil {
+		return nil
+	}
+
+	cb := &circuitBreaker{
+		threshold:    int(config.FailureThreshold),
+		window:       time.Duration(config.FailureWindow) * time.Millisecond,
+		openInterval: time.Duration(config.OpenInterval) * time.Millisecond,
+		probes:       int(config.HalfOpenProbes),
+		state:        CircuitClosed,
+		changed:      make(chan struct{}),
+		lock:         &sync.Mutex{},
+	}
+
+	if cb.threshold == 0 {
+		cb.threshold = defaultCircuitFailureThreshold
+	}
+	if cb.openInterval == 0 {
+		cb.openInterval = defaultCircuitOpenInterval
+	}
+	if cb.probes == 0 {
+		cb.probes = defaultCircuitHalfOpenProbes
+	}
+
+	return cb
+}
+
+// transition changes the state, the caller holds the lock and emits the event.
+func (cb *circuitBreaker) transition(to CircuitState, err error, now time.Time) *CircuitEvent {
+
+	event := &CircuitEvent{From: cb.state, To: to, Err: err, Time: now}
+	if to == CircuitOpen {
+		event.Failures = cb.failures
+	}
+
+	cb.state = to
+	cb.failures = 0
+	cb.openedAt = now
+	cb.probesStarted = 0
+	cb.probesSucceeded = 0
+
+	close(cb.changed)
+	cb.changed = make(chan struct{})
+
+	return event
+}
+
+// allow reports whether a publish may go through right now, handing out the probes of a half-open circuit.
+// Probes that never report back are handed out again once the open interval passes.
+func (cb *circuitBreaker) allow(now time.Time) (*CircuitEvent, error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	var event *CircuitEvent
+	switch cb.state {
+	case CircuitClosed:
+		return nil, nil
+
+	case CircuitOpen:
+		if now.Sub(cb.openedAt) < cb.openInterval {
+			return nil, ErrCircuitOpen
+		}
+		event = cb.transition(CircuitHalfOpen, nil, now)
+	}
+
+	if cb.probesStarted >= cb.probes {
+		if now.Sub(cb.openedAt) < cb.openInterval {
+			return event, ErrCircuitOpen
+		}
+		cb.openedAt = now
+		cb.probesStarted = 0
+	}
+
+	cb.probesStarted++
+	return event, nil
+}
+
+// retry returns when allow might let a publish through again, and a channel closed on the next state change.
+func (cb *circuitBreaker) retry() (time.Time, <-chan struct{}) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.openedAt.Add(cb.openInterval), cb.changed
+}
+
+// record counts the outcome of a publish, a nil error is a success.
+func (cb *circuitBreaker) record(err error, now time.Time) *CircuitEvent {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		if err == nil {
+			cb.failures = 0
+			return nil
+		}
+
+		if cb.failures == 0 || (cb.window > 0 && now.Sub(cb.firstFailure) > cb.window) {
+			cb.failures = 0
+			cb.firstFailure = now
+		}
+
+		cb.failures++
+		if cb.failures >= cb.threshold {
+			return cb.transition(CircuitOpen, err, now)
+		}
+
+	case CircuitHalfOpen:
+		if err != nil {
+			cb.failures = 1
+			return cb.transition(CircuitOpen, err, now)
+		}
+
+		cb.probesSucceeded++
+		if cb.probesSucceeded >= cb.probes {
+			return cb.transition(CircuitClosed, nil, now)
+		}
+	}
+
+	return nil // publishes finishing while open were let through before it opened
+}
+
+func (cb *circuitBreaker) getState() CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.state
+}
+
+// SetCircuitBreaker makes the Publisher reject publishing with ErrCircuitOpen after consecutive failures, nil removes it.
+// Letters queued for auto-publishing wait for the circuit to close instead of failing.
+func (pub *Publisher) SetCircuitBreaker(config *CircuitBreakerConfig) {
+	pub.pubRWLock.Lock()
+	defer pub.pubRWLock.Unlock()
+	pub.circuitBreaker = newCircuitBreaker(config)
+}
+
+// SetCircuitBreakerHandler calls the handler on every state change of the circuit breaker, nil removes the current handler.
+// The handler is called inline while publishing and must be safe for concurrent use.
+func (pub *Publisher) SetCircuitBreakerHandler(handler func(CircuitEvent)) {
+	pub.pubRWLock.Lock()
+	defer pub.pubRWLock.Unlock()
+	pub.circuitHandler = handler
+}
+
+// CircuitState returns the state of the circuit breaker, always CircuitClosed without one.
+func (pub *Publisher) CircuitState() CircuitState {
+
+	if cb := pub.getCircuitBreaker(); cb != nil {
+		return cb.getState()
+	}
+
+	return CircuitClosed
+}
+
+func (pub *Publisher) getCircuitBreaker() *circuitBreaker {
+	pub.pubRWLock.RLock()
+	defer pub.pubRWLock.RUnlock()
+	return pub.circuitBreaker
+}
+
+func (pub *Publisher) emitCircuitEvent(event *CircuitEvent) {
+
+	if event == nil {
+		return
+	}
+
+	pub.pubRWLock.RLock()
+	handler := pub.circuitHandler
+	pub.pubRWLock.RUnlock()
+
+	if handler != nil {
+		handler(*event)
+	}
+}
+
+// enterCircuit returns ErrCircuitOpen while the circuit breaker rejects publishing.
+func (pub *Publisher) enterCircuit() error {
+
+	cb := pub.getCircuitBreaker()
+	if cb == nil {
+		return nil
+	}
+
+	event, err := cb.allow(time.Now())
+	pub.emitCircuitEvent(event)
+
+	return err
+}
+
+// awaitCircuit waits until the circuit breaker lets a publish through, or the context is done.
+func (pub *Publisher) awaitCircuit(ctx context.Context) error {
+
+	for {
+		cb := pub.getCircuitBreaker()
+		if cb == nil {
+			return nil
+		}
+
+		event, err := cb.allow(time.Now())
+		pub.emitCircuitEvent(event)
+		if err == nil {
+			return nil
+		}
+
+		retryAt, changed := cb.retry()
+		timer := time.NewTimer(time.Until(retryAt))
+
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("circuit breaker: %w", ctx.Err())
+		}
+	}
+}
+
+// circuitResult counts the outcome of a publish for the circuit breaker, a nil error is a success.
+func (pub *Publisher) circuitResult(err error) {
+
+	if cb := pub.getCircuitBreaker(); cb != nil {
+		pub.emitCircuitEvent(cb.record(err, time.Now()))
+	}
+}
+
+// circuitConfirmation counts a publish confirmation for the circuit breaker. A returned publish made it to the server.
+func (pub *Publisher) circuitConfirmation(confirmation *DeferredConfirmation) {
+
+	switch {
+	case confirmation.Acked() || confirmation.Returned() != nil:
+		pub.circuitResult(nil)
+	case confirmation.Err() != nil:
+		pub.circuitResult(confirmation.Err())
+	default:
+		pub.circuitResult(ErrNacked)
+	}
+}
+
+// getPublishChannel gets a cached channel to publish the letter on, once the circuit breaker and the rate limit allow it.
+// It also returns the letter to publish, a claim checked copy of the letter when its body was offloaded.
+func (pub *Publisher) getPublishChannel(ctx context.Context, letter *Letter, ackable bool) (*ChannelHost, *Letter, error) {
+
+	if err := pub.enterCircuit(); err != nil {
+		return nil, letter, err
+	}
+
+	letter, err := pub.claimCheck(ctx, letter)
+	if err != nil {
+		return nil, letter, err
+	}
+
+	if err := pub.throttle(ctx, letter); err != nil {
+		return nil, letter, err
+	}
+
+	chanHost, err := pub.getChannel(ctx, ackable)
+	if err != nil {
+		pub.circuitResult(err)
+		return nil, letter, err
+	}
+
+	return chanHost, letter, nil
+}
@@ -0,0 +1,267 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// defaultClaimCheckThreshold is the size (in bytes) of the bodies offloaded when the ClaimCheckConfig has no Threshold.
+const defaultClaimCheckThreshold = 1 << 20
+
+// ClaimCheckHeader marks a message whose body was offloaded to a ClaimCheckStore, its value is the reference.
+const ClaimCheckHeader = "x-claim-check"
+
+// ErrClaimCheckNotFound is returned by a ClaimCheckStore when nothing is stored under the reference.
+var ErrClaimCheckNotFound = errors.New("claim check not found")
+
+// ClaimCheckStore keeps the bodies of letters too large to publish, the messages only carry a reference to them.
+type ClaimCheckStore interface {
+	// Store keeps the data under the key (the LetterID and a suffix unique to the body), returning the reference to
+	// retrieve it by.
+	Store(ctx context.Context, key string, data []byte) (reference string, err error)
+
+	// Retrieve returns the data stored under the reference, ErrClaimCheckNotFound when there is none.
+	Retrieve(ctx context.Context, reference string) ([]byte, error)
+
+	// Delete removes the data stored under the reference, deleting it twice is not an error.
+	Delete(ctx context.Context, reference string) error
+}
+
+// FileClaimCheckStore is a ClaimCheckStore keeping each body in a file of a local (or shared) directory.
+type FileClaimCheckStore struct {
+	directory string
+}
+
+// NewFileClaimCheckStore creates a FileClaimCheckStore in the directory, creating the directory if needed.
+// Publishers and consumers on different machines need the directory to be shared.
+func NewFileClaimCheckStore(directory string) (*FileClaimCheckStore, error) {
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("claim check store %w", err)
+	}
+
+	return &FileClaimCheckStore{directory: directory}, nil
+}
+
+// Store writes the data to a file named after the key, the reference is the file name.
+func (fs *FileClaimCheckStore) Store(ctx context.Context, key string, data []byte) (string, error) {
+
+	path, err := fs.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	// Written under a temporary name first, a consumer never reads a partial body.
+	temp, err := os.CreateTemp(fs.directory, ".claimcheck-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Retrieve reads the file of the reference.
+func (fs *FileClaimCheckStore) Retrieve(ctx context.Context, reference string) ([]byte, error) {
+
+	path, err := fs.path(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrClaimCheckNotFound, reference)
+	}
+
+	return data, err
+}
+
+// Delete removes the file of the reference.
+func (fs *FileClaimCheckStore) Delete(ctx context.Context, reference string) error {
+
+	path, err := fs.path(reference)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path keeps references to plain file names, a message can't point the store outside of its directory.
+func (fs *FileClaimCheckStore) path(reference string) (string, error) {
+
+	if reference == "" || reference == "." || reference == ".." ||
+		strings.HasPrefix(reference, ".claimcheck-") || filepath.Base(reference) != reference {
+		return "", fmt.Errorf("claim check reference %q is not valid", reference)
+	}
+
+	return filepath.Join(fs.directory, reference), nil
+}
+
+// SetClaimCheck offloads the body of every letter over the threshold (in bytes) to the store before publishing it,
+// the letter is published with a WrappedBody referencing it instead. A nil store stops offloading.
+func (pub *Publisher) SetClaimCheck(store ClaimCheckStore, threshold int) {
+	pub.pubRWLock.Lock()
+	defer pub.pubRWLock.Unlock()
+	pub.claimCheckStore = store
+	pub.claimCheckThreshold = threshold
+}
+
+func (pub *Publisher) getClaimCheck() (ClaimCheckStore, int) {
+	pub.pubRWLock.RLock()
+	defer pub.pubRWLock.RUnlock()
+	return pub.claimCheckStore, pub.claimCheckThreshold
+}
+
+// claimCheck returns the letter to publish: the letter itself when its body is within the threshold, or else a copy of
+// it (and of its Envelope) with a WrappedBody carrying the reference of the offloaded body, marked with the
+// ClaimCheckHeader. The letter is left as is, and a letter already marked is never offloaded again.
+func (pub *Publisher) claimCheck(ctx context.Context, letter *Letter) (*Letter, error) {
+
+	store, threshold := pub.getClaimCheck()
+	if store == nil || len(letter.Body) <= threshold {
+		return letter, nil
+	}
+
+	if _, ok := letter.Envelope.Headers[ClaimCheckHeader]; ok {
+		return letter, nil
+	}
+
+	// The LetterID is shared by the chunks of a letter (and by its republishes), each body gets a key of its own.
+	key := letter.LetterID.String() + "-" + uuid.New().String()
+	reference, err := store.Store(ctx, key, letter.Body)
+	if err != nil {
+		return letter, fmt.Errorf("unable to claim check LetterID: %s: %w", letter.LetterID.String(), err)
+	}
+
+	body, err := json.Marshal(&WrappedBody{
+		LetterID:   letter.LetterID,
+		Body:       &ModdedBody{UTCDateTime: JSONUtcTimestamp()},
+		ClaimCheck: reference,
+	})
+	if err != nil {
+		return letter, err
+	}
+
+	envelope := *letter.Envelope
+	envelope.Headers = make(amqp.Table, len(letter.Envelope.Headers)+1)
+	for key, value := range letter.Envelope.Headers {
+		envelope.Headers[key] = value
+	}
+	envelope.Headers[ClaimCheckHeader] = reference
+
+	offloaded := *letter
+	offloaded.Body = body
+	offloaded.Envelope = &envelope
+
+	return &offloaded, nil
+}
+
+// SetClaimCheck rehydrates the messages carrying a ClaimCheckHeader, their body is retrieved from the store before the
+// message is handed out. With deleteAfterAck the stored body is deleted once the message is acknowledged (right away
+// when auto acking).
+func (con *Consumer) SetClaimCheck(store ClaimCheckStore, deleteAfterAck bool) {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+	con.claimCheckStore = store
+	con.deleteClaimChecks = deleteAfterAck
+}
+
+func (con *Consumer) getClaimCheck() (ClaimCheckStore, bool) {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+	return con.claimCheckStore, con.deleteClaimChecks
+}
+
+// rehydrate replaces the body of a claim checked message with the one in the store, returning false when it can't.
+// An ackable message that can't be rehydrated is requeued to try again, or rejected when its body is gone for good.
+func (con *Consumer) rehydrate(msg *ReceivedMessage, observer ConsumerObserver) bool {
+
+	store, deleteAfterAck := con.getClaimCheck()
+	if store == nil {
+		return true
+	}
+
+	if _, ok := msg.Delivery.Headers[ClaimCheckHeader]; !ok {
+		return true
+	}
+
+	wrappedBody, err := ReadWrappedBodyFromJSONBytes(msg.Body)
+	if err == nil && wrappedBody.ClaimCheck == "" {
+		err = fmt.Errorf("%w, the body carries no reference", ErrClaimCheckNotFound)
+	}
+	if err != nil {
+		con.claimCheckError(msg, observer, "failed to rehydrate", err)
+		if msg.IsAckable {
+			_ = msg.Reject(false) // it would never rehydrate
+		}
+		return false
+	}
+
+	reference := wrappedBody.ClaimCheck
+	data, err := store.Retrieve(context.Background(), reference)
+	if err != nil {
+		con.claimCheckError(msg, observer, "failed to rehydrate", err)
+		if msg.IsAckable {
+			_ = msg.Nack(!errors.Is(err, ErrClaimCheckNotFound))
+		}
+		return false
+	}
+
+	msg.Body = data
+	msg.Delivery.Body = data
+
+	if !deleteAfterAck {
+		return true
+	}
+
+	deleteClaimCheck := func() {
+		if err := store.Delete(context.Background(), reference); err != nil {
+			con.claimCheckError(msg, observer, "failed to delete the claim check of", err)
+		}
+	}
+
+	if msg.IsAckable {
+		msg.onAck = deleteClaimCheck
+	} else {
+		deleteClaimCheck()
+	}
+
+	return true
+}
+
+func (con *Consumer) claimCheckError(msg *ReceivedMessage, observer ConsumerObserver, action string, err error) {
+
+	err = fmt.Errorf("consumer %s %s MessageID: %s: %w", con.ConsumerName, action, msg.MessageID, err)
+	if observer != nil {
+		observer.ObserveConsumerError(con.ConsumerName, err)
+	}
+	con.errors <- err
+}
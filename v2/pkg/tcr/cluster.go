@@ -0,0 +1,82 @@
+package tcr
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+)
+
+const (
+	// URISelectionRoundRobin spreads connections across the cluster, each connection starting on the next URI.
+	URISelectionRoundRobin = "roundrobin"
+
+	// URISelectionRandom starts each connection attempt on a random URI.
+	URISelectionRandom = "random"
+
+	// URISelectionPreferFirst always starts with the first URI, the rest are only used for failover.
+	URISelectionPreferFirst = "preferfirst"
+)
+
+// uriSelector decides the order in which the nodes of a cluster are dialed.
+// It is shared by every ConnectionHost in a ConnectionPool so round robin is pool wide.
+type uriSelector struct {
+	uris      []string
+	selection string
+	next      uint64
+}
+
+func newURISelector(uris []string, selection string) (*uriSelector, error) {
+
+	if len(uris) == 0 {
+		return nil, errors.New("at least one uri is required")
+	}
+
+	for _, uri := range uris {
+		if uri == "" {
+			return nil, errors.New("uri can't be empty")
+		}
+	}
+
+	switch selection {
+	case "":
+		selection = URISelectionRoundRobin
+	case URISelectionRoundRobin, URISelectionRandom, URISelectionPreferFirst:
+	default:
+		return nil, fmt.Errorf("uri selection %q is not supported", selection)
+	}
+
+	return &uriSelector{
+		uris:      uris,
+		selection: selection,
+	}, nil
+}
+
+// candidates returns every URI in the order they should be dialed.
+// The lost node, when provided, is tried last so reconnects fail over to the next node first.
+func (us *uriSelector) candidates(lost string) []string {
+
+	count := len(us.uris)
+	start := 0
+
+	switch us.selection {
+	case URISelectionRoundRobin:
+		start = int((atomic.AddUint64(&us.next, 1) - 1) % uint64(count))
+	case URISelectionRandom:
+		start = rand.Intn(count)
+	}
+
+	ordered := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		uri := us.uris[(start+i)%count]
+		if uri != lost {
+			ordered = append(ordered, uri)
+		}
+	}
+
+	if len(ordered) < count {
+		ordered = append(ordered, lost)
+	}
+
+	return ordered
+}
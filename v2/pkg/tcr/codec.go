@@ -0,0 +1,62 @@
+package tcr
+
+import (
+	"strings"
+	"sync"
+)
+
+// JSONContentType is the content type of the JSONCodec.
+const JSONContentType = "application/json"
+
+// Codec encodes the values of a TypedPublisher and decodes the ones of a TypedConsumer.
+type Codec interface {
+	ContentType() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// JSONCodec encodes values as JSON, the same way CreatePayload does.
+type JSONCodec struct{}
+
+// ContentType returns application/json.
+func (JSONCodec) ContentType() string {
+	return JSONContentType
+}
+
+// Marshal encodes the value as JSON.
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal decodes the JSON data into the value.
+func (JSONCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+var (
+	codecs    = map[string]Codec{JSONContentType: JSONCodec{}}
+	codecLock = &sync.RWMutex{}
+)
+
+// RegisterCodec makes the codec available by its content type to GetCodec, replacing any codec registered before it.
+func RegisterCodec(codec Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[mediaType(codec.ContentType())] = codec
+}
+
+// GetCodec returns the codec registered for the content type (parameters like charset are ignored), or nil when there is none.
+func GetCodec(contentType string) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecs[mediaType(contentType)]
+}
+
+func mediaType(contentType string) string {
+
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
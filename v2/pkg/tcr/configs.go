@@ -7,26 +7,45 @@ type RabbitSeasoning struct {
 	PoolConfig        *PoolConfig                `json:"PoolConfig" yaml:"PoolConfig"`
 	ConsumerConfigs   map[string]*ConsumerConfig `json:"ConsumerConfigs" yaml:"ConsumerConfigs"`
 	PublisherConfig   *PublisherConfig           `json:"PublisherConfig" yaml:"PublisherConfig"`
+	ClaimCheckConfig  *ClaimCheckConfig          `json:"ClaimCheckConfig" yaml:"ClaimCheckConfig"` // nil for no claim checks
 }
 
 // PoolConfig represents settings for creating/configuring pools.
 type PoolConfig struct {
-	ApplicationName      string     `json:"ApplicationName" yaml:"ApplicationName"`
-	URI                  string     `json:"URI" yaml:"URI"`
-	Heartbeat            uint32     `json:"Heartbeat" yaml:"Heartbeat"`
-	ConnectionTimeout    uint32     `json:"ConnectionTimeout" yaml:"ConnectionTimeout"`
-	SleepOnErrorInterval uint32     `json:"SleepOnErrorInterval" yaml:"SleepOnErrorInterval"` // sleep length on errors
-	MaxConnectionCount   uint64     `json:"MaxConnectionCount" yaml:"MaxConnectionCount"`   // number of connections to create in the pool
-	MaxCacheChannelCount uint64     `json:"MaxCacheChannelCount" yaml:"MaxCacheChannelCount"` // number of channels to be cached in the pool
-	TLSConfig            *TLSConfig `json:"TLSConfig" yaml:"TLSConfig"`            // TLS settings for connection with AMQPS.
+	ApplicationName          string              `json:"ApplicationName" yaml:"ApplicationName"`
+	URI                      string              `json:"URI" yaml:"URI"`
+	URIs                     []string            `json:"URIs" yaml:"URIs"`                 // cluster nodes for failover, takes precedence over URI
+	URISelection             string              `json:"URISelection" yaml:"URISelection"` // roundrobin (default), random, or preferfirst
+	Heartbeat                uint32              `json:"Heartbeat" yaml:"Heartbeat"`
+	ConnectionTimeout        uint32              `json:"ConnectionTimeout" yaml:"ConnectionTimeout"`
+	SleepOnErrorInterval     uint32              `json:"SleepOnErrorInterval" yaml:"SleepOnErrorInterval"`         // sleep length on errors
+	MaxConnectionCount       uint64              `json:"MaxConnectionCount" yaml:"MaxConnectionCount"`             // number of connections to create in the pool
+	MaxCacheChannelCount     uint64              `json:"MaxCacheChannelCount" yaml:"MaxCacheChannelCount"`         // default size of each channel cache when the sizes below are 0
+	MaxAckableChannelCount   uint64              `json:"MaxAckableChannelCount" yaml:"MaxAckableChannelCount"`     // number of confirm mode channels cached in the pool
+	MaxPlainChannelCount     uint64              `json:"MaxPlainChannelCount" yaml:"MaxPlainChannelCount"`         // number of non-confirm channels cached in the pool
+	TLSConfig                *TLSConfig          `json:"TLSConfig" yaml:"TLSConfig"`                               // TLS settings for connection with AMQPS.
+	BackoffPolicy            *BackoffPolicy      `json:"BackoffPolicy" yaml:"BackoffPolicy"`                       // optional, defaults to retrying forever every SleepOnErrorInterval
+	CredentialsProvider      CredentialsProvider `json:"-" yaml:"-"`                                               // optional, consulted on every dial for the username and password (ex. an OAuth2 token)
+	CredentialsRefresh       string              `json:"CredentialsRefresh" yaml:"CredentialsRefresh"`             // recycle (default) or updatesecret, when credentials have an ExpiresAt
+	CredentialsRefreshBefore uint32              `json:"CredentialsRefreshBefore" yaml:"CredentialsRefreshBefore"` // milliseconds before ExpiresAt to refresh, defaults to a tenth of the lifetime
+	Transport                Transport           `json:"-" yaml:"-"`                                               // optional, defaults to streadway/amqp (ex., tcrtest in-memory broker)
 }
 
 // TLSConfig represents settings for configuring TLS.
 type TLSConfig struct {
-	EnableTLS         bool   `json:"EnableTLS" yaml:"EnableTLS"` // Use TLSConfig to create connections with AMQPS uri.
-	PEMCertLocation   string `json:"PEMCertLocation" yaml:"PEMCertLocation"`
-	LocalCertLocation string `json:"LocalCertLocation" yaml:"LocalCertLocation"`
-	CertServerName    string `json:"CertServerName" yaml:"CertServerName"`
+	EnableTLS          bool     `json:"EnableTLS" yaml:"EnableTLS"`                   // Use TLSConfig to create connections with AMQPS uri.
+	PEMCertLocation    string   `json:"PEMCertLocation" yaml:"PEMCertLocation"`       // CA certificate(s) used to verify the server
+	CACertPEM          string   `json:"CACertPEM" yaml:"CACertPEM"`                   // in-memory alternative (or addition) to PEMCertLocation
+	LocalCertLocation  string   `json:"LocalCertLocation" yaml:"LocalCertLocation"`   // legacy, a single PEM file holding both client cert and key
+	ClientCertLocation string   `json:"ClientCertLocation" yaml:"ClientCertLocation"` // client certificate file
+	ClientKeyLocation  string   `json:"ClientKeyLocation" yaml:"ClientKeyLocation"`   // client key file
+	ClientCertPEM      string   `json:"ClientCertPEM" yaml:"ClientCertPEM"`           // in-memory client certificate
+	ClientKeyPEM       string   `json:"ClientKeyPEM" yaml:"ClientKeyPEM"`             // in-memory client key
+	CertServerName     string   `json:"CertServerName" yaml:"CertServerName"`         // ServerName (SNI) to verify, defaults to the host of the URI
+	MinVersion         string   `json:"MinVersion" yaml:"MinVersion"`                 // 1.0, 1.1, 1.2, or 1.3
+	CipherSuites       []string `json:"CipherSuites" yaml:"CipherSuites"`             // ex. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	InsecureSkipVerify bool     `json:"InsecureSkipVerify" yaml:"InsecureSkipVerify"` // development only
+	SASLExternal       bool     `json:"SASLExternal" yaml:"SASLExternal"`             // authenticate with the client certificate instead of the URI credentials
 }
 
 // ConsumerConfig represents settings for configuring a consumer with ease.
@@ -45,11 +64,41 @@ type ConsumerConfig struct {
 
 // PublisherConfig represents settings for configuring global settings for all Publishers with ease.
 type PublisherConfig struct {
-	AutoAck                bool   `json:"AutoAck" yaml:"AutoAck"`
-	SleepOnIdleInterval    uint32 `json:"SleepOnIdleInterval" yaml:"SleepOnIdleInterval"`
-	SleepOnErrorInterval   uint32 `json:"SleepOnErrorInterval" yaml:"SleepOnErrorInterval"`
-	PublishTimeOutInterval uint32 `json:"PublishTimeOutInterval" yaml:"PublishTimeOutInterval"`
-	MaxRetryCount          uint32 `json:"MaxRetryCount" yaml:"MaxRetryCount"`
+	AutoAck                bool                  `json:"AutoAck" yaml:"AutoAck"`
+	SleepOnIdleInterval    uint32                `json:"SleepOnIdleInterval" yaml:"SleepOnIdleInterval"`
+	SleepOnErrorInterval   uint32                `json:"SleepOnErrorInterval" yaml:"SleepOnErrorInterval"`
+	PublishTimeOutInterval uint32                `json:"PublishTimeOutInterval" yaml:"PublishTimeOutInterval"`
+	MaxRetryCount          uint32                `json:"MaxRetryCount" yaml:"MaxRetryCount"`
+	FlowControl            string                `json:"FlowControl" yaml:"FlowControl"`             // wait (default), failfast, or route when the server blocks a connection
+	OutboxDirectory        string                `json:"OutboxDirectory" yaml:"OutboxDirectory"`     // durable outbox for queued letters, empty disables it
+	OutboxSegmentSize      uint32                `json:"OutboxSegmentSize" yaml:"OutboxSegmentSize"` // bytes per outbox segment, 0 for 64MB
+	OutboxSync             bool                  `json:"OutboxSync" yaml:"OutboxSync"`               // fsync every outbox write
+	RateLimit              *RateLimitConfig      `json:"RateLimit" yaml:"RateLimit"`                 // nil for no rate limit
+	CircuitBreaker         *CircuitBreakerConfig `json:"CircuitBreaker" yaml:"CircuitBreaker"`       // nil for no circuit breaker
+	Lanes                  []*LaneConfig         `json:"Lanes" yaml:"Lanes"`                         // auto-publisher lanes, highest priority first, nil for one lane of 1000
+}
+
+// LaneConfig is a priority lane of the auto-publisher, holding the letters queued to it until they are published.
+type LaneConfig struct {
+	Name     string `json:"Name" yaml:"Name"`
+	Capacity uint32 `json:"Capacity" yaml:"Capacity"` // letters the lane holds, 0 for 1000
+	Overflow string `json:"Overflow" yaml:"Overflow"` // block (default), failfast, or dropoldest when the lane is full
+}
+
+// CircuitBreakerConfig opens the circuit of a Publisher after consecutive publish failures (errors, nacks, or confirmation timeouts).
+type CircuitBreakerConfig struct {
+	FailureThreshold uint32 `json:"FailureThreshold" yaml:"FailureThreshold"` // consecutive failures that open the circuit, 0 for 5
+	FailureWindow    uint32 `json:"FailureWindow" yaml:"FailureWindow"`       // milliseconds the consecutive failures have to happen within, 0 for no window
+	OpenInterval     uint32 `json:"OpenInterval" yaml:"OpenInterval"`         // milliseconds the circuit stays open before probing, 0 for 5000
+	HalfOpenProbes   uint32 `json:"HalfOpenProbes" yaml:"HalfOpenProbes"`     // probe publishes that have to succeed to close the circuit, 0 for 1
+}
+
+// RateLimitConfig throttles a Publisher with token buckets, refilled every second up to the limits below.
+type RateLimitConfig struct {
+	MessagesPerSecond uint32 `json:"MessagesPerSecond" yaml:"MessagesPerSecond"` // 0 for no message limit
+	BytesPerSecond    uint64 `json:"BytesPerSecond" yaml:"BytesPerSecond"`       // 0 for no byte limit, counts letter bodies
+	Scope             string `json:"Scope" yaml:"Scope"`                         // global (default), exchange, or routingkey
+	Mode              string `json:"Mode" yaml:"Mode"`                           // wait (default) or failfast
 }
 
 // TopologyConfig allows you to build simple toplogies from a JSON file.
@@ -60,6 +109,14 @@ type TopologyConfig struct {
 	ExchangeBindings []*ExchangeBinding `json:"ExchangeBindings" yaml:"ExchangeBindings"`
 }
 
+// ClaimCheckConfig offloads large bodies to a FileClaimCheckStore, publishing a reference to them instead.
+type ClaimCheckConfig struct {
+	Enabled        bool   `json:"Enabled" yaml:"Enabled"`
+	Directory      string `json:"Directory" yaml:"Directory"`           // where the bodies are kept, shared by publishers and consumers
+	Threshold      int    `json:"Threshold" yaml:"Threshold"`           // bodies over this many bytes are offloaded, 0 for 1MB
+	DeleteAfterAck bool   `json:"DeleteAfterAck" yaml:"DeleteAfterAck"` // consumers delete a body once its message is acked
+}
+
 // CompressionConfig allows you to configuration symmetric key encryption based on options
 type CompressionConfig struct {
 	Enabled bool   `json:"Enabled" yaml:"Enabled"`
@@ -0,0 +1,233 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrConfirmChannelClosed is the error of a DeferredConfirmation whose channel closed before the server confirmed the publish.
+// It wraps ErrChannelClosed.
+var ErrConfirmChannelClosed = fmt.Errorf("%w before the publish was confirmed", ErrChannelClosed)
+
+// DeferredConfirmation resolves once the server acks or nacks a publish made in confirm mode.
+type DeferredConfirmation struct {
+	DeliveryTag uint64
+	messageID   string
+	done        chan struct{}
+	ack         bool
+	err         error
+	returned    *ReturnMessage
+	confirmedAt time.Time
+}
+
+func newDeferredConfirmation(deliveryTag uint64, messageID string) *DeferredConfirmation {
+	return &DeferredConfirmation{DeliveryTag: deliveryTag, messageID: messageID, done: make(chan struct{})}
+}
+
+// Done is closed once the publish has been acked, nacked, or its channel closed.
+func (dc *DeferredConfirmation) Done() <-chan struct{} {
+	return dc.done
+}
+
+// Acked returns true when the server acked the publish without returning it, blocking until Done is closed.
+func (dc *DeferredConfirmation) Acked() bool {
+	<-dc.done
+	return dc.ack
+}
+
+// Err returns ErrUnroutable when the server returned the mandatory publish, or ErrConfirmChannelClosed when the channel
+// closed before the publish was confirmed, blocking until Done is closed.
+func (dc *DeferredConfirmation) Err() error {
+	<-dc.done
+	return dc.err
+}
+
+// Wait blocks until the publish is confirmed, returning whether it was acked, or the context error when the context is done first.
+func (dc *DeferredConfirmation) Wait(ctx context.Context) (bool, error) {
+	select {
+	case <-dc.done:
+		return dc.ack, dc.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Returned returns the ReturnMessage when the server returned the mandatory publish, blocking until Done is closed.
+func (dc *DeferredConfirmation) Returned() *ReturnMessage {
+	<-dc.done
+	return dc.returned
+}
+
+// ConfirmedAt returns when the publish was confirmed (or its channel closed), blocking until Done is closed.
+func (dc *DeferredConfirmation) ConfirmedAt() time.Time {
+	<-dc.done
+	return dc.confirmedAt
+}
+
+func (dc *DeferredConfirmation) resolve(ack bool, err error) {
+	dc.ack = ack
+	dc.err = err
+	dc.confirmedAt = time.Now()
+	close(dc.done)
+}
+
+// confirmTracker hands out delivery tags to publishes on a confirm mode channel and resolves them as confirmations
+// arrive, allowing many publishes to await their confirmations on one channel at once.
+type confirmTracker struct {
+	lock      *sync.Mutex
+	published uint64 // the delivery tag of the last publish
+	pending   map[uint64]*DeferredConfirmation
+	oldest    uint64 // no delivery tag below this one is pending
+	closed    bool
+	returned  func(*ReturnMessage)
+}
+
+// newConfirmTracker starts tracking the confirmations, and returns, of a channel that was just put in confirm mode.
+// Every return is handed to returned once correlated.
+func newConfirmTracker(
+	confirmations <-chan amqp.Confirmation,
+	returns <-chan amqp.Return,
+	returned func(*ReturnMessage)) *confirmTracker {
+
+	ct := &confirmTracker{
+		lock:     &sync.Mutex{},
+		pending:  make(map[uint64]*DeferredConfirmation),
+		oldest:   1,
+		returned: returned,
+	}
+
+	go ct.listen(confirmations, returns)
+
+	return ct
+}
+
+// publish publishes on the channel and registers the delivery tag the server will confirm it with.
+func (ct *confirmTracker) publish(
+	channel AMQPChannel,
+	exchange, routingKey string,
+	mandatory, immediate bool,
+	msg amqp.Publishing) (*DeferredConfirmation, error) {
+
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	if ct.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	// Delivery tags are only used up by publishes that made it out, so publishing holds the lock.
+	if err := channel.Publish(exchange, routingKey, mandatory, immediate, msg); err != nil {
+		return nil, err
+	}
+
+	ct.published++
+	confirmation := newDeferredConfirmation(ct.published, msg.MessageId)
+	ct.pending[ct.published] = confirmation
+
+	return confirmation, nil
+}
+
+func (ct *confirmTracker) listen(confirmations <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+
+	for confirmations != nil || returns != nil {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			ct.correlate(ret)
+
+		case confirmation, ok := <-confirmations:
+			if !ok {
+				confirmations = nil
+				ct.close()
+				continue
+			}
+
+			// The server returns a mandatory publish before acking it, make sure the return is seen first.
+			ct.drainReturns(returns)
+			ct.confirm(confirmation.DeliveryTag, confirmation.Ack)
+		}
+	}
+}
+
+func (ct *confirmTracker) drainReturns(returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			ct.correlate(ret)
+		default:
+			return
+		}
+	}
+}
+
+// correlate attaches a return to the pending publish with the same MessageId, before handing it off.
+func (ct *confirmTracker) correlate(ret amqp.Return) {
+
+	message := NewReturnMessage(&ret)
+
+	ct.lock.Lock()
+	if message.MessageID != "" {
+		for _, pending := range ct.pending {
+			if pending.messageID == message.MessageID && pending.returned == nil {
+				pending.returned = message
+				break
+			}
+		}
+	}
+	ct.lock.Unlock()
+
+	if ct.returned != nil {
+		ct.returned(message)
+	}
+}
+
+// close fails every publish still awaiting its confirmation, the channel is gone.
+func (ct *confirmTracker) close() {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	ct.closed = true
+	for tag, pending := range ct.pending {
+		pending.resolve(false, ErrConfirmChannelClosed)
+		delete(ct.pending, tag)
+	}
+}
+
+// confirm resolves the publish with the delivery tag. Confirmations arrive in delivery tag order, so publishes still
+// pending below the tag were covered by the same (multiple) ack or nack and are resolved with it.
+func (ct *confirmTracker) confirm(deliveryTag uint64, ack bool) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	for tag := ct.oldest; tag <= deliveryTag; tag++ {
+		if pending, ok := ct.pending[tag]; ok {
+			if pending.returned != nil {
+				pending.resolve(false, ErrUnroutable)
+			} else {
+				pending.resolve(ack, nil)
+			}
+			delete(ct.pending, tag)
+		}
+	}
+
+	if deliveryTag >= ct.oldest {
+		ct.oldest = deliveryTag + 1
+	}
+}
+
+// inFlight returns how many publishes are awaiting their confirmation.
+func (ct *confirmTracker) inFlight() int {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	return len(ct.pending)
+}
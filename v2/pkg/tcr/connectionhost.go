@@ -1,6 +1,7 @@
 package tcr
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"sync"
@@ -11,16 +12,29 @@ import (
 
 // ConnectionHost is an internal representation of amqp.Connection.
 type ConnectionHost struct {
-	Connection         *amqp.Connection
+	Connection         *amqp.Connection // nil when the PoolConfig supplies a Transport other than streadway/amqp
 	ConnectionID       uint64
 	CachedChannelCount uint64
+	connection         AMQPConnection // the Transport's connection, Connection is only set for the default Transport
+	transport          Transport
+	uris               *uriSelector
 	uri                string
+	reconnects         uint64
+	blocked            bool
+	unblocked          chan struct{} // closed while the connection is not blocked
+	lastError          error
+	lastErrorTime      time.Time
+	stateLock          *sync.RWMutex
 	connectionName     string
 	heartbeatInterval  time.Duration
 	connectionTimeout  time.Duration
 	tlsConfig          *TLSConfig
+	credentialSource   *credentialSource
+	recycleRequested   AMQPConnection // recycled at its next checkout, its credentials are about to expire
 	Errors             chan *amqp.Error
 	Blockers           chan amqp.Blocking
+	events             func(ConnectionEvent)
+	returns            func(*ReturnMessage)
 	connLock           *sync.Mutex
 }
 
@@ -33,15 +47,102 @@ func NewConnectionHost(
 	connectionTimeout time.Duration,
 	tlsConfig *TLSConfig) (*ConnectionHost, error) {
 
+	return NewConnectionHostWithTransport(
+		uri,
+		connectionName,
+		connectionID,
+		heartbeatInterval,
+		connectionTimeout,
+		tlsConfig,
+		nil)
+}
+
+// NewConnectionHostWithTransport creates a simple ConnectionHost wrapper that dials with the provided Transport.
+// A nil Transport dials RabbitMQ with streadway/amqp.
+func NewConnectionHostWithTransport(
+	uri string,
+	connectionName string,
+	connectionID uint64,
+	heartbeatInterval time.Duration,
+	connectionTimeout time.Duration,
+	tlsConfig *TLSConfig,
+	transport Transport) (*ConnectionHost, error) {
+
+	return NewConnectionHostWithURIs(
+		[]string{uri},
+		URISelectionPreferFirst,
+		connectionName,
+		connectionID,
+		heartbeatInterval,
+		connectionTimeout,
+		tlsConfig,
+		transport)
+}
+
+// NewConnectionHostWithURIs creates a simple ConnectionHost wrapper that fails over between the nodes of a cluster.
+// The uriSelection (ex. URISelectionRoundRobin) decides which node is dialed first.
+func NewConnectionHostWithURIs(
+	uris []string,
+	uriSelection string,
+	connectionName string,
+	connectionID uint64,
+	heartbeatInterval time.Duration,
+	connectionTimeout time.Duration,
+	tlsConfig *TLSConfig,
+	transport Transport) (*ConnectionHost, error) {
+
+	selector, err := newURISelector(uris, uriSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConnectionHost(
+		selector,
+		connectionName,
+		connectionID,
+		heartbeatInterval,
+		connectionTimeout,
+		tlsConfig,
+		transport,
+		nil,
+		nil,
+		nil)
+}
+
+func newConnectionHost(
+	uris *uriSelector,
+	connectionName string,
+	connectionID uint64,
+	heartbeatInterval time.Duration,
+	connectionTimeout time.Duration,
+	tlsConfig *TLSConfig,
+	transport Transport,
+	credentialSource *credentialSource,
+	events func(ConnectionEvent),
+	returns func(*ReturnMessage)) (*ConnectionHost, error) {
+
+	if transport == nil {
+		transport = NewAMQPTransport()
+	}
+
+	unblocked := make(chan struct{})
+	close(unblocked)
+
 	connHost := &ConnectionHost{
-		uri:               uri,
+		transport:         transport,
+		unblocked:         unblocked,
+		uris:              uris,
+		stateLock:         &sync.RWMutex{},
 		connectionName:    connectionName,
 		ConnectionID:      connectionID,
 		heartbeatInterval: heartbeatInterval,
 		connectionTimeout: connectionTimeout,
 		tlsConfig:         tlsConfig,
+		credentialSource:  credentialSource,
 		Errors:            make(chan *amqp.Error, 10),
 		Blockers:          make(chan amqp.Blocking, 10),
+		events:            events,
+		returns:           returns,
 		connLock:          &sync.Mutex{},
 	}
 
@@ -62,7 +163,7 @@ func (ch *ConnectionHost) Connect() bool {
 func (ch *ConnectionHost) ConnectWithErrorHandler(errorHandler func(error)) bool {
 
 	// Compare, Lock, Recompare Strategy
-	if ch.Connection != nil && !ch.Connection.IsClosed() /* <- atomic */ {
+	if conn := ch.currentConnection(); conn != nil && !conn.IsClosed() /* <- atomic */ {
 		return true
 	}
 
@@ -70,21 +171,39 @@ func (ch *ConnectionHost) ConnectWithErrorHandler(errorHandler func(error)) bool
 	defer ch.connLock.Unlock()
 
 	// Recompare, check if an operation is still necessary after acquiring lock.
-	if ch.Connection != nil && !ch.Connection.IsClosed() /* <- atomic */ {
+	conn := ch.currentConnection()
+	if conn != nil && !conn.IsClosed() /* <- atomic */ {
 		return true
 	}
 
-	// Proceed with reconnectivity
-	var amqpConn *amqp.Connection
+	recovered := conn != nil
+	if !ch.dial(errorHandler) {
+		return false
+	}
+
+	if recovered {
+		ch.stateLock.Lock()
+		ch.reconnects++
+		ch.stateLock.Unlock()
+		ch.emit(ConnectionEvent{Type: ConnectionRecovered, ConnectionID: ch.ConnectionID})
+	}
+
+	return true
+}
+
+// dial opens a new connection, failing over through the cluster, and makes it the current connection.
+// The caller holds the connLock.
+func (ch *ConnectionHost) dial(errorHandler func(error)) bool {
+
+	var amqpConn AMQPConnection
 	var actualTLSConfig *tls.Config
 	var err error
 
 	if ch.tlsConfig != nil && ch.tlsConfig.EnableTLS {
 
-		actualTLSConfig, err = CreateTLSConfig(
-			ch.tlsConfig.PEMCertLocation,
-			ch.tlsConfig.LocalCertLocation)
+		actualTLSConfig, err = NewTLSClientConfig(ch.tlsConfig)
 		if err != nil {
+			ch.recordError(err)
 			if errorHandler != nil {
 				errorHandler(err)
 			}
@@ -92,63 +211,175 @@ func (ch *ConnectionHost) ConnectWithErrorHandler(errorHandler func(error)) bool
 		}
 	}
 
-	if actualTLSConfig == nil {
-		amqpConn, err = amqp.DialConfig(ch.uri, amqp.Config{
-			Heartbeat: ch.heartbeatInterval,
-			Dial:      amqp.DefaultDial(ch.connectionTimeout),
-			Properties: amqp.Table{
-				"connection_name": ch.connectionName,
-			},
-		})
-	} else {
-		amqpConn, err = amqp.DialConfig("amqps://"+ch.tlsConfig.CertServerName, amqp.Config{
-			Heartbeat:       ch.heartbeatInterval,
-			Dial:            amqp.DefaultDial(ch.connectionTimeout),
-			TLSClientConfig: actualTLSConfig,
-			Properties: amqp.Table{
-				"connection_name": ch.connectionName,
-			},
-		})
+	config := dialConfig(ch.heartbeatInterval, ch.connectionTimeout, ch.connectionName)
+
+	var credentials *Credentials
+	if ch.credentialSource != nil {
+
+		credentials, err = ch.credentials()
+		if err != nil {
+			ch.recordError(err)
+			if errorHandler != nil {
+				errorHandler(err)
+			}
+			return false
+		}
+
+		config.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: credentials.Username, Password: credentials.Password}}
 	}
-	if err != nil {
-		if errorHandler != nil {
-			errorHandler(err)
+
+	if actualTLSConfig != nil && ch.tlsConfig.SASLExternal {
+		config.SASL = []amqp.Authentication{&externalAuth{}}
+	}
+
+	// Fail over through the cluster, the node we just lost is tried last.
+	var uri string
+	for _, candidate := range ch.uris.candidates(ch.CurrentURI()) {
+
+		if actualTLSConfig == nil {
+			amqpConn, err = ch.transport.Dial(candidate, config)
+		} else {
+			// streadway/amqp fills in the ServerName from the URI, so every node gets its own copy.
+			config.TLSClientConfig = actualTLSConfig.Clone()
+			amqpConn, err = ch.transport.Dial(tlsURI(candidate), config)
+		}
+		if err != nil {
+			ch.recordError(err)
+			if errorHandler != nil {
+				errorHandler(err)
+			}
+			continue
 		}
+
+		uri = candidate
+		break
+	}
+
+	if amqpConn == nil {
 		return false
 	}
 
-	ch.Connection = amqpConn
-	ch.Errors = make(chan *amqp.Error, 10)
-	ch.Blockers = make(chan amqp.Blocking, 10)
+	errs := make(chan *amqp.Error, 10)
+	blockers := make(chan amqp.Blocking, 10)
+	amqpConn.NotifyClose(errs) // errs is closed by streadway/amqp in some scenarios :(
 
-	ch.Connection.NotifyClose(ch.Errors) // ch.Errors is closed by streadway/amqp in some scenarios :(
-	ch.Connection.NotifyBlocked(ch.Blockers)
+	ch.stateLock.Lock()
+	ch.uri = uri
+	ch.connection = amqpConn
+	ch.Connection = unwrapConnection(amqpConn)
+	ch.recycleRequested = nil
+	ch.Errors = errs
+	ch.Blockers = blockers
+	ch.stateLock.Unlock()
+	ch.setBlocked(amqpConn, false)
+
+	// Blocked state is tracked in the background, the watcher forwards to Blockers without ever stalling streadway/amqp.
+	go ch.watch(
+		amqpConn,
+		blockers,
+		amqpConn.NotifyClose(make(chan *amqp.Error, 1)),
+		amqpConn.NotifyBlocked(make(chan amqp.Blocking, 10)))
+
+	if credentials != nil && !credentials.ExpiresAt.IsZero() {
+		go ch.keepCredentialsFresh(amqpConn, credentials.ExpiresAt, amqpConn.NotifyClose(make(chan *amqp.Error, 1)))
+	}
 
 	return true
 }
 
-// PauseOnFlowControl allows you to wait and sleep while receiving flow control messages.
-// Sleeps for one second, repeatedly until the blocking has stopped.
+// currentConnection returns the connection in use, safe to call while a reconnect is swapping it.
+func (ch *ConnectionHost) currentConnection() AMQPConnection {
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+	return ch.connection
+}
+
+// TransportConnection returns the connection in use as dialed by the Transport, works with any Transport unlike Connection.
+func (ch *ConnectionHost) TransportConnection() AMQPConnection {
+	return ch.currentConnection()
+}
+
+// connectionErrors returns the Errors of the current connection, safe to call while a reconnect is swapping them.
+func (ch *ConnectionHost) connectionErrors() chan *amqp.Error {
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+	return ch.Errors
+}
+
+// CurrentURI returns the URI of the cluster node this ConnectionHost is (or was last) connected to.
+func (ch *ConnectionHost) CurrentURI() string {
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+	return ch.uri
+}
+
+// recordError keeps the most recent dial, connection, or channel error for Stats.
+func (ch *ConnectionHost) recordError(err error) {
+	ch.stateLock.Lock()
+	defer ch.stateLock.Unlock()
+	ch.lastError = err
+	ch.lastErrorTime = time.Now()
+}
+
+func (ch *ConnectionHost) getLastError() error {
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+	return ch.lastError
+}
+
+// setBlocked updates the blocked state, unless conn has already been replaced by a reconnect.
+func (ch *ConnectionHost) setBlocked(conn AMQPConnection, blocked bool) {
+	ch.stateLock.Lock()
+	defer ch.stateLock.Unlock()
+
+	if ch.connection != conn || ch.blocked == blocked {
+		return
+	}
+
+	ch.blocked = blocked
+	if blocked {
+		ch.unblocked = make(chan struct{})
+	} else {
+		close(ch.unblocked)
+	}
+}
+
+// IsBlocked returns true while the server has blocked the connection (flow control, ex. a memory alarm).
+func (ch *ConnectionHost) IsBlocked() bool {
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+	return ch.blocked
+}
+
+func (ch *ConnectionHost) emit(event ConnectionEvent) {
+	if ch.events != nil {
+		ch.events(event)
+	}
+}
+
+func (ch *ConnectionHost) emitReturn(message *ReturnMessage) {
+	if ch.returns != nil {
+		ch.returns(message)
+	}
+}
+
+// PauseOnFlowControl allows you to wait while the server has blocked the connection.
 func (ch *ConnectionHost) PauseOnFlowControl() {
+	_ = ch.PauseOnFlowControlContext(context.Background())
+}
 
-	ch.connLock.Lock()
-	defer ch.connLock.Unlock()
+// PauseOnFlowControlContext allows you to wait while the server has blocked the connection, giving up when the context is done.
+// A connection that closes while blocked is no longer considered blocked.
+func (ch *ConnectionHost) PauseOnFlowControlContext(ctx context.Context) error {
 
-	for {
-		// nothing we can do (race condition) Blockers
-		// and will deadlock if it is read from.
-		if ch.Connection.IsClosed( /* atomic */ ) {
-			return
-		}
+	ch.stateLock.RLock()
+	unblocked := ch.unblocked
+	ch.stateLock.RUnlock()
 
-		select {
-		case blocker := <-ch.Blockers: // Check for flow control issues.
-			if !blocker.Active {
-				return
-			}
-			time.Sleep(time.Second)
-		default:
-			return
-		}
+	select {
+	case <-unblocked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
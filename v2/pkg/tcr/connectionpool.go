@@ -1,27 +1,43 @@
 package tcr
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Workiva/go-datastructures/queue"
 	"github.com/streadway/amqp"
 )
 
+// connectionPollInterval is how often a context is checked while waiting on an empty connection queue.
+const connectionPollInterval = 10 * time.Millisecond
+
 // ConnectionPool houses the pool of RabbitMQ connections.
 type ConnectionPool struct {
 	Config               PoolConfig
-	uri                  string
+	uris                 *uriSelector
 	heartbeatInterval    time.Duration
 	connectionTimeout    time.Duration
 	connections          *queue.Queue
-	channels             chan *ChannelHost
+	connectionHosts      []*ConnectionHost
+	ackableChannels      chan *ChannelHost
+	plainChannels        chan *ChannelHost
+	ackableChannelCount  uint64
+	plainChannelCount    uint64
+	channelCheckouts     uint64
+	channelReturns       uint64
+	erroredReturns       uint64
+	channelWaits         *waitSampler
 	connectionID         uint64
 	poolRWLock           *sync.RWMutex
 	flaggedConnections   map[uint64]bool
 	sleepOnErrorInterval time.Duration
+	events               chan ConnectionEvent
+	returns              chan *ReturnMessage
 	errorHandler         func(error)
 	unhealthyHandler     func(error)
 }
@@ -51,83 +67,169 @@ func NewConnectionPoolWithHandlers(config *PoolConfig, errorHandler func(error),
 		return nil, errors.New("connectionpool maxconnectioncount can't be 0")
 	}
 
+	uris := config.URIs
+	if len(uris) == 0 {
+		uris = []string{config.URI}
+	}
+
+	selector, err := newURISelector(uris, config.URISelection)
+	if err != nil {
+		return nil, fmt.Errorf("connectionpool %w", err)
+	}
+
+	if err := validateBackoffPolicy(config.BackoffPolicy); err != nil {
+		return nil, fmt.Errorf("connectionpool %w", err)
+	}
+
+	if err := validateCredentialsRefresh(config.CredentialsRefresh); err != nil {
+		return nil, fmt.Errorf("connectionpool %w", err)
+	}
+
+	ackableChannelCount := config.MaxAckableChannelCount
+	if ackableChannelCount == 0 {
+		ackableChannelCount = config.MaxCacheChannelCount
+	}
+
+	plainChannelCount := config.MaxPlainChannelCount
+	if plainChannelCount == 0 {
+		plainChannelCount = config.MaxCacheChannelCount
+	}
+
 	cp := &ConnectionPool{
 		Config:               *config,
-		uri:                  config.URI,
+		uris:                 selector,
 		heartbeatInterval:    time.Duration(config.Heartbeat) * time.Second,
 		connectionTimeout:    time.Duration(config.ConnectionTimeout) * time.Second,
 		connections:          queue.New(int64(config.MaxConnectionCount)), // possible overflow error
-		channels:             make(chan *ChannelHost, config.MaxCacheChannelCount),
+		ackableChannels:      make(chan *ChannelHost, ackableChannelCount),
+		plainChannels:        make(chan *ChannelHost, plainChannelCount),
+		ackableChannelCount:  ackableChannelCount,
+		plainChannelCount:    plainChannelCount,
 		poolRWLock:           &sync.RWMutex{},
 		flaggedConnections:   make(map[uint64]bool),
+		channelWaits:         newWaitSampler(),
+		events:               make(chan ConnectionEvent, eventBufferSize),
+		returns:              make(chan *ReturnMessage, eventBufferSize),
 		sleepOnErrorInterval: time.Duration(config.SleepOnErrorInterval) * time.Millisecond,
 		errorHandler:         errorHandler,
 		unhealthyHandler:     unhealthyHandler,
 	}
 
-	if ok := cp.initializeConnections(); !ok {
-		return nil, errors.New("initialization failed during connection creation")
+	if err := cp.initializeConnections(); err != nil {
+		return nil, fmt.Errorf("initialization failed during connection creation: %w", err)
 	}
 
 	return cp, nil
 }
 
-func (cp *ConnectionPool) initializeConnections() bool {
+func (cp *ConnectionPool) initializeConnections() error {
 
 	cp.connectionID = 0
 	cp.connections = queue.New(int64(cp.Config.MaxConnectionCount))
+	connectionHosts := make([]*ConnectionHost, 0, cp.Config.MaxConnectionCount)
 
 	for i := uint64(0); i < cp.Config.MaxConnectionCount; i++ {
 
-		connectionHost, err := NewConnectionHost(
-			cp.uri,
+		connectionHost, err := newConnectionHost(
+			cp.uris,
 			cp.Config.ApplicationName+"-"+strconv.FormatUint(cp.connectionID, 10),
 			cp.connectionID,
 			cp.heartbeatInterval,
 			cp.connectionTimeout,
-			cp.Config.TLSConfig)
+			cp.Config.TLSConfig,
+			cp.Config.Transport,
+			newCredentialSource(&cp.Config),
+			cp.emit,
+			cp.emitReturn)
 
 		if err != nil {
 			cp.handleError(err)
-			return false
+			return err
 		}
 
 		if err = cp.connections.Put(connectionHost); err != nil {
 			cp.handleError(err)
-			return false
+			return err
 		}
 
+		connectionHosts = append(connectionHosts, connectionHost)
 		cp.connectionID++
 	}
 
-	for i := uint64(0); i < cp.Config.MaxCacheChannelCount; i++ {
-		cp.channels <- cp.createCacheChannel(i)
+	cp.poolRWLock.Lock()
+	cp.connectionHosts = connectionHosts
+	cp.poolRWLock.Unlock()
+
+	for i := uint64(0); i < cp.ackableChannelCount; i++ {
+		chanHost, err := cp.createCacheChannel(i, true)
+		if err != nil {
+			return err
+		}
+
+		cp.ackableChannels <- chanHost
+	}
+
+	for i := uint64(0); i < cp.plainChannelCount; i++ {
+		chanHost, err := cp.createCacheChannel(cp.ackableChannelCount+i, false)
+		if err != nil {
+			return err
+		}
+
+		cp.plainChannels <- chanHost
 	}
 
-	return true
+	return nil
 }
 
 // GetConnection gets a connection based on whats in the ConnectionPool (blocking under bad network conditions).
 // Flowcontrol (blocking) or transient network outages will pause here until cleared.
-// Uses the SleepOnErrorInterval to pause between retries.
+// Uses the BackoffPolicy (or SleepOnErrorInterval) to pause between retries, returning a *RecoveryExhaustedError
+// if the policy runs out of attempts.
 func (cp *ConnectionPool) GetConnection() (*ConnectionHost, error) {
+	return cp.GetConnectionContext(context.Background())
+}
+
+// GetConnectionContext gets a connection based on whats in the ConnectionPool, giving up when the context is done.
+// Flowcontrol (blocking) or transient network outages will pause here until cleared or the context is done.
+// Returns an *AcquisitionError on cancellation or deadline and a *RecoveryExhaustedError when the BackoffPolicy runs out of attempts.
+func (cp *ConnectionPool) GetConnectionContext(ctx context.Context) (*ConnectionHost, error) {
+
+	connHost, err := cp.getConnectionFromPool(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, newAcquisitionError(ctx, "connection")
+		}
+
+		cp.handleError(err) // errors on bad data in the queue
+		return nil, err
+	}
+
+	if err = cp.verifyHealthyConnection(ctx, connHost); err != nil {
+		cp.ReturnConnection(connHost, true) // let the next caller finish the recovery
+		if ctx.Err() != nil {
+			return nil, newAcquisitionError(ctx, "connection")
+		}
 
-	connHost, err := cp.getConnectionFromPool()
-	if err != nil { // errors on bad data in the queue
-		cp.handleError(err)
 		return nil, err
 	}
 
-	cp.verifyHealthyConnection(connHost)
+	connHost.recycle() // checked out, nobody else is using the ConnectionHost
 
 	return connHost, nil
 }
 
-func (cp *ConnectionPool) getConnectionFromPool() (*ConnectionHost, error) {
+func (cp *ConnectionPool) getConnectionFromPool(ctx context.Context) (*ConnectionHost, error) {
 
-	// Pull from the queue.
-	// Pauses here indefinitely if the queue is empty.
-	structs, err := cp.connections.Get(1)
+	var structs []interface{}
+	var err error
+
+	if ctx.Done() == nil {
+		// Pull from the queue.
+		// Pauses here indefinitely if the queue is empty.
+		structs, err = cp.connections.Get(1)
+	} else {
+		structs, err = cp.pollConnections(ctx)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -140,12 +242,32 @@ func (cp *ConnectionPool) getConnectionFromPool() (*ConnectionHost, error) {
 	return connHost, nil
 }
 
-func (cp *ConnectionPool) verifyHealthyConnection(connHost *ConnectionHost) {
+// pollConnections pulls from the queue, checking the context in between short polls.
+func (cp *ConnectionPool) pollConnections(ctx context.Context) ([]interface{}, error) {
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		structs, err := cp.connections.Poll(1, connectionPollInterval)
+		if err == queue.ErrTimeout {
+			continue
+		}
+
+		return structs, err
+	}
+}
+
+func (cp *ConnectionPool) verifyHealthyConnection(ctx context.Context, connHost *ConnectionHost) error {
 
 	healthy := true
 	select {
-	case err := <-connHost.Errors:
+	case err := <-connHost.connectionErrors(): // closed without an error on a client initiated close
 		healthy = false
+		if err != nil {
+			connHost.recordError(err)
+		}
 		if cp.unhealthyHandler != nil {
 			cp.unhealthyHandler(err)
 		}
@@ -156,21 +278,28 @@ func (cp *ConnectionPool) verifyHealthyConnection(connHost *ConnectionHost) {
 	flagged := cp.isConnectionFlagged(connHost.ConnectionID)
 
 	// Between these three states we do our best to determine that a connection is dead in the various lifecycles.
-	if flagged || !healthy || connHost.Connection.IsClosed( /* atomic */) {
-		cp.triggerConnectionRecovery(connHost)
+	if flagged || !healthy || connHost.currentConnection().IsClosed( /* atomic */) {
+		if err := cp.triggerConnectionRecovery(ctx, connHost); err != nil {
+			return err
+		}
 	}
 
-	connHost.PauseOnFlowControl()
+	return connHost.PauseOnFlowControlContext(ctx)
 }
 
-func (cp *ConnectionPool) triggerConnectionRecovery(connHost *ConnectionHost) {
+func (cp *ConnectionPool) triggerConnectionRecovery(ctx context.Context, connHost *ConnectionHost) error {
 
-	// InfiniteLoop: Stay here till we reconnect.
+	// InfiniteLoop: Stay here till we reconnect, the context is done, or the backoff runs out of attempts.
+	b := cp.newBackoff()
 	for {
 		ok := connHost.ConnectWithErrorHandler(cp.unhealthyHandler)
 		if !ok {
-			if cp.sleepOnErrorInterval > 0 {
-				time.Sleep(cp.sleepOnErrorInterval)
+			delay, retry := b.next()
+			if !retry {
+				return cp.exhausted(b, "connection", connHost.ConnectionID, connHost.getLastError())
+			}
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
 			}
 			continue
 		}
@@ -178,13 +307,18 @@ func (cp *ConnectionPool) triggerConnectionRecovery(connHost *ConnectionHost) {
 	}
 
 	// Flush any pending errors.
+	errs := connHost.connectionErrors()
 	for {
 		select {
-		case <-connHost.Errors:
+		case _, ok := <-errs:
+			if ok {
+				continue
+			}
 		default:
-			cp.unflagConnection(connHost.ConnectionID)
-			return
 		}
+
+		cp.unflagConnection(connHost.ConnectionID)
+		return nil
 	}
 }
 
@@ -199,29 +333,61 @@ func (cp *ConnectionPool) ReturnConnection(connHost *ConnectionHost, flag bool)
 	_ = cp.connections.Put(connHost)
 }
 
-// GetChannelFromPool gets a cached ackable channel from the Pool if they exist or creates a channel.
-// A non-acked channel is always a transient channel.
-// Blocking if Ackable is true and the cache is empty.
-// If you want a transient Ackable channel (un-managed), use CreateChannel directly.
-func (cp *ConnectionPool) GetChannelFromPool() *ChannelHost {
+// GetChannelFromPool gets a cached channel from the Pool, from the ackable (confirm mode) cache if ackable is true,
+// otherwise from the plain cache.
+// Blocking if the cache is empty.
+// If you want a transient channel (un-managed), use GetTransientChannel directly.
+// A channel the BackoffPolicy gives up on is put back in the cache, and it keeps trying until it gets a healthy one.
+func (cp *ConnectionPool) GetChannelFromPool(ackable bool) *ChannelHost {
 
-	return <-cp.channels
+	start := time.Now()
+	for {
+		chanHost := <-cp.channelCache(ackable)
+		if err := cp.refreshStaleChannel(chanHost); err != nil {
+			cp.channelCache(ackable) <- chanHost
+			continue
+		}
+
+		cp.recordCheckout(start)
+		return chanHost
+	}
+}
+
+// GetChannelFromPoolContext gets a cached ackable or plain channel from the Pool, giving up when the context is done.
+// Returns an *AcquisitionError on cancellation or deadline, and a *RecoveryExhaustedError when the BackoffPolicy runs out
+// of attempts rebuilding the channel (it goes back in the cache, broken, to be rebuilt on a later checkout).
+func (cp *ConnectionPool) GetChannelFromPoolContext(ctx context.Context, ackable bool) (*ChannelHost, error) {
+
+	start := time.Now()
+	select {
+	case chanHost := <-cp.channelCache(ackable):
+		if err := cp.refreshStaleChannel(chanHost); err != nil {
+			cp.channelCache(ackable) <- chanHost
+			return nil, err
+		}
+
+		cp.recordCheckout(start)
+		return chanHost, nil
+	case <-ctx.Done():
+		return nil, newAcquisitionError(ctx, "channel")
+	}
 }
 
 // ReturnChannel returns a Channel.
 // If Channel is not a cached channel, it is simply closed here.
 // If Cache Channel, we check if erred, new Channel is created instead and then returned to the cache.
+// When the BackoffPolicy runs out of attempts the channel is returned to the cache flagged as broken, to be rebuilt on its next checkout.
 func (cp *ConnectionPool) ReturnChannel(chanHost *ChannelHost, erred bool) {
 
 	// If called by user with the wrong channel don't add a non-managed channel back to the channel cache.
 	if chanHost.CachedChannel {
+		atomic.AddUint64(&cp.channelReturns, 1)
 		if erred {
-			cp.reconnectChannel(chanHost) // <- blocking operation
-		} else {
-			chanHost.FlushConfirms()
+			atomic.AddUint64(&cp.erroredReturns, 1)
+			_ = cp.reconnectChannel(chanHost) // <- blocking operation
 		}
 
-		cp.channels <- chanHost
+		cp.channelCache(chanHost.Ackable) <- chanHost
 		return
 	}
 
@@ -232,59 +398,142 @@ func (cp *ConnectionPool) ReturnChannel(chanHost *ChannelHost, erred bool) {
 	}(chanHost)
 }
 
-func (cp *ConnectionPool) reconnectChannel(chanHost *ChannelHost) {
+// refreshStaleChannel rebuilds a cached channel whose connection has been replaced, or that was closed or left broken,
+// while it sat in the cache. Returns the *RecoveryExhaustedError when it can't.
+func (cp *ConnectionPool) refreshStaleChannel(chanHost *ChannelHost) error {
+
+	closed, reason := chanHost.closed()
+	if reason != nil { // taken off Errors, it is reported here or nowhere
+		chanHost.connHost.recordError(reason)
+		if cp.unhealthyHandler != nil {
+			cp.unhealthyHandler(reason)
+		}
+	}
+
+	if closed || chanHost.isBroken() || chanHost.stale() {
+		return cp.reconnectChannel(chanHost)
+	}
+
+	return nil
+}
+
+func (cp *ConnectionPool) channelCache(ackable bool) chan *ChannelHost {
+	if ackable {
+		return cp.ackableChannels
+	}
+	return cp.plainChannels
+}
 
-	// InfiniteLoop: Stay here till we reconnect.
+// reconnectChannel rebuilds the channel, flagging it as broken when the backoff runs out of attempts.
+func (cp *ConnectionPool) reconnectChannel(chanHost *ChannelHost) error {
+
+	// InfiniteLoop: Stay here till we reconnect or the backoff runs out of attempts.
+	b := cp.newBackoff()
 	for {
-		cp.verifyHealthyConnection(chanHost.connHost) // <- blocking operation
+		err := cp.verifyHealthyConnection(context.Background(), chanHost.connHost) // <- blocking operation
+		if err == nil {
+			err = chanHost.MakeChannel() // Creates a new channel and flushes internal buffers automatically.
+		}
 
-		err := chanHost.MakeChannel() // Creates a new channel and flushes internal buffers automatically.
 		if err != nil {
-			cp.handleError(err)
+			if err = cp.retryAfter(context.Background(), b, "channel", chanHost.ConnectionID, err); err != nil {
+				chanHost.setBroken(true)
+				return err
+			}
 			continue
 		}
 		break
 	}
+
+	chanHost.setBroken(false)
+	cp.emit(ConnectionEvent{Type: ChannelRecovered, ConnectionID: chanHost.ConnectionID, ChannelID: chanHost.ID})
+	return nil
 }
 
 // createCacheChannel allows you create a cached ChannelHost which helps wrap Amqp Channel functionality.
-func (cp *ConnectionPool) createCacheChannel(id uint64) *ChannelHost {
+func (cp *ConnectionPool) createCacheChannel(id uint64, ackable bool) (*ChannelHost, error) {
 
-	// InfiniteLoop: Stay till we have a good channel.
+	// InfiniteLoop: Stay till we have a good channel or the backoff runs out of attempts.
+	b := cp.newBackoff()
 	for {
 		connHost, err := cp.GetConnection()
 		if err != nil {
-			cp.handleError(err)
+			var exhaustedErr *RecoveryExhaustedError
+			if errors.As(err, &exhaustedErr) {
+				return nil, err
+			}
+			if err = cp.retryAfter(context.Background(), b, "channel", 0, err); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
-		chanHost, err := NewChannelHost(connHost, id, connHost.ConnectionID, true, true)
+		chanHost, err := NewChannelHost(connHost, id, connHost.ConnectionID, ackable, true)
 		if err != nil {
-			cp.handleError(err)
 			cp.ReturnConnection(connHost, true)
+			if err = cp.retryAfter(context.Background(), b, "channel", connHost.ConnectionID, err); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
+		atomic.AddUint64(&connHost.CachedChannelCount, 1)
 		cp.ReturnConnection(connHost, false)
-		return chanHost
+		return chanHost, nil
 	}
 }
 
+func (cp *ConnectionPool) recordCheckout(start time.Time) {
+	atomic.AddUint64(&cp.channelCheckouts, 1)
+	cp.channelWaits.record(time.Since(start))
+}
+
 // GetTransientChannel allows you create an unmanaged amqp Channel with the help of the ConnectionPool.
+// Returns nil if a BackoffPolicy with MaxAttempts gives up, or when the PoolConfig supplies a Transport other than
+// streadway/amqp, use GetTransientChannelContext to get the error or a channel of any Transport.
 func (cp *ConnectionPool) GetTransientChannel(ackable bool) *amqp.Channel {
 
-	// InfiniteLoop: Stay till we have a good channel.
+	channel, err := cp.GetTransientChannelContext(context.Background(), ackable)
+	if err != nil {
+		return nil // the BackoffPolicy ran out of attempts
+	}
+
+	amqpChannel := unwrapChannel(channel)
+	if amqpChannel == nil {
+		channel.Close()
+	}
+
+	return amqpChannel
+}
+
+// GetTransientChannelContext allows you create an unmanaged amqp Channel with the help of the ConnectionPool, giving up when the context is done.
+// Returns an *AcquisitionError on cancellation or deadline and a *RecoveryExhaustedError when the BackoffPolicy runs out of attempts.
+func (cp *ConnectionPool) GetTransientChannelContext(ctx context.Context, ackable bool) (AMQPChannel, error) {
+
+	// InfiniteLoop: Stay till we have a good channel, the context is done, or the backoff runs out of attempts.
+	b := cp.newBackoff()
 	for {
-		connHost, err := cp.GetConnection()
+		connHost, err := cp.GetConnectionContext(ctx)
 		if err != nil {
-			cp.handleError(err)
+			var exhaustedErr *RecoveryExhaustedError
+			if errors.As(err, &exhaustedErr) {
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				return nil, newAcquisitionError(ctx, "transient channel")
+			}
+			if err = cp.retryAfter(ctx, b, "transient channel", 0, err); err != nil {
+				return nil, cp.transientChannelError(ctx, err)
+			}
 			continue
 		}
 
-		channel, err := connHost.Connection.Channel()
+		channel, err := connHost.currentConnection().Channel()
 		if err != nil {
-			cp.handleError(err)
 			cp.ReturnConnection(connHost, true)
+			if err = cp.retryAfter(ctx, b, "transient channel", connHost.ConnectionID, err); err != nil {
+				return nil, cp.transientChannelError(ctx, err)
+			}
 			continue
 		}
 
@@ -293,12 +542,22 @@ func (cp *ConnectionPool) GetTransientChannel(ackable bool) *amqp.Channel {
 		if ackable {
 			err := channel.Confirm(false)
 			if err != nil {
-				cp.handleError(err)
+				_ = channel.Close()
+				if err = cp.retryAfter(ctx, b, "transient channel", connHost.ConnectionID, err); err != nil {
+					return nil, cp.transientChannelError(ctx, err)
+				}
 				continue
 			}
 		}
-		return channel
+		return channel, nil
+	}
+}
+
+func (cp *ConnectionPool) transientChannelError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return newAcquisitionError(ctx, "transient channel")
 	}
+	return err
 }
 
 // UnflagConnection flags that connection as usable in the future.
@@ -334,21 +593,23 @@ func (cp *ConnectionPool) Shutdown() {
 	}
 
 	wg := &sync.WaitGroup{}
-ChannelFlushLoop:
-	for {
-		select {
-		case chanHost := <-cp.channels:
-			wg.Add(1)
-			// Started receiving panics on Channel.Close()
-			go func(*ChannelHost) {
-				defer wg.Done()
-				defer func() { _ = recover() }()
-
-				chanHost.Close()
-			}(chanHost)
-
-		default:
-			break ChannelFlushLoop
+	for _, channels := range []chan *ChannelHost{cp.ackableChannels, cp.plainChannels} {
+	ChannelFlushLoop:
+		for {
+			select {
+			case chanHost := <-channels:
+				wg.Add(1)
+				// Started receiving panics on Channel.Close()
+				go func(*ChannelHost) {
+					defer wg.Done()
+					defer func() { _ = recover() }()
+
+					chanHost.Close()
+				}(chanHost)
+
+			default:
+				break ChannelFlushLoop
+			}
 		}
 	}
 	wg.Wait()
@@ -366,8 +627,8 @@ ChannelFlushLoop:
 				defer wg.Done()
 				defer func() { _ = recover() }()
 
-				if !connectionHost.Connection.IsClosed() {
-					connectionHost.Connection.Close()
+				if conn := connectionHost.currentConnection(); !conn.IsClosed() {
+					conn.Close()
 				}
 			}(connectionHost)
 
@@ -377,7 +638,9 @@ ChannelFlushLoop:
 	wg.Wait()
 
 	cp.connections = queue.New(int64(cp.Config.MaxConnectionCount))
-	cp.flaggedConnections = make(map[uint64]bool)
+	cp.poolRWLock.Lock()
+	cp.flaggedConnections = make(map[uint64]bool) // consumers may still be recovering their channels
+	cp.poolRWLock.Unlock()
 	cp.connectionID = 0
 }
 
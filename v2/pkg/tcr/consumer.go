@@ -1,11 +1,13 @@
 package tcr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 )
 
@@ -22,7 +24,11 @@ type Consumer struct {
 	messageGroup         *sync.WaitGroup
 	receivedMessages     chan *ReceivedMessage
 	consumeStop          chan bool
+	consumeDone          chan struct{}   // closed when the consume loop exits
+	handlers             *sync.WaitGroup // ackable messages handed out and not yet settled
 	stopImmediate        bool
+	stopGraceful         bool
+	stopDone             <-chan struct{} // done when a graceful stop gives up on the deliveries still on their way
 	started              bool
 	autoAck              bool
 	exclusive            bool
@@ -30,6 +36,9 @@ type Consumer struct {
 	args                 amqp.Table
 	qosCountOverride     int
 	conLock              *sync.Mutex
+	observer             ConsumerObserver
+	claimCheckStore      ClaimCheckStore
+	deleteClaimChecks    bool
 }
 
 // NewConsumerFromConfig creates a new Consumer to receive messages from a specific queuename.
@@ -47,6 +56,7 @@ func NewConsumerFromConfig(config *ConsumerConfig, cp *ConnectionPool) *Consumer
 		messageGroup:         &sync.WaitGroup{},
 		receivedMessages:     make(chan *ReceivedMessage, 1000),
 		consumeStop:          make(chan bool, 1),
+		handlers:             &sync.WaitGroup{},
 		autoAck:              config.AutoAck,
 		exclusive:            config.Exclusive,
 		noWait:               config.NoWait,
@@ -88,6 +98,7 @@ func NewConsumer(
 		messageGroup:         &sync.WaitGroup{},
 		receivedMessages:     make(chan *ReceivedMessage, 1000),
 		consumeStop:          make(chan bool, 1),
+		handlers:             &sync.WaitGroup{},
 		stopImmediate:        false,
 		started:              false,
 		autoAck:              autoAck,
@@ -101,9 +112,17 @@ func NewConsumer(
 
 // Get gets a single message from any queue. Auto-Acknowledges.
 func (con *Consumer) Get(queueName string) (*amqp.Delivery, error) {
+	return con.GetContext(context.Background(), queueName)
+}
+
+// GetContext gets a single message from any queue, giving up on acquiring a channel when the context is done. Auto-Acknowledges.
+func (con *Consumer) GetContext(ctx context.Context, queueName string) (*amqp.Delivery, error) {
 
 	// Get Channel
-	channel := con.ConnectionPool.GetTransientChannel(false)
+	channel, err := con.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
 	defer channel.Close()
 
 	// Get Single Message
@@ -121,13 +140,21 @@ func (con *Consumer) Get(queueName string) (*amqp.Delivery, error) {
 
 // GetBatch gets a group of messages from any queue. Auto-Acknowledges.
 func (con *Consumer) GetBatch(queueName string, batchSize int) ([]*amqp.Delivery, error) {
+	return con.GetBatchContext(context.Background(), queueName, batchSize)
+}
+
+// GetBatchContext gets a group of messages from any queue, giving up on acquiring a channel when the context is done. Auto-Acknowledges.
+func (con *Consumer) GetBatchContext(ctx context.Context, queueName string, batchSize int) ([]*amqp.Delivery, error) {
 
 	if batchSize < 1 {
 		return nil, errors.New("can't get a batch of messages whose size is less than 1")
 	}
 
 	// Get Channel
-	channel := con.ConnectionPool.GetTransientChannel(false)
+	channel, err := con.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
 	defer channel.Close()
 
 	messages := make([]*amqp.Delivery, 0)
@@ -165,6 +192,7 @@ func (con *Consumer) StartConsuming() {
 		con.FlushErrors()
 		con.FlushStop()
 
+		con.consumeDone = make(chan struct{})
 		go con.startConsumeLoop(nil)
 		con.started = true
 	}
@@ -180,12 +208,14 @@ func (con *Consumer) StartConsumingWithAction(action func(*ReceivedMessage)) {
 		con.FlushErrors()
 		con.FlushStop()
 
+		con.consumeDone = make(chan struct{})
 		go con.startConsumeLoop(action)
 		con.started = true
 	}
 }
 
 func (con *Consumer) startConsumeLoop(action func(*ReceivedMessage)) {
+	defer close(con.consumeDone)
 
 ConsumeLoop:
 	for {
@@ -200,15 +230,20 @@ ConsumeLoop:
 		}
 
 		// Get ChannelHost
-		chanHost := con.ConnectionPool.GetChannelFromPool()
+		chanHost := con.ConnectionPool.GetChannelFromPool(false)
 
 		// Configure RabbitMQ channel QoS for Consumer
 		if con.qosCountOverride > 0 {
-			_ = chanHost.Channel.Qos(con.qosCountOverride, 0, false)
+			_ = chanHost.channel.Qos(con.qosCountOverride, 0, false)
+		}
+
+		// Initiate consuming process, with a tag of our own when none is configured so the consumer can be cancelled.
+		consumerTag := con.ConsumerName
+		if consumerTag == "" {
+			consumerTag = "tcr-" + uuid.New().String()
 		}
 
-		// Initiate consuming process.
-		deliveryChan, err := chanHost.Channel.Consume(con.QueueName, con.ConsumerName, con.autoAck, con.exclusive, false, con.noWait, nil)
+		deliveryChan, err := chanHost.channel.Consume(con.QueueName, consumerTag, con.autoAck, con.exclusive, false, con.noWait, nil)
 		if err != nil {
 			con.ConnectionPool.ReturnChannel(chanHost, true)
 			if con.sleepOnErrorInterval > 0 {
@@ -218,7 +253,7 @@ ConsumeLoop:
 		}
 
 		// Process delivered messages by the consumer, returns true when we are to stop all consuming.
-		if con.processDeliveries(deliveryChan, chanHost, action) {
+		if con.processDeliveries(deliveryChan, consumerTag, chanHost, action) {
 			break ConsumeLoop
 		}
 	}
@@ -234,20 +269,31 @@ ConsumeLoop:
 	con.conLock.Lock()
 	con.started = false
 	con.stopImmediate = false
+	con.stopGraceful = false
+	con.stopDone = nil
 	con.conLock.Unlock()
 }
 
 // ProcessDeliveries is the inner loop for processing the deliveries and returns true to break outer loop.
-func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHost *ChannelHost, action func(*ReceivedMessage)) bool {
+func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, consumerTag string, chanHost *ChannelHost, action func(*ReceivedMessage)) bool {
+
+	observer := con.getObserver()
 
 	for {
 		// Listen for channel closure (close errors).
 		// Highest priority so separated to it's own select.
 		select {
-		case errorMessage := <-chanHost.Errors:
-			if errorMessage != nil {
+		case errorMessage, ok := <-chanHost.Errors:
+			if errorMessage != nil || !ok { // closed without an error when the connection is closed by the client
 				con.ConnectionPool.ReturnChannel(chanHost, true)
-				con.errors <- fmt.Errorf("consumer's current channel closed\r\n[reason: %s]\r\n[code: %d]", errorMessage.Reason, errorMessage.Code)
+				err := errors.New("consumer's current channel closed")
+				if errorMessage != nil {
+					err = fmt.Errorf("consumer's current channel closed\r\n[reason: %s]\r\n[code: %d]", errorMessage.Reason, errorMessage.Code)
+				}
+				if observer != nil {
+					observer.ObserveConsumerError(con.ConsumerName, err)
+				}
+				con.errors <- err
 				if con.sleepOnErrorInterval > 0 {
 					time.Sleep(con.sleepOnErrorInterval)
 				}
@@ -259,17 +305,12 @@ func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHo
 
 		// Convert amqp.Delivery into our internal struct for later use.
 		select {
-		case delivery := <-deliveryChan: // all buffered deliveries are wiped on a channel close error
-
-			msg := NewReceivedMessage(
-				!con.autoAck,
-				delivery)
-
-			if action != nil {
-				action(msg)
-			} else {
-				con.receivedMessages <- msg
+		case delivery, ok := <-deliveryChan: // all buffered deliveries are wiped on a channel close error
+			if !ok {
+				deliveryChan = nil // closed along with the channel, never hand out its zero value deliveries
+				break
 			}
+			con.handleDelivery(delivery, action, observer, nil)
 
 		default:
 			if con.sleepOnIdleInterval > 0 {
@@ -282,6 +323,29 @@ func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHo
 		select {
 		case stop := <-con.consumeStop:
 			if stop {
+				// Stop the server from delivering more, a graceful stop still hands out what is already on the way.
+				cancelErr := chanHost.channel.Cancel(consumerTag, false)
+
+				con.conLock.Lock()
+				graceful, stopDone := con.stopGraceful, con.stopDone
+				con.conLock.Unlock()
+
+				// Without the cancel the deliveries never end, don't wait on them.
+				if graceful && cancelErr == nil && deliveryChan != nil {
+				DrainLoop:
+					for {
+						select {
+						case delivery, ok := <-deliveryChan:
+							if !ok {
+								break DrainLoop
+							}
+							con.handleDelivery(delivery, action, observer, stopDone)
+						case <-stopDone:
+							break DrainLoop
+						}
+					}
+				}
+
 				con.ConnectionPool.ReturnChannel(chanHost, false)
 				return true
 			}
@@ -291,6 +355,47 @@ func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHo
 	}
 }
 
+// handleDelivery converts an amqp.Delivery into a ReceivedMessage and hands it to the action or ReceivedMessages.
+// A message that can't be handed to ReceivedMessages before done is requeued.
+func (con *Consumer) handleDelivery(delivery amqp.Delivery, action func(*ReceivedMessage), observer ConsumerObserver, done <-chan struct{}) {
+
+	msg := NewReceivedMessage(
+		!con.autoAck,
+		delivery)
+
+	if msg.IsAckable {
+		con.handlers.Add(1)
+		msg.onSettle = con.handlers.Done
+		msg.settleOnce = &sync.Once{}
+	}
+
+	if observer != nil {
+		observer.ObserveDelivery(con.ConsumerName)
+		msg.consumerName = con.ConsumerName
+		msg.observer = observer
+	}
+
+	if !con.rehydrate(msg, observer) {
+		return
+	}
+
+	if action != nil {
+		start := time.Now()
+		action(msg)
+		if observer != nil {
+			observer.ObserveHandler(con.ConsumerName, time.Since(start))
+		}
+	} else {
+		select {
+		case con.receivedMessages <- msg:
+		case <-done:
+			if msg.IsAckable {
+				_ = msg.Nack(true)
+			}
+		}
+	}
+}
+
 // StopConsuming allows you to signal stop to the consumer.
 // Will stop on the consumer channelclose or responding to signal after getting all remaining deviveries.
 // FlushMessages empties the internal buffer of messages received by queue. Ackable messages are still in
@@ -357,7 +462,8 @@ func (con *Consumer) FlushMessages() {
 FlushLoop:
 	for {
 		select {
-		case <-con.receivedMessages:
+		case msg := <-con.receivedMessages:
+			msg.settle() // dropped, nobody is going to ack it
 		default:
 			break FlushLoop
 		}
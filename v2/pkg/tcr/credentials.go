@@ -0,0 +1,227 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// CredentialsRefreshRecycle redials a connection with fresh credentials before the current ones expire.
+	CredentialsRefreshRecycle = "recycle"
+
+	// CredentialsRefreshUpdateSecret sends fresh credentials on the open connection (connection.update-secret),
+	// connections that don't implement SecretUpdater are recycled instead.
+	CredentialsRefreshUpdateSecret = "updatesecret"
+)
+
+// Credentials are the username and password (or OAuth2 token) a connection authenticates with.
+type Credentials struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time // zero never expires
+}
+
+// CredentialsProvider supplies the Credentials for a connection, it is consulted on every dial.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// SecretUpdater is implemented by AMQPConnections that can replace their secret without reconnecting.
+// streadway/amqp does not support connection.update-secret, so those connections are always recycled.
+type SecretUpdater interface {
+	UpdateSecret(newSecret, reason string) error
+}
+
+// NewStaticCredentialsProvider creates a CredentialsProvider that always returns the same username and password.
+func NewStaticCredentialsProvider(username, password string) CredentialsProvider {
+	return &staticCredentialsProvider{credentials: Credentials{Username: username, Password: password}}
+}
+
+type staticCredentialsProvider struct {
+	credentials Credentials
+}
+
+// Credentials returns a copy of the static credentials.
+func (scp *staticCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	credentials := scp.credentials
+	return &credentials, nil
+}
+
+// NewFileCredentialsProvider creates a CredentialsProvider that reads the username and password from files on every dial
+// (ex. a mounted secret rotated by a sidecar). Surrounding whitespace is trimmed.
+// With a ttl above zero the credentials expire ttl after they are read, so open connections pick up rotated files.
+func NewFileCredentialsProvider(usernameLocation, passwordLocation string, ttl time.Duration) CredentialsProvider {
+	return &fileCredentialsProvider{
+		usernameLocation: usernameLocation,
+		passwordLocation: passwordLocation,
+		ttl:              ttl,
+	}
+}
+
+type fileCredentialsProvider struct {
+	usernameLocation string
+	passwordLocation string
+	ttl              time.Duration
+}
+
+// Credentials reads the username and password files.
+func (fcp *fileCredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+
+	username, err := ioutil.ReadFile(fcp.usernameLocation)
+	if err != nil {
+		return nil, fmt.Errorf("credentials username: %w", err)
+	}
+
+	password, err := ioutil.ReadFile(fcp.passwordLocation)
+	if err != nil {
+		return nil, fmt.Errorf("credentials password: %w", err)
+	}
+
+	credentials := &Credentials{
+		Username: strings.TrimSpace(string(username)),
+		Password: strings.TrimSpace(string(password)),
+	}
+	if fcp.ttl > 0 {
+		credentials.ExpiresAt = time.Now().Add(fcp.ttl)
+	}
+
+	return credentials, nil
+}
+
+func validateCredentialsRefresh(refresh string) error {
+
+	switch refresh {
+	case "", CredentialsRefreshRecycle, CredentialsRefreshUpdateSecret:
+		return nil
+	default:
+		return fmt.Errorf("credentials refresh %q is not supported", refresh)
+	}
+}
+
+// credentialSource is how a ConnectionHost gets, and keeps fresh, the credentials it dials with.
+type credentialSource struct {
+	provider CredentialsProvider
+	refresh  string
+	before   time.Duration // zero refreshes after 90% of the lifetime
+}
+
+func newCredentialSource(config *PoolConfig) *credentialSource {
+
+	if config.CredentialsProvider == nil {
+		return nil
+	}
+
+	return &credentialSource{
+		provider: config.CredentialsProvider,
+		refresh:  config.CredentialsRefresh,
+		before:   time.Duration(config.CredentialsRefreshBefore) * time.Millisecond,
+	}
+}
+
+// credentials asks the provider for credentials, giving up after the connection timeout.
+func (ch *ConnectionHost) credentials() (*Credentials, error) {
+
+	ctx := context.Background()
+	if ch.connectionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ch.connectionTimeout)
+		defer cancel()
+	}
+
+	credentials, err := ch.credentialSource.provider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("credentials provider: %w", err)
+	}
+
+	return credentials, nil
+}
+
+// refreshAt returns when credentials obtained now should be refreshed.
+func (cs *credentialSource) refreshAt(expiresAt time.Time) time.Time {
+
+	if cs.before > 0 {
+		return expiresAt.Add(-cs.before)
+	}
+
+	return expiresAt.Add(-time.Until(expiresAt) / 10)
+}
+
+// keepCredentialsFresh refreshes the credentials of conn before they expire, until conn is closed or replaced.
+func (ch *ConnectionHost) keepCredentialsFresh(conn AMQPConnection, expiresAt time.Time, closes chan *amqp.Error) {
+
+	for {
+		timer := time.NewTimer(time.Until(ch.credentialSource.refreshAt(expiresAt)))
+		select {
+		case <-closes:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		updater, ok := conn.(SecretUpdater)
+		if ch.credentialSource.refresh != CredentialsRefreshUpdateSecret || !ok {
+			ch.requestRecycle(conn)
+			return
+		}
+
+		credentials, err := ch.credentials()
+		if err == nil {
+			err = updater.UpdateSecret(credentials.Password, "credentials refresh")
+		}
+		if err != nil {
+			ch.recordError(err)
+			ch.requestRecycle(conn)
+			return
+		}
+
+		ch.emit(ConnectionEvent{Type: CredentialsRefreshed, ConnectionID: ch.ConnectionID, Reason: CredentialsRefreshUpdateSecret})
+		if credentials.ExpiresAt.IsZero() {
+			return
+		}
+		expiresAt = credentials.ExpiresAt
+	}
+}
+
+// requestRecycle marks conn to be recycled the next time its ConnectionHost is checked out of the ConnectionPool.
+// The connection is never swapped from under the callers using it.
+func (ch *ConnectionHost) requestRecycle(conn AMQPConnection) {
+	ch.stateLock.Lock()
+	defer ch.stateLock.Unlock()
+
+	if ch.connection == conn {
+		ch.recycleRequested = conn
+	}
+}
+
+// recycle replaces a connection marked by requestRecycle with a freshly dialed connection and then closes it.
+// Called by the ConnectionPool on checkout, cached channels left on the old connection are rebuilt when checked out.
+func (ch *ConnectionHost) recycle() {
+
+	ch.stateLock.Lock()
+	conn := ch.recycleRequested
+	ch.recycleRequested = nil
+	ch.stateLock.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	ch.connLock.Lock()
+	defer ch.connLock.Unlock()
+
+	if ch.currentConnection() != conn || conn.IsClosed() {
+		return // lost (and recovered) or shut down in the meantime
+	}
+
+	if !ch.dial(nil) {
+		return // conn stays up on the old credentials, it is redialed when the server closes it
+	}
+
+	_ = conn.Close()
+	ch.emit(ConnectionEvent{Type: CredentialsRefreshed, ConnectionID: ch.ConnectionID, Reason: CredentialsRefreshRecycle})
+}
@@ -0,0 +1,115 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrConnectionBlocked is returned by a Publisher using FlowControlFailFast when the server has blocked the connection.
+var ErrConnectionBlocked = errors.New("connection is blocked by the server")
+
+// ErrCircuitOpen is returned while the circuit breaker of a Publisher is open after consecutive publish failures.
+var ErrCircuitOpen = errors.New("publisher circuit breaker is open")
+
+// ErrRateLimited is returned by a Publisher using RateLimitFailFast when publishing would go over its rate limit.
+var ErrRateLimited = errors.New("publish is over the rate limit")
+
+// ErrConfirmTimeout is returned when the server didn't confirm a publish before the publish timeout (or the context) ran out.
+var ErrConfirmTimeout = errors.New("publish confirmation wasn't received in a timely manner")
+
+// ErrNacked is returned when the server nacked a publish and it wasn't (or could no longer be) republished.
+var ErrNacked = errors.New("publish was nacked by the server")
+
+// ErrChannelClosed is matched by errors.Is when the channel a publish was made on closed, see ChannelError.
+var ErrChannelClosed = errors.New("channel closed")
+
+// ErrShutdown is returned when publishing or queueing a letter was given up on because of a shutdown.
+var ErrShutdown = errors.New("shutdown triggered")
+
+// ErrQueueFull is returned when a lane of the auto-publisher has no room for a letter, and fails the receipts of letters dropped to make room.
+var ErrQueueFull = errors.New("publisher queue is full")
+
+// ErrUnroutable is the error of a mandatory publish the server returned because no queue was bound to receive it.
+var ErrUnroutable = errors.New("mandatory publish was returned unroutable by the server")
+
+// ErrTransactionUnknown is returned when a transaction commit got no answer (ex. the connection dropped mid-commit),
+// its letters may or may not have been published.
+var ErrTransactionUnknown = errors.New("transaction outcome is unknown")
+
+// ErrUnconfirmed is the error of a letter a batch published, but was cut short before its confirmation came in,
+// it may or may not have reached the server.
+var ErrUnconfirmed = errors.New("publish was cut short before its confirmation")
+
+// AcquisitionError is returned when a connection or channel could not be acquired from the ConnectionPool before the context was done.
+// It unwraps to the context error, so errors.Is(err, context.DeadlineExceeded) works as expected.
+type AcquisitionError struct {
+	Resource string // connection, channel, or transient channel
+	Err      error  // context.Canceled or context.DeadlineExceeded
+}
+
+func (ae *AcquisitionError) Error() string {
+	return fmt.Sprintf("unable to acquire %s from connectionpool: %s", ae.Resource, ae.Err)
+}
+
+// Unwrap returns the context error.
+func (ae *AcquisitionError) Unwrap() error {
+	return ae.Err
+}
+
+func newAcquisitionError(ctx context.Context, resource string) error {
+	return &AcquisitionError{Resource: resource, Err: ctx.Err()}
+}
+
+// ChannelError is returned when publishing on a channel failed, identifying the channel the letter was published on.
+// It unwraps to the error of the channel, and errors.Is(err, ErrChannelClosed) works as expected.
+type ChannelError struct {
+	ConnectionID uint64
+	ChannelID    uint64 // 0 for a transient channel
+	Err          error
+}
+
+func (ce *ChannelError) Error() string {
+	return fmt.Sprintf("publish on channel %d of connection %d failed: %s", ce.ChannelID, ce.ConnectionID, ce.Err)
+}
+
+// Unwrap returns the error of the channel.
+func (ce *ChannelError) Unwrap() error {
+	return ce.Err
+}
+
+// Is reports whether the target is ErrChannelClosed.
+func (ce *ChannelError) Is(target error) bool {
+	return target == ErrChannelClosed
+}
+
+func newChannelError(chanHost *ChannelHost, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	channelError := &ChannelError{Err: err}
+	if chanHost != nil {
+		channelError.ConnectionID = chanHost.ConnectionID
+		channelError.ChannelID = chanHost.ID
+	}
+
+	return channelError
+}
+
+func newConfirmTimeoutError(letter *Letter) error {
+	return fmt.Errorf("%w for LetterID: %s - recommend retry/requeue", ErrConfirmTimeout, letter.LetterID.String())
+}
+
+func newNackedError(letter *Letter) error {
+	return fmt.Errorf("%w for LetterID: %s", ErrNacked, letter.LetterID.String())
+}
+
+func newShutdownError(letter *Letter) error {
+	return fmt.Errorf("stopped publishing LetterID: %s as %w", letter.LetterID.String(), ErrShutdown)
+}
+
+func newUnroutableError(letter *Letter, returned *ReturnMessage) error {
+	return fmt.Errorf("publish of LetterID: %s was returned [%d %s]: %w", letter.LetterID.String(), returned.ReplyCode, returned.ReplyText, ErrUnroutable)
+}
@@ -0,0 +1,111 @@
+package tcr
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// eventBufferSize is how many ConnectionEvents are kept for a slow reader before new ones are dropped.
+const eventBufferSize = 1000
+
+// ConnectionEventType identifies what happened in a ConnectionEvent.
+type ConnectionEventType string
+
+const (
+	// ConnectionLost is emitted when the server or network closes a connection.
+	ConnectionLost ConnectionEventType = "ConnectionLost"
+
+	// ConnectionRecovered is emitted when a lost connection has been dialed again.
+	ConnectionRecovered ConnectionEventType = "ConnectionRecovered"
+
+	// ConnectionBlocked is emitted when the server blocks a connection (ex. a memory or disk alarm).
+	ConnectionBlocked ConnectionEventType = "ConnectionBlocked"
+
+	// ConnectionUnblocked is emitted when the server lifts a block.
+	ConnectionUnblocked ConnectionEventType = "ConnectionUnblocked"
+
+	// ChannelRecovered is emitted when an erred cached channel has been rebuilt.
+	ChannelRecovered ConnectionEventType = "ChannelRecovered"
+
+	// RecoveryFailed is emitted when a recovery loop runs out of BackoffPolicy attempts.
+	RecoveryFailed ConnectionEventType = "RecoveryFailed"
+
+	// CredentialsRefreshed is emitted when a connection has been recycled, or has had its secret updated, before its credentials expired.
+	CredentialsRefreshed ConnectionEventType = "CredentialsRefreshed"
+)
+
+// ConnectionEvent describes a change in the lifecycle of a connection or cached channel in the ConnectionPool.
+type ConnectionEvent struct {
+	Type         ConnectionEventType
+	ConnectionID uint64
+	ChannelID    uint64 // ChannelRecovered only
+	Reason       string // the alarm for ConnectionBlocked, the CredentialsRefresh mode for CredentialsRefreshed
+	Err          error  // the *amqp.Error from the server when there is one, a *RecoveryExhaustedError for RecoveryFailed
+	Time         time.Time
+}
+
+// Events returns the ConnectionEvents of the ConnectionPool.
+// Events are buffered, when nobody keeps up with the buffer new events are dropped instead of stalling the pool.
+func (cp *ConnectionPool) Events() <-chan ConnectionEvent {
+	return cp.events
+}
+
+func (cp *ConnectionPool) emit(event ConnectionEvent) {
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	select {
+	case cp.events <- event:
+	default:
+	}
+}
+
+// Returns returns the mandatory publishes the server returned as unroutable, from every channel of the ConnectionPool.
+// Returns are buffered, when nobody keeps up with the buffer new returns are dropped instead of stalling the channels.
+func (cp *ConnectionPool) Returns() <-chan *ReturnMessage {
+	return cp.returns
+}
+
+func (cp *ConnectionPool) emitReturn(message *ReturnMessage) {
+	select {
+	case cp.returns <- message:
+	default:
+	}
+}
+
+// watch tracks the blocked state of a single connection and emits its ConnectionEvents until it is closed.
+// Blockings are forwarded to the ConnectionHost Blockers when there is room.
+func (ch *ConnectionHost) watch(conn AMQPConnection, forward chan amqp.Blocking, closes chan *amqp.Error, blockers chan amqp.Blocking) {
+
+	for {
+		select {
+		case err, ok := <-closes:
+			ch.setBlocked(conn, false) // wakes anyone waiting on a connection that is gone
+			if ok && err != nil {      // no error on a client initiated close
+				ch.emit(ConnectionEvent{Type: ConnectionLost, ConnectionID: ch.ConnectionID, Err: err})
+			}
+			return
+
+		case blocker, ok := <-blockers:
+			if !ok {
+				blockers = nil // closed with the connection, wait on closes
+				continue
+			}
+
+			ch.setBlocked(conn, blocker.Active)
+			select {
+			case forward <- blocker:
+			default:
+			}
+
+			if blocker.Active {
+				ch.emit(ConnectionEvent{Type: ConnectionBlocked, ConnectionID: ch.ConnectionID, Reason: blocker.Reason})
+			} else {
+				ch.emit(ConnectionEvent{Type: ConnectionUnblocked, ConnectionID: ch.ConnectionID})
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// FlowControlWait waits for a blocked connection to be unblocked before publishing (default).
+	FlowControlWait = "wait"
+
+	// FlowControlFailFast returns ErrConnectionBlocked instead of publishing on a blocked connection.
+	FlowControlFailFast = "failfast"
+
+	// FlowControlRoute publishes on a cached channel of an unblocked connection, waiting only when every connection is blocked.
+	FlowControlRoute = "route"
+)
+
+func validateFlowControl(flowControl string) error {
+
+	switch flowControl {
+	case "", FlowControlWait, FlowControlFailFast, FlowControlRoute:
+		return nil
+	default:
+		return fmt.Errorf("flow control %q is not supported", flowControl)
+	}
+}
+
+// SetFlowControl decides what the Publisher does when the server blocks a connection (ex. FlowControlFailFast).
+func (pub *Publisher) SetFlowControl(flowControl string) error {
+
+	if err := validateFlowControl(flowControl); err != nil {
+		return err
+	}
+
+	pub.pubRWLock.Lock()
+	defer pub.pubRWLock.Unlock()
+	pub.flowControl = flowControl
+
+	return nil
+}
+
+func (pub *Publisher) getFlowControl() string {
+	pub.pubRWLock.RLock()
+	defer pub.pubRWLock.RUnlock()
+	return pub.flowControl
+}
+
+// getChannel gets a cached channel from the ConnectionPool, applying the flow control of the Publisher when its connection is blocked.
+func (pub *Publisher) getChannel(ctx context.Context, ackable bool) (*ChannelHost, error) {
+
+	chanHost, err := pub.ConnectionPool.GetChannelFromPoolContext(ctx, ackable)
+	if err != nil {
+		return nil, err
+	}
+
+	if !chanHost.connHost.IsBlocked() {
+		return chanHost, nil
+	}
+
+	switch pub.getFlowControl() {
+	case FlowControlFailFast:
+		pub.ConnectionPool.ReturnChannel(chanHost, false)
+		return nil, ErrConnectionBlocked
+
+	case FlowControlRoute:
+		if routed := pub.ConnectionPool.tryUnblockedChannel(ackable); routed != nil {
+			pub.ConnectionPool.ReturnChannel(chanHost, false)
+			return routed, nil
+		}
+	}
+
+	if err := chanHost.connHost.PauseOnFlowControlContext(ctx); err != nil {
+		pub.ConnectionPool.ReturnChannel(chanHost, false)
+		return nil, newAcquisitionError(ctx, "unblocked channel")
+	}
+
+	return chanHost, nil
+}
+
+// tryUnblockedChannel takes a cached channel on an unblocked connection without waiting, nil when none is available.
+func (cp *ConnectionPool) tryUnblockedChannel(ackable bool) *ChannelHost {
+
+	channels := cp.channelCache(ackable)
+	blocked := make([]*ChannelHost, 0, cap(channels))
+	defer func() {
+		for _, chanHost := range blocked {
+			channels <- chanHost
+		}
+	}()
+
+	for i := 0; i < cap(channels); i++ {
+		select {
+		case chanHost := <-channels:
+			if !chanHost.connHost.IsBlocked() {
+				return chanHost
+			}
+			blocked = append(blocked, chanHost)
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
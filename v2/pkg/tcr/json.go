@@ -87,8 +87,17 @@ func CreatePayload(
 		return nil, err
 	}
 
+	return modifyPayload(data, compression, encryption)
+}
+
+// modifyPayload optionally compresses and then encrypts the data, the reverse of ReadPayload.
+func modifyPayload(
+	data []byte,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) ([]byte, error) {
+
 	buffer := &bytes.Buffer{}
-	if compression.Enabled {
+	if compression != nil && compression.Enabled {
 		err := handleCompression(compression, data, buffer)
 		if err != nil {
 			return nil, err
@@ -98,7 +107,7 @@ func CreatePayload(
 		data = buffer.Bytes()
 	}
 
-	if encryption.Enabled {
+	if encryption != nil && encryption.Enabled {
 		err := handleEncryption(encryption, data, buffer)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,198 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// OverflowBlock waits for room in a full lane, until the context is done or the Publisher is shut down (default).
+	OverflowBlock = "block"
+
+	// OverflowFailFast returns ErrQueueFull instead of waiting for room in a full lane.
+	OverflowFailFast = "failfast"
+
+	// OverflowDropOldest makes room in a full lane by dropping its oldest letter, failing its PublishReceipt with ErrQueueFull.
+	OverflowDropOldest = "dropoldest"
+
+	defaultLaneCapacity = 1000
+)
+
+func validateLanes(lanes []*LaneConfig) error {
+
+	names := make(map[string]bool, len(lanes))
+	for _, lane := range lanes {
+		if lane == nil {
+			return fmt.Errorf("lane can't be nil")
+		}
+
+		if names[lane.Name] {
+			return fmt.Errorf("lane %q is configured more than once", lane.Name)
+		}
+		names[lane.Name] = true
+
+		switch lane.Overflow {
+		case "", OverflowBlock, OverflowFailFast, OverflowDropOldest:
+		default:
+			return fmt.Errorf("lane %q overflow %q is not supported", lane.Name, lane.Overflow)
+		}
+	}
+
+	return nil
+}
+
+// publishLane holds queued letters awaiting the auto-publisher.
+type publishLane struct {
+	name     string
+	overflow string
+	letters  chan *Letter
+}
+
+// newPublishLanes creates the lanes in priority order, a single lane of 1000 letters that blocks when there are none.
+func newPublishLanes(configs []*LaneConfig) []*publishLane {
+
+	if len(configs) == 0 {
+		return []*publishLane{{overflow: OverflowBlock, letters: make(chan *Letter, defaultLaneCapacity)}}
+	}
+
+	lanes := make([]*publishLane, 0, len(configs))
+	for _, config := range configs {
+		capacity := int(config.Capacity)
+		if capacity == 0 {
+			capacity = defaultLaneCapacity
+		}
+
+		lanes = append(lanes, &publishLane{
+			name:     config.Name,
+			overflow: config.Overflow,
+			letters:  make(chan *Letter, capacity),
+		})
+	}
+
+	return lanes
+}
+
+// defaultLane is the lowest priority lane, the one QueueLetter uses.
+func (pub *Publisher) defaultLane() *publishLane {
+	return pub.lanes[len(pub.lanes)-1]
+}
+
+// QueueLetterContext queues up a letter in the lowest priority lane, see QueueLetterToLane.
+func (pub *Publisher) QueueLetterContext(ctx context.Context, letter *Letter) error {
+	return pub.queueLetter(ctx, pub.defaultLane(), letter)
+}
+
+// QueueLetterToLane queues up a letter in the named lane, letters of higher priority lanes are auto-published first.
+// When the lane is full its overflow decides: OverflowBlock waits until the context is done, OverflowFailFast returns
+// ErrQueueFull, and OverflowDropOldest drops the oldest letter of the lane to make room.
+func (pub *Publisher) QueueLetterToLane(ctx context.Context, laneName string, letter *Letter) error {
+
+	for _, lane := range pub.lanes {
+		if lane.name == laneName {
+			return pub.queueLetter(ctx, lane, letter)
+		}
+	}
+
+	return fmt.Errorf("lane %q was not found", laneName)
+}
+
+// queueLetter queues the letter in the lane unless the Publisher has been shut down, writing it to the Outbox first (if any).
+func (pub *Publisher) queueLetter(ctx context.Context, lane *publishLane, letter *Letter) error {
+
+	letter, err := pub.claimCheck(ctx, letter) // the claim checked copy is queued (and written to the Outbox)
+	if err != nil {
+		return err
+	}
+
+	pub.queueLock.RLock()
+	if pub.queueClosed {
+		pub.queueLock.RUnlock()
+		return fmt.Errorf("unable to queue LetterID: %s as %w", letter.LetterID.String(), ErrShutdown)
+	}
+
+	outbox := pub.outbox
+	if outbox != nil {
+		if err := outbox.append(letter); err != nil {
+			pub.queueLock.RUnlock()
+			return err
+		}
+	}
+
+	atomic.AddInt64(&pub.queued, 1)
+	pub.queueLock.RUnlock()
+
+	err = pub.pushLetter(ctx, lane, letter)
+	if err != nil {
+		atomic.AddInt64(&pub.queued, -1)
+		if outbox != nil {
+			_ = outbox.done(letter.LetterID) // the caller has it back
+		}
+	}
+
+	return err
+}
+
+func (pub *Publisher) pushLetter(ctx context.Context, lane *publishLane, letter *Letter) error {
+
+	switch lane.overflow {
+	case OverflowFailFast:
+		select {
+		case lane.letters <- letter:
+			return nil
+		default:
+			return fmt.Errorf("%w, lane %q has no room for LetterID: %s", ErrQueueFull, lane.name, letter.LetterID.String())
+		}
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case lane.letters <- letter:
+				return nil
+			default:
+			}
+
+			select {
+			case oldest := <-lane.letters:
+				pub.dropLetter(lane, oldest)
+			default: // the auto-publisher just made room
+			}
+		}
+
+	default:
+		select {
+		case lane.letters <- letter:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("queue LetterID: %s: %w", letter.LetterID.String(), ctx.Err())
+		case <-pub.queueClosing:
+			return fmt.Errorf("unable to queue LetterID: %s as %w", letter.LetterID.String(), ErrShutdown)
+		}
+	}
+}
+
+// dropLetter gives up on a queued letter to make room in its lane.
+func (pub *Publisher) dropLetter(lane *publishLane, letter *Letter) {
+
+	atomic.AddInt64(&pub.queued, -1)
+	if outbox := pub.getOutbox(); outbox != nil {
+		_ = outbox.done(letter.LetterID)
+	}
+
+	err := fmt.Errorf("%w, LetterID: %s was dropped from lane %q to make room", ErrQueueFull, letter.LetterID.String(), lane.name)
+	pub.publishReceipt(newPublishReceipt(letter), letter, err)
+}
+
+// nextLetter takes a letter from the highest priority lane that has one, nil when every lane is empty.
+func (pub *Publisher) nextLetter() *Letter {
+
+	for _, lane := range pub.lanes {
+		select {
+		case letter := <-lane.letters:
+			return letter
+		default:
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package tcr
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 )
@@ -13,18 +15,25 @@ type Letter struct {
 	Envelope   *Envelope
 }
 
-// Envelope contains all the address details of where a letter is going.
+// Envelope contains all the address details of where a letter is going, and the properties it is published with.
 type Envelope struct {
-	Exchange      string
-	RoutingKey    string
-	ContentType   string
-	CorrelationID string
-	Type          string
-	Mandatory     bool
-	Immediate     bool
-	Headers       amqp.Table
-	DeliveryMode  uint8
-	Priority      uint8
+	Exchange        string
+	RoutingKey      string
+	ContentType     string
+	ContentEncoding string
+	CorrelationID   string
+	ReplyTo         string
+	Expiration      string // per message TTL in milliseconds, ex. "60000"
+	MessageID       string // overrides the LetterID as the MessageId
+	Timestamp       time.Time
+	Type            string
+	UserID          string // has to match the user of the connection, the server rejects the publish otherwise
+	AppID           string // overrides the ApplicationName of the ConnectionPool as the AppId
+	Mandatory       bool
+	Immediate       bool
+	Headers         amqp.Table
+	DeliveryMode    uint8
+	Priority        uint8
 }
 
 // WrappedBody is to go inside a Letter struct with indications of the body of data being modified (ex., compressed).
@@ -32,6 +41,7 @@ type WrappedBody struct {
 	LetterID       uuid.UUID   `json:"LetterID"`
 	Body           *ModdedBody `json:"Body"`
 	LetterMetadata string      `json:"LetterMetadata"`
+	ClaimCheck     string      `json:"ClaimCheck,omitempty"` // the reference of a body offloaded to a ClaimCheckStore
 }
 
 // ModdedBody is a payload with modifications and indicators of what was modified.
@@ -43,3 +53,39 @@ type ModdedBody struct {
 	UTCDateTime string `json:"UTCDateTime"`
 	Data        []byte `json:"Data"`
 }
+
+// newPublishing converts the letter into the amqp.Publishing every publish sends. The MessageId, Timestamp, and AppId
+// the Envelope leaves empty are the LetterID, now, and the application name.
+func newPublishing(letter *Letter, applicationName string) amqp.Publishing {
+
+	envelope := letter.Envelope
+
+	publishing := amqp.Publishing{
+		Headers:         envelope.Headers,
+		ContentType:     envelope.ContentType,
+		ContentEncoding: envelope.ContentEncoding,
+		DeliveryMode:    envelope.DeliveryMode,
+		Priority:        envelope.Priority,
+		CorrelationId:   envelope.CorrelationID,
+		ReplyTo:         envelope.ReplyTo,
+		Expiration:      envelope.Expiration,
+		MessageId:       envelope.MessageID,
+		Timestamp:       envelope.Timestamp,
+		Type:            envelope.Type,
+		UserId:          envelope.UserID,
+		AppId:           envelope.AppID,
+		Body:            letter.Body,
+	}
+
+	if publishing.MessageId == "" {
+		publishing.MessageId = letter.LetterID.String()
+	}
+	if publishing.Timestamp.IsZero() {
+		publishing.Timestamp = time.Now().UTC()
+	}
+	if publishing.AppId == "" {
+		publishing.AppId = applicationName
+	}
+
+	return publishing
+}
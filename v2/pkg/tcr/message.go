@@ -3,6 +3,8 @@ package tcr
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,11 +12,52 @@ import (
 )
 
 // PublishReceipt is a way to monitor publishing success and to initiate a retry when using async publishing.
+// Use errors.Is on the Error to tell ErrConfirmTimeout, ErrNacked, ErrChannelClosed, ErrUnroutable, and ErrShutdown apart.
 type PublishReceipt struct {
-	LetterID     uuid.UUID
-	FailedLetter *Letter
-	Success      bool
-	Error        error
+	LetterID       uuid.UUID
+	FailedLetter   *Letter
+	Success        bool
+	Error          error
+	Attempts       int           // publishes made, republishes included
+	ConnectionID   uint64        // the connection of the last publish
+	ChannelID      uint64        // the channel of the last publish, 0 for a transient channel
+	PublishedAt    time.Time     // when the last publish was made, zero when nothing was published
+	ConfirmedAt    time.Time     // when the server confirmed the last publish, zero without a confirmation
+	ConfirmLatency time.Duration // ConfirmedAt minus PublishedAt
+}
+
+func newPublishReceipt(letter *Letter) *PublishReceipt {
+	return &PublishReceipt{LetterID: letter.LetterID}
+}
+
+// attempt records a publish of the letter on the channel, nil for a transient channel.
+func (not *PublishReceipt) attempt(chanHost *ChannelHost) {
+
+	not.Attempts++
+	not.ConnectionID, not.ChannelID = 0, 0
+	if chanHost != nil {
+		not.ConnectionID, not.ChannelID = chanHost.ConnectionID, chanHost.ID
+	}
+
+	not.PublishedAt = time.Now()
+	not.ConfirmedAt = time.Time{}
+	not.ConfirmLatency = 0
+}
+
+// confirmed records when the server confirmed the last publish.
+func (not *PublishReceipt) confirmed(confirmedAt time.Time) {
+	not.ConfirmedAt = confirmedAt
+	not.ConfirmLatency = confirmedAt.Sub(not.PublishedAt)
+}
+
+// finish records the outcome of publishing the letter.
+func (not *PublishReceipt) finish(letter *Letter, err error) {
+
+	not.Error = err
+	not.Success = err == nil
+	if err != nil {
+		not.FailedLetter = letter
+	}
 }
 
 // ToString allows you to quickly log the PublishReceipt struct as a string.
@@ -34,6 +77,11 @@ type ReceivedMessage struct {
 	ApplicationID string
 	PublishDate   string
 	Delivery      amqp.Delivery // Access everything.
+	consumerName  string
+	observer      ConsumerObserver
+	onSettle      func() // tells the Consumer the message has been acked, nacked, or rejected
+	onAck         func() // runs once the message has been acked, deletes its claim check
+	settleOnce    *sync.Once
 }
 
 // NewReceivedMessage creates a new ReceivedMessage.
@@ -63,7 +111,16 @@ func (msg *ReceivedMessage) Acknowledge() error {
 		return errors.New("can't acknowledge, internal channel is nil")
 	}
 
-	return msg.Delivery.Acknowledger.Ack(msg.Delivery.DeliveryTag, false)
+	err := msg.Delivery.Acknowledger.Ack(msg.Delivery.DeliveryTag, false)
+	msg.settle()
+	if err == nil && msg.onAck != nil {
+		msg.onAck()
+	}
+	if msg.observer != nil {
+		msg.observer.ObserveAck(msg.consumerName, err)
+	}
+
+	return err
 }
 
 // Nack allows for you to negative acknowledge message on the original channel it was received.
@@ -77,7 +134,13 @@ func (msg *ReceivedMessage) Nack(requeue bool) error {
 		return errors.New("can't nack, internal channel is nil")
 	}
 
-	return msg.Delivery.Acknowledger.Nack(msg.Delivery.DeliveryTag, false, requeue)
+	err := msg.Delivery.Acknowledger.Nack(msg.Delivery.DeliveryTag, false, requeue)
+	msg.settle()
+	if msg.observer != nil {
+		msg.observer.ObserveNack(msg.consumerName, requeue, err)
+	}
+
+	return err
 }
 
 // Reject allows for you to reject on the original channel it was received.
@@ -91,7 +154,80 @@ func (msg *ReceivedMessage) Reject(requeue bool) error {
 		return errors.New("can't reject, internal channel is nil")
 	}
 
-	return msg.Delivery.Acknowledger.Reject(msg.Delivery.DeliveryTag, requeue)
+	err := msg.Delivery.Acknowledger.Reject(msg.Delivery.DeliveryTag, requeue)
+	msg.settle()
+	if msg.observer != nil {
+		msg.observer.ObserveReject(msg.consumerName, requeue, err)
+	}
+
+	return err
+}
+
+func (msg *ReceivedMessage) settle() {
+	if msg.onSettle != nil {
+		msg.settleOnce.Do(msg.onSettle)
+	}
+}
+
+// ContentType returns the MIME content type of the message.
+func (msg *ReceivedMessage) ContentType() string {
+	return msg.Delivery.ContentType
+}
+
+// ContentEncoding returns the MIME content encoding of the message.
+func (msg *ReceivedMessage) ContentEncoding() string {
+	return msg.Delivery.ContentEncoding
+}
+
+// Headers returns the application or exchange specific headers of the message.
+func (msg *ReceivedMessage) Headers() amqp.Table {
+	return msg.Delivery.Headers
+}
+
+// DeliveryMode returns amqp.Persistent or amqp.Transient (0 when the publisher left it unset).
+func (msg *ReceivedMessage) DeliveryMode() uint8 {
+	return msg.Delivery.DeliveryMode
+}
+
+// Priority returns the priority of the message, 0 to 9.
+func (msg *ReceivedMessage) Priority() uint8 {
+	return msg.Delivery.Priority
+}
+
+// CorrelationID returns the correlation identifier of the message.
+func (msg *ReceivedMessage) CorrelationID() string {
+	return msg.Delivery.CorrelationId
+}
+
+// ReplyTo returns the address to reply to the message at.
+func (msg *ReceivedMessage) ReplyTo() string {
+	return msg.Delivery.ReplyTo
+}
+
+// Expiration returns the per message TTL the message was published with, 0 when it has none or it isn't a number of milliseconds.
+func (msg *ReceivedMessage) Expiration() time.Duration {
+
+	milliseconds, err := strconv.ParseInt(msg.Delivery.Expiration, 10, 64)
+	if err != nil || milliseconds < 0 {
+		return 0
+	}
+
+	return time.Duration(milliseconds) * time.Millisecond
+}
+
+// Timestamp returns when the message was published.
+func (msg *ReceivedMessage) Timestamp() time.Time {
+	return msg.Delivery.Timestamp
+}
+
+// Type returns the type name of the message.
+func (msg *ReceivedMessage) Type() string {
+	return msg.Delivery.Type
+}
+
+// UserID returns the user the message was published with (validated by the server).
+func (msg *ReceivedMessage) UserID() string {
+	return msg.Delivery.UserId
 }
 
 // ErrorMessage allow for you to replay a message that was returned.
@@ -164,6 +300,7 @@ func NewReturnMessage(amqpReturn *amqp.Return) *ReturnMessage {
 		Type:            amqpReturn.Type,
 		UserID:          amqpReturn.UserId,
 		AppID:           amqpReturn.AppId,
+		Body:            amqpReturn.Body,
 	}
 }
 
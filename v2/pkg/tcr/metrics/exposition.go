@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency histogram upper bounds in seconds, matching the Prometheus client defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   *sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		lock:   &sync.Mutex{},
+		values: make(map[string]*counterValue),
+	}
+}
+
+func (cv *counterVec) inc(labelValues ...string) {
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	value, ok := cv.values[key]
+	if !ok {
+		value = &counterValue{labelValues: labelValues}
+		cv.values[key] = value
+	}
+	value.value++
+}
+
+func (cv *counterVec) write(w io.Writer) {
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+
+	writeHeader(w, cv.name, cv.help, "counter")
+	for _, key := range sortedKeys(cv.values) {
+		value := cv.values[key]
+		fmt.Fprintf(w, "%s%s %d\n", cv.name, formatLabels(cv.labels, value.labelValues), value.value)
+	}
+}
+
+// histogramVec is a cumulative histogram partitioned by label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    *sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: sorted,
+		lock:    &sync.Mutex{},
+		values:  make(map[string]*histogramValue),
+	}
+}
+
+func (hv *histogramVec) observe(duration time.Duration, labelValues ...string) {
+	hv.lock.Lock()
+	defer hv.lock.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	value, ok := hv.values[key]
+	if !ok {
+		value = &histogramValue{labelValues: labelValues, counts: make([]uint64, len(hv.buckets))}
+		hv.values[key] = value
+	}
+
+	seconds := duration.Seconds()
+	for i, upperBound := range hv.buckets {
+		if seconds <= upperBound {
+			value.counts[i]++
+			break
+		}
+	}
+	value.count++
+	value.sum += seconds
+}
+
+func (hv *histogramVec) write(w io.Writer) {
+	hv.lock.Lock()
+	defer hv.lock.Unlock()
+
+	writeHeader(w, hv.name, hv.help, "histogram")
+	for _, key := range sortedKeys(hv.values) {
+		value := hv.values[key]
+
+		labelNames := append(append([]string{}, hv.labels...), "le")
+		cumulative := uint64(0)
+		for i, upperBound := range hv.buckets {
+			cumulative += value.counts[i]
+			labelValues := append(append([]string{}, value.labelValues...), formatFloat(upperBound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(labelNames, labelValues), cumulative)
+		}
+
+		labelValues := append(append([]string{}, value.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.name, formatLabels(labelNames, labelValues), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.name, formatLabels(hv.labels, value.labelValues), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.name, formatLabels(hv.labels, value.labelValues), value.count)
+	}
+}
+
+// sample is a single scraped value, used for metrics read from a ConnectionPool at scrape time.
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func writeSamples(w io.Writer, name, help, metricType string, labels []string, samples []sample) {
+
+	writeHeader(w, name, help, metricType)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+func formatLabels(names, values []string) string {
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(values[i]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+
+	return builder.String()
+}
+
+func formatFloat(value float64) string {
+
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
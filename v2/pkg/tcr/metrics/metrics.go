@@ -0,0 +1,298 @@
+// Package metrics instruments a ConnectionPool, Publishers, and Consumers and exposes them in the
+// Prometheus text exposition format through an http.Handler, without depending on the Prometheus client.
+//
+//	collector := metrics.NewCollector("")
+//	collector.InstrumentRabbitService(rabbitService)
+//	http.Handle("/metrics", collector)
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+)
+
+// DefaultNamespace prefixes every metric name when no namespace is provided.
+const DefaultNamespace = "tcr"
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector implements tcr.PublisherObserver and tcr.ConsumerObserver, and serves everything it has observed,
+// plus the Stats of every instrumented ConnectionPool, as Prometheus metrics.
+type Collector struct {
+	namespace string
+	lock      *sync.RWMutex
+	pools     []*tcr.ConnectionPool
+
+	publishes         *counterVec
+	publishRetries    *counterVec
+	confirmations     *counterVec
+	confirmTimeouts   *counterVec
+	confirmLatency    *histogramVec
+	deliveries        *counterVec
+	acknowledgements  *counterVec
+	handlerDuration   *histogramVec
+	consumerErrors    *counterVec
+	acknowledgeErrors *counterVec
+}
+
+// NewCollector creates a Collector whose metric names are prefixed by namespace (DefaultNamespace if empty).
+func NewCollector(namespace string) *Collector {
+
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return &Collector{
+		namespace: namespace,
+		lock:      &sync.RWMutex{},
+
+		publishes:         newCounterVec(namespace+"_publishes_total", "Messages published, by result.", "result"),
+		publishRetries:    newCounterVec(namespace+"_publish_retries_total", "Publishes retried after a channel error or nack."),
+		confirmations:     newCounterVec(namespace+"_publish_confirmations_total", "Publisher confirmations received, by result (ack or nack).", "result"),
+		confirmTimeouts:   newCounterVec(namespace+"_publish_confirmation_timeouts_total", "Publishes that timed out waiting for a confirmation."),
+		confirmLatency:    newHistogramVec(namespace+"_publish_confirmation_latency_seconds", "Time from publish to confirmation.", DefaultBuckets),
+		deliveries:        newCounterVec(namespace+"_consumer_deliveries_total", "Messages delivered to a consumer.", "consumer"),
+		acknowledgements:  newCounterVec(namespace+"_consumer_acknowledgements_total", "Message acknowledgements by type (ack, nack, or reject).", "consumer", "type"),
+		acknowledgeErrors: newCounterVec(namespace+"_consumer_acknowledgement_errors_total", "Message acknowledgements that failed, by type.", "consumer", "type"),
+		handlerDuration:   newHistogramVec(namespace+"_consumer_handler_duration_seconds", "Time spent in the consumer action.", DefaultBuckets, "consumer"),
+		consumerErrors:    newCounterVec(namespace+"_consumer_errors_total", "Consumer channel errors.", "consumer"),
+	}
+}
+
+// InstrumentConnectionPool includes the Stats of the ConnectionPool in every scrape, labeled by its ApplicationName.
+// Returns an error when another pool with the same ApplicationName is instrumented, their series would clash.
+func (c *Collector) InstrumentConnectionPool(cp *tcr.ConnectionPool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, pool := range c.pools {
+		if pool == cp {
+			return nil
+		}
+
+		if pool.Config.ApplicationName == cp.Config.ApplicationName {
+			return fmt.Errorf("a connection pool named %q is already instrumented", cp.Config.ApplicationName)
+		}
+	}
+
+	c.pools = append(c.pools, cp)
+	return nil
+}
+
+// InstrumentPublisher sets the Collector as the observer of the Publisher.
+func (c *Collector) InstrumentPublisher(pub *tcr.Publisher) {
+	pub.SetObserver(c)
+}
+
+// InstrumentConsumer sets the Collector as the observer of the Consumer.
+func (c *Collector) InstrumentConsumer(con *tcr.Consumer) {
+	con.SetObserver(c)
+}
+
+// InstrumentRabbitService instruments the ConnectionPool, Publisher, and every Consumer of the RabbitService.
+// Returns the error of InstrumentConnectionPool, leaving the RabbitService uninstrumented.
+func (c *Collector) InstrumentRabbitService(rs *tcr.RabbitService) error {
+
+	if err := c.InstrumentConnectionPool(rs.ConnectionPool); err != nil {
+		return err
+	}
+	c.InstrumentPublisher(rs.Publisher)
+
+	for name := range rs.Config.ConsumerConfigs {
+		if con, err := rs.GetConsumer(name); err == nil {
+			c.InstrumentConsumer(con)
+		}
+	}
+
+	return nil
+}
+
+// ObservePublish counts a publish by result.
+func (c *Collector) ObservePublish(letter *tcr.Letter, err error) {
+	c.publishes.inc(result(err))
+}
+
+// ObservePublishRetry counts a publish retry.
+func (c *Collector) ObservePublishRetry(letter *tcr.Letter) {
+	c.publishRetries.inc()
+}
+
+// ObserveConfirmation counts a confirmation and records its latency.
+func (c *Collector) ObserveConfirmation(letter *tcr.Letter, ack bool, latency time.Duration) {
+
+	if ack {
+		c.confirmations.inc("ack")
+	} else {
+		c.confirmations.inc("nack")
+	}
+
+	c.confirmLatency.observe(latency)
+}
+
+// ObserveConfirmationTimeout counts a confirmation timeout.
+func (c *Collector) ObserveConfirmationTimeout(letter *tcr.Letter) {
+	c.confirmTimeouts.inc()
+}
+
+// ObserveDelivery counts a delivery.
+func (c *Collector) ObserveDelivery(consumerName string) {
+	c.deliveries.inc(consumerName)
+}
+
+// ObserveAck counts an ack.
+func (c *Collector) ObserveAck(consumerName string, err error) {
+	c.observeAcknowledgement(consumerName, "ack", err)
+}
+
+// ObserveNack counts a nack.
+func (c *Collector) ObserveNack(consumerName string, requeue bool, err error) {
+	c.observeAcknowledgement(consumerName, "nack", err)
+}
+
+// ObserveReject counts a reject.
+func (c *Collector) ObserveReject(consumerName string, requeue bool, err error) {
+	c.observeAcknowledgement(consumerName, "reject", err)
+}
+
+// ObserveHandler records the duration of a consumer action.
+func (c *Collector) ObserveHandler(consumerName string, duration time.Duration) {
+	c.handlerDuration.observe(duration, consumerName)
+}
+
+// ObserveConsumerError counts a consumer error.
+func (c *Collector) ObserveConsumerError(consumerName string, err error) {
+	c.consumerErrors.inc(consumerName)
+}
+
+func (c *Collector) observeAcknowledgement(consumerName, acknowledgementType string, err error) {
+
+	if err != nil {
+		c.acknowledgeErrors.inc(consumerName, acknowledgementType)
+		return
+	}
+
+	c.acknowledgements.inc(consumerName, acknowledgementType)
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	buffer := &bytes.Buffer{}
+	c.writePools(buffer)
+
+	c.publishes.write(buffer)
+	c.publishRetries.write(buffer)
+	c.confirmations.write(buffer)
+	c.confirmTimeouts.write(buffer)
+	c.confirmLatency.write(buffer)
+
+	c.deliveries.write(buffer)
+	c.acknowledgements.write(buffer)
+	c.acknowledgeErrors.write(buffer)
+	c.handlerDuration.write(buffer)
+	c.consumerErrors.write(buffer)
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(buffer.Bytes())
+}
+
+func (c *Collector) writePools(buffer *bytes.Buffer) {
+
+	c.lock.RLock()
+	pools := make([]*tcr.ConnectionPool, len(c.pools))
+	copy(pools, c.pools)
+	c.lock.RUnlock()
+
+	if len(pools) == 0 {
+		return
+	}
+
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].Config.ApplicationName < pools[j].Config.ApplicationName
+	})
+
+	type poolMetric struct {
+		name       string
+		help       string
+		metricType string
+		value      func(tcr.PoolStats) float64
+	}
+
+	poolMetrics := []poolMetric{
+		{"connections", "Connections in the pool.", "gauge", func(s tcr.PoolStats) float64 { return float64(len(s.Connections)) }},
+		{"connections_closed", "Connections currently closed.", "gauge", func(s tcr.PoolStats) float64 {
+			return float64(countConnections(s, func(cs tcr.ConnectionStats) bool { return cs.Closed }))
+		}},
+		{"connections_blocked", "Connections currently blocked by the server.", "gauge", func(s tcr.PoolStats) float64 {
+			return float64(countConnections(s, func(cs tcr.ConnectionStats) bool { return cs.Blocked }))
+		}},
+		{"connections_flagged", "Connections flagged for recovery.", "gauge", func(s tcr.PoolStats) float64 { return float64(s.FlaggedConnections) }},
+		{"reconnects_total", "Connection reconnects.", "counter", func(s tcr.PoolStats) float64 { return float64(s.Reconnects) }},
+		{"channels_cached", "Cached channels in the pool.", "gauge", func(s tcr.PoolStats) float64 { return float64(s.CachedChannels) }},
+		{"channels_available", "Cached channels ready to be checked out.", "gauge", func(s tcr.PoolStats) float64 { return float64(s.ChannelsAvailable) }},
+		{"channels_checked_out", "Cached channels currently checked out.", "gauge", func(s tcr.PoolStats) float64 { return float64(s.ChannelsCheckedOut) }},
+		{"channel_checkouts_total", "Cached channel checkouts.", "counter", func(s tcr.PoolStats) float64 { return float64(s.ChannelCheckouts) }},
+		{"channel_returns_total", "Cached channel returns.", "counter", func(s tcr.PoolStats) float64 { return float64(s.ChannelReturns) }},
+		{"channel_errored_returns_total", "Cached channels returned flagged as errored.", "counter", func(s tcr.PoolStats) float64 { return float64(s.ErroredChannelReturns) }},
+	}
+
+	stats := make([]tcr.PoolStats, len(pools))
+	for i, cp := range pools {
+		stats[i] = cp.Stats()
+	}
+
+	labels := []string{"pool"}
+	for _, metric := range poolMetrics {
+		samples := make([]sample, len(pools))
+		for i, cp := range pools {
+			samples[i] = sample{labelValues: []string{cp.Config.ApplicationName}, value: metric.value(stats[i])}
+		}
+
+		writeSamples(buffer, c.namespace+"_pool_"+metric.name, metric.help, metric.metricType, labels, samples)
+	}
+
+	// The pool keeps percentiles over recent checkouts rather than a histogram, exposed as a gauge per quantile.
+	waitLabels := []string{"pool", "quantile"}
+	waitSamples := make([]sample, 0, len(pools)*4)
+	for i, cp := range pools {
+		wait := stats[i].ChannelWait
+		for _, quantile := range []struct {
+			label string
+			value time.Duration
+		}{{"0.5", wait.P50}, {"0.9", wait.P90}, {"0.99", wait.P99}, {"1", wait.Max}} {
+			waitSamples = append(waitSamples, sample{
+				labelValues: []string{cp.Config.ApplicationName, quantile.label},
+				value:       quantile.value.Seconds(),
+			})
+		}
+	}
+
+	writeSamples(buffer, c.namespace+"_pool_channel_wait_seconds", "Recent cached channel wait time by quantile.", "gauge", waitLabels, waitSamples)
+}
+
+func countConnections(stats tcr.PoolStats, predicate func(tcr.ConnectionStats) bool) int {
+
+	count := 0
+	for _, connStats := range stats.Connections {
+		if predicate(connStats) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func result(err error) string {
+
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
@@ -0,0 +1,74 @@
+package tcr
+
+import "time"
+
+// PublisherObserver receives instrumentation events from a Publisher (ex. the tcr/metrics package).
+// Implementations are called inline while publishing and must be safe for concurrent use.
+type PublisherObserver interface {
+	ObservePublish(letter *Letter, err error)
+	ObservePublishRetry(letter *Letter)
+	ObserveConfirmation(letter *Letter, ack bool, latency time.Duration)
+	ObserveConfirmationTimeout(letter *Letter)
+}
+
+// ConsumerObserver receives instrumentation events from a Consumer (ex. the tcr/metrics package).
+// Implementations are called inline while consuming and must be safe for concurrent use.
+type ConsumerObserver interface {
+	ObserveDelivery(consumerName string)
+	ObserveAck(consumerName string, err error)
+	ObserveNack(consumerName string, requeue bool, err error)
+	ObserveReject(consumerName string, requeue bool, err error)
+	ObserveHandler(consumerName string, duration time.Duration)
+	ObserveConsumerError(consumerName string, err error)
+}
+
+// SetObserver instruments the Publisher, nil removes the current observer.
+func (pub *Publisher) SetObserver(observer PublisherObserver) {
+	pub.pubRWLock.Lock()
+	defer pub.pubRWLock.Unlock()
+	pub.observer = observer
+}
+
+func (pub *Publisher) getObserver() PublisherObserver {
+	pub.pubRWLock.RLock()
+	defer pub.pubRWLock.RUnlock()
+	return pub.observer
+}
+
+func (pub *Publisher) observePublish(letter *Letter, err error) {
+	if observer := pub.getObserver(); observer != nil {
+		observer.ObservePublish(letter, err)
+	}
+}
+
+func (pub *Publisher) observePublishRetry(letter *Letter) {
+	if observer := pub.getObserver(); observer != nil {
+		observer.ObservePublishRetry(letter)
+	}
+}
+
+func (pub *Publisher) observeConfirmation(letter *Letter, ack bool, latency time.Duration) {
+	if observer := pub.getObserver(); observer != nil {
+		observer.ObserveConfirmation(letter, ack, latency)
+	}
+}
+
+func (pub *Publisher) observeConfirmationTimeout(letter *Letter) {
+	if observer := pub.getObserver(); observer != nil {
+		observer.ObserveConfirmationTimeout(letter)
+	}
+}
+
+// SetObserver instruments the Consumer and the ReceivedMessages it creates, nil removes the current observer.
+// Handler durations are only observed when consuming with StartConsumingWithAction.
+func (con *Consumer) SetObserver(observer ConsumerObserver) {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+	con.observer = observer
+}
+
+func (con *Consumer) getObserver() ConsumerObserver {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+	return con.observer
+}
@@ -0,0 +1,453 @@
+package tcr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// defaultOutboxSegmentSize is how large (in bytes) a segment of the Outbox grows before a new one is started.
+	defaultOutboxSegmentSize = 64 << 20
+
+	outboxSegmentPrefix = "outbox-"
+	outboxSegmentSuffix = ".log"
+
+	outboxLetterRecord byte = 1 // a queued letter in gob, the values of its headers keep their types
+	outboxDoneRecord   byte = 2 // the LetterID of a letter that has been published
+)
+
+func init() {
+	// The types amqp.Table values can hold beyond the ones gob knows already.
+	gob.Register(amqp.Table{})
+	gob.Register([]interface{}{})
+	gob.Register(amqp.Decimal{})
+	gob.Register(time.Time{})
+}
+
+// ErrOutboxClosed is returned when writing to an Outbox that has been closed.
+var ErrOutboxClosed = errors.New("outbox is closed")
+
+// Outbox is a durable, append-only log of the letters queued on a Publisher, kept in a local directory.
+// Letters are written before QueueLetter returns and marked done once published with a confirmation, the letters
+// never marked done are replayed when the Outbox is opened again (at least once delivery across process restarts).
+// Segments are deleted once every letter in them (and in the segments before them) is done.
+type Outbox struct {
+	directory   string
+	segmentSize int64
+	sync        bool
+	active      *os.File
+	activeID    uint64
+	activeSize  int64
+	segments    []uint64             // segment ids on disk, oldest first
+	open        map[uuid.UUID]uint64 // LetterID to the segment of its entry
+	openCounts  map[uint64]int       // segment to the count of its letters not done
+	replay      []*Letter
+	closed      bool
+	outboxLock  *sync.Mutex
+}
+
+// OpenOutbox opens (or creates) the Outbox in the directory and loads the letters that were never marked done.
+// A segmentSize of 0 uses 64MB. With syncWrites every write is flushed to disk before returning, surviving a machine
+// crash instead of only a process crash at the cost of QueueLetter throughput.
+func OpenOutbox(directory string, segmentSize int64, syncWrites bool) (*Outbox, error) {
+
+	if segmentSize <= 0 {
+		segmentSize = defaultOutboxSegmentSize
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("outbox %w", err)
+	}
+
+	outbox := &Outbox{
+		directory:   directory,
+		segmentSize: segmentSize,
+		sync:        syncWrites,
+		open:        make(map[uuid.UUID]uint64),
+		openCounts:  make(map[uint64]int),
+		outboxLock:  &sync.Mutex{},
+	}
+
+	if err := outbox.load(); err != nil {
+		return nil, fmt.Errorf("outbox %w", err)
+	}
+
+	if err := outbox.roll(); err != nil {
+		return nil, fmt.Errorf("outbox %w", err)
+	}
+
+	return outbox, nil
+}
+
+// Pending returns the count of letters written to the Outbox and not yet done.
+func (ob *Outbox) Pending() int {
+	ob.outboxLock.Lock()
+	defer ob.outboxLock.Unlock()
+	return len(ob.open)
+}
+
+// Discard marks a letter done without publishing it, so it isn't replayed (ex. a letter that failed for good).
+func (ob *Outbox) Discard(letterID uuid.UUID) error {
+	return ob.done(letterID)
+}
+
+// Close closes the active segment. Letters not yet done are replayed the next time the Outbox is opened.
+func (ob *Outbox) Close() error {
+	ob.outboxLock.Lock()
+	defer ob.outboxLock.Unlock()
+
+	if ob.closed {
+		return nil
+	}
+	ob.closed = true
+
+	return ob.active.Close()
+}
+
+// takeReplay hands out the letters loaded on open that were never marked done, only once.
+func (ob *Outbox) takeReplay() []*Letter {
+	ob.outboxLock.Lock()
+	defer ob.outboxLock.Unlock()
+
+	replay := ob.replay
+	ob.replay = nil
+	return replay
+}
+
+// append writes the letter to the Outbox, unless an entry for its LetterID is already waiting to be done (ex. requeued).
+func (ob *Outbox) append(letter *Letter) error {
+
+	data, err := encodeLetter(letter)
+	if err != nil {
+		return fmt.Errorf("outbox %w", err)
+	}
+
+	ob.outboxLock.Lock()
+	defer ob.outboxLock.Unlock()
+
+	if ob.closed {
+		return ErrOutboxClosed
+	}
+
+	if _, ok := ob.open[letter.LetterID]; ok {
+		return nil
+	}
+
+	if ob.activeSize >= ob.segmentSize {
+		if err := ob.roll(); err != nil {
+			return fmt.Errorf("outbox %w", err)
+		}
+	}
+
+	if err := ob.write(outboxLetterRecord, data); err != nil {
+		return fmt.Errorf("outbox %w", err)
+	}
+
+	ob.open[letter.LetterID] = ob.activeID
+	ob.openCounts[ob.activeID]++
+
+	return nil
+}
+
+// done marks the letter as published.
+func (ob *Outbox) done(letterID uuid.UUID) error {
+	ob.outboxLock.Lock()
+	defer ob.outboxLock.Unlock()
+
+	if ob.closed {
+		return ErrOutboxClosed
+	}
+
+	segment, ok := ob.open[letterID]
+	if !ok {
+		return nil
+	}
+
+	if err := ob.write(outboxDoneRecord, letterID[:]); err != nil {
+		return fmt.Errorf("outbox %w", err)
+	}
+
+	delete(ob.open, letterID)
+	ob.openCounts[segment]--
+
+	return ob.compact()
+}
+
+// write appends a record: its length, the CRC32 of its payload, and the payload (record type then data).
+func (ob *Outbox) write(recordType byte, data []byte) error {
+
+	record := make([]byte, 8+1+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(1+len(data)))
+	record[8] = recordType
+	copy(record[9:], data)
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[8:]))
+
+	if _, err := ob.active.Write(record); err != nil {
+		return err
+	}
+	ob.activeSize += int64(len(record))
+
+	if ob.sync {
+		return ob.active.Sync()
+	}
+
+	return nil
+}
+
+// roll closes the active segment (if any) and starts a new one.
+func (ob *Outbox) roll() error {
+
+	if ob.active != nil {
+		if err := ob.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	id := uint64(1)
+	if len(ob.segments) > 0 {
+		id = ob.segments[len(ob.segments)-1] + 1
+	}
+
+	active, err := os.OpenFile(ob.segmentPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	ob.active = active
+	ob.activeID = id
+	ob.activeSize = 0
+	ob.segments = append(ob.segments, id)
+
+	return ob.compact()
+}
+
+// compact deletes the oldest segments while every letter in them is done. A segment holding letters not yet done
+// also keeps the segments after it, their done records are still needed to replay it.
+func (ob *Outbox) compact() error {
+
+	for len(ob.segments) > 1 && ob.segments[0] != ob.activeID && ob.openCounts[ob.segments[0]] == 0 {
+		if err := os.Remove(ob.segmentPath(ob.segments[0])); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("outbox %w", err)
+		}
+
+		delete(ob.openCounts, ob.segments[0])
+		ob.segments = ob.segments[1:]
+	}
+
+	return nil
+}
+
+// load replays every segment on disk, oldest first, to find the letters that were never marked done.
+func (ob *Outbox) load() error {
+
+	entries, err := os.ReadDir(ob.directory)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, outboxSegmentPrefix) || !strings.HasSuffix(name, outboxSegmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, outboxSegmentPrefix), outboxSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ob.segments = append(ob.segments, id)
+	}
+	sort.Slice(ob.segments, func(i, j int) bool { return ob.segments[i] < ob.segments[j] })
+
+	letters := make(map[uuid.UUID]*Letter)
+	order := make([]uuid.UUID, 0)
+	for i, id := range ob.segments {
+		last := i == len(ob.segments)-1
+		err := ob.readSegment(id, last, func(recordType byte, data []byte) error {
+			switch recordType {
+			case outboxLetterRecord:
+				letter, err := decodeLetter(data)
+				if err != nil {
+					return err
+				}
+
+				if _, ok := ob.open[letter.LetterID]; !ok {
+					order = append(order, letter.LetterID)
+				} else {
+					ob.openCounts[ob.open[letter.LetterID]]--
+				}
+				letters[letter.LetterID] = letter
+				ob.open[letter.LetterID] = id
+				ob.openCounts[id]++
+
+			case outboxDoneRecord:
+				letterID, err := uuid.FromBytes(data)
+				if err != nil {
+					return err
+				}
+
+				if segment, ok := ob.open[letterID]; ok {
+					delete(ob.open, letterID)
+					ob.openCounts[segment]--
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, letterID := range order {
+		if _, ok := ob.open[letterID]; ok {
+			ob.replay = append(ob.replay, letters[letterID])
+		}
+	}
+
+	return nil
+}
+
+// readSegment reads every record of a segment. A torn record at the end of the last segment (a crash mid-write)
+// is cut off, anywhere else it is an error.
+func (ob *Outbox) readSegment(id uint64, last bool, handle func(recordType byte, data []byte) error) error {
+
+	path := ob.segmentPath(id)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, 8)
+	offset := int64(0)
+	for {
+		_, err := io.ReadFull(reader, header)
+		if err == io.EOF {
+			return nil
+		}
+
+		// The length isn't covered by the checksum, it is only trusted as far as the segment goes.
+		var payload []byte
+		if err == nil {
+			length := int64(binary.BigEndian.Uint32(header[0:4]))
+			if length > info.Size()-offset-int64(len(header)) {
+				err = fmt.Errorf("record length %d is past the end of the segment", length)
+			} else {
+				payload = make([]byte, length)
+				_, err = io.ReadFull(reader, payload)
+			}
+		}
+
+		if err == nil && (len(payload) == 0 || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8])) {
+			err = errors.New("checksum mismatch")
+		}
+
+		if err != nil {
+			if last {
+				return os.Truncate(path, offset)
+			}
+			return fmt.Errorf("segment %d is corrupt at offset %d: %w", id, offset, err)
+		}
+
+		if err := handle(payload[0], payload[1:]); err != nil {
+			return fmt.Errorf("segment %d at offset %d: %w", id, offset, err)
+		}
+		offset += int64(len(header) + len(payload))
+	}
+}
+
+// encodeLetter encodes the letter for an outboxLetterRecord.
+func encodeLetter(letter *Letter) ([]byte, error) {
+
+	buffer := &bytes.Buffer{}
+	if err := gob.NewEncoder(buffer).Encode(letter); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decodeLetter decodes the letter of an outboxLetterRecord.
+func decodeLetter(data []byte) (*Letter, error) {
+
+	letter := &Letter{}
+	return letter, gob.NewDecoder(bytes.NewReader(data)).Decode(letter)
+}
+
+func (ob *Outbox) segmentPath(id uint64) string {
+	return filepath.Join(ob.directory, fmt.Sprintf("%s%020d%s", outboxSegmentPrefix, id, outboxSegmentSuffix))
+}
+
+// SetOutbox makes QueueLetter write letters to the Outbox before queueing them, and the auto-publisher mark them done
+// once confirmed. The letters the Outbox replayed from a previous run are queued up again. Call it before queueing letters.
+func (pub *Publisher) SetOutbox(outbox *Outbox) {
+	pub.queueLock.Lock()
+	defer pub.queueLock.Unlock()
+
+	pub.outbox = outbox
+
+	replay := outbox.takeReplay()
+	if len(replay) == 0 {
+		return
+	}
+
+	atomic.AddInt64(&pub.queued, int64(len(replay)))
+	lane := pub.defaultLane()
+	for i, letter := range replay {
+		select {
+		case lane.letters <- letter:
+		default: // the queue is full, the rest wait for the auto-publisher in the background
+			go pub.replay(replay[i:])
+			return
+		}
+	}
+}
+
+// replay queues up letters replayed from the Outbox, giving up on them if the Publisher is shut down first.
+func (pub *Publisher) replay(letters []*Letter) {
+	lane := pub.defaultLane()
+	for i, letter := range letters {
+		select {
+		case lane.letters <- letter:
+		case <-pub.queueClosing: // still in the Outbox, replayed again next time
+			atomic.AddInt64(&pub.queued, -int64(len(letters)-i))
+			return
+		}
+	}
+}
+
+func (pub *Publisher) getOutbox() *Outbox {
+	pub.queueLock.RLock()
+	defer pub.queueLock.RUnlock()
+	return pub.outbox
+}
+
+// closeOutbox closes the Outbox (if any), the letters not yet done stay on disk.
+func (pub *Publisher) closeOutbox() {
+	if outbox := pub.getOutbox(); outbox != nil {
+		_ = outbox.Close()
+	}
+}
@@ -2,7 +2,6 @@ package tcr
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"time"
 
@@ -13,43 +12,89 @@ import (
 type Publisher struct {
 	Config                 *RabbitSeasoning
 	ConnectionPool         *ConnectionPool
-	letters                chan *Letter
+	lanes                  []*publishLane // highest priority first
 	autoStop               chan bool
 	publishReceipts        chan *PublishReceipt
 	autoStarted            bool
 	autoPublishGroup       *sync.WaitGroup
+	autoCancel             context.CancelFunc
+	autoDone               chan struct{}
+	queueLock              *sync.RWMutex
+	queueClosed            bool
+	queueClosing           chan struct{} // closed along with the queue, wakes up QueueLetter waiting for room
+	queued                 int64     // letters queued and not yet done publishing
+	undelivered            []*Letter // letters that failed to publish after the queue was closed
+	abandoned              []*Letter // letters given up on mid-publish by a shutdown
 	sleepOnIdleInterval    time.Duration
 	sleepOnErrorInterval   time.Duration
 	publishTimeOutDuration time.Duration
+	maxRetryCount          uint32 // republishes of a nacked letter, by PublishBatchWithConfirmation and RabbitService
 	pubLock                *sync.Mutex
 	pubRWLock              *sync.RWMutex
+	observer               PublisherObserver
+	flowControl            string
+	rateLimiter            *rateLimiter
+	circuitBreaker         *circuitBreaker
+	circuitHandler         func(CircuitEvent)
+	outbox                 *Outbox
+	claimCheckStore        ClaimCheckStore
+	claimCheckThreshold    int
 }
 
 // NewPublisherFromConfig creates and configures a new Publisher.
+// The PublisherConfig is not validated, use NewPublisherFromConfigWithError for that.
 func NewPublisherFromConfig(
 	config *RabbitSeasoning,
 	cp *ConnectionPool) *Publisher {
 
-	if config.PublisherConfig.MaxRetryCount == 0 {
-		config.PublisherConfig.MaxRetryCount = 5
+	maxRetryCount := config.PublisherConfig.MaxRetryCount
+	if maxRetryCount == 0 {
+		maxRetryCount = defaultMaxRetryCount
 	}
 
 	return &Publisher{
 		Config:                 config,
 		ConnectionPool:         cp,
-		letters:                make(chan *Letter, 1000),
+		lanes:                  newPublishLanes(config.PublisherConfig.Lanes),
 		autoStop:               make(chan bool, 1),
 		autoPublishGroup:       &sync.WaitGroup{},
 		publishReceipts:        make(chan *PublishReceipt, 1000),
 		sleepOnIdleInterval:    time.Duration(config.PublisherConfig.SleepOnIdleInterval) * time.Millisecond,
 		sleepOnErrorInterval:   time.Duration(config.PublisherConfig.SleepOnErrorInterval) * time.Millisecond,
 		publishTimeOutDuration: time.Duration(config.PublisherConfig.PublishTimeOutInterval) * time.Millisecond,
+		maxRetryCount:          maxRetryCount,
 		pubLock:                &sync.Mutex{},
 		pubRWLock:              &sync.RWMutex{},
+		queueLock:              &sync.RWMutex{},
+		queueClosing:           make(chan struct{}),
 		autoStarted:            false,
+		flowControl:            config.PublisherConfig.FlowControl,
+		rateLimiter:            newRateLimiter(config.PublisherConfig.RateLimit),
+		circuitBreaker:         newCircuitBreaker(config.PublisherConfig.CircuitBreaker),
 	}
 }
 
+// NewPublisherFromConfigWithError creates and configures a new Publisher, returning an error when the PublisherConfig
+// is invalid (ex. an unknown FlowControl or RateLimit mode).
+func NewPublisherFromConfigWithError(
+	config *RabbitSeasoning,
+	cp *ConnectionPool) (*Publisher, error) {
+
+	if err := validateFlowControl(config.PublisherConfig.FlowControl); err != nil {
+		return nil, err
+	}
+
+	if err := validateRateLimit(config.PublisherConfig.RateLimit); err != nil {
+		return nil, err
+	}
+
+	if err := validateLanes(config.PublisherConfig.Lanes); err != nil {
+		return nil, err
+	}
+
+	return NewPublisherFromConfig(config, cp), nil
+}
+
 // NewPublisher creates and configures a new Publisher.
 func NewPublisher(
 	cp *ConnectionPool,
@@ -59,15 +104,18 @@ func NewPublisher(
 
 	return &Publisher{
 		ConnectionPool:         cp,
-		letters:                make(chan *Letter, 1000),
+		lanes:                  newPublishLanes(nil),
 		autoStop:               make(chan bool, 1),
 		autoPublishGroup:       &sync.WaitGroup{},
 		publishReceipts:        make(chan *PublishReceipt, 1000),
 		sleepOnIdleInterval:    sleepOnIdleInterval,
 		sleepOnErrorInterval:   sleepOnErrorInterval,
 		publishTimeOutDuration: publishTimeOutDuration,
+		maxRetryCount:          defaultMaxRetryCount,
 		pubLock:                &sync.Mutex{},
 		pubRWLock:              &sync.RWMutex{},
+		queueLock:              &sync.RWMutex{},
+		queueClosing:           make(chan struct{}),
 		autoStarted:            false,
 	}
 }
@@ -78,29 +126,30 @@ func NewPublisher(
 // For proper resilience (at least once delivery guarantee over shaky network) use PublishWithConfirmation
 func (pub *Publisher) Publish(letter *Letter, skipReceipt bool) {
 
-	chanHost := pub.ConnectionPool.GetChannelFromPool()
+	receipt := newPublishReceipt(letter)
+	chanHost, letter, err := pub.getPublishChannel(context.Background(), letter, false)
+	if err != nil {
+		pub.observePublish(letter, err)
+		if !skipReceipt {
+			pub.publishReceipt(receipt, letter, err)
+		}
+		return
+	}
 
-	err := chanHost.Channel.Publish(
+	receipt.attempt(chanHost)
+	err = chanHost.channel.Publish(
 		letter.Envelope.Exchange,
 		letter.Envelope.RoutingKey,
 		letter.Envelope.Mandatory,
 		letter.Envelope.Immediate,
-		amqp.Publishing{
-			ContentType:   letter.Envelope.ContentType,
-			Body:          letter.Body,
-			Headers:       letter.Envelope.Headers,
-			DeliveryMode:  letter.Envelope.DeliveryMode,
-			Priority:      letter.Envelope.Priority,
-			MessageId:     letter.LetterID.String(),
-			CorrelationId: letter.Envelope.CorrelationID,
-			Type:          letter.Envelope.Type,
-			Timestamp:     time.Now().UTC(),
-			AppId:         pub.ConnectionPool.Config.ApplicationName,
-		},
+		newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 	)
+	err = newChannelError(chanHost, err)
+	pub.observePublish(letter, err)
+	pub.circuitResult(err)
 
 	if !skipReceipt {
-		pub.publishReceipt(letter, err)
+		pub.publishReceipt(receipt, letter, err)
 	}
 
 	pub.ConnectionPool.ReturnChannel(chanHost, err != nil)
@@ -111,29 +160,66 @@ func (pub *Publisher) Publish(letter *Letter, skipReceipt bool) {
 // For proper resilience (at least once delivery guarantee over shaky network) use PublishWithConfirmation
 func (pub *Publisher) PublishWithError(letter *Letter, skipReceipt bool) error {
 
-	chanHost := pub.ConnectionPool.GetChannelFromPool()
+	receipt := newPublishReceipt(letter)
+	chanHost, letter, err := pub.getPublishChannel(context.Background(), letter, false)
+	if err != nil {
+		pub.observePublish(letter, err)
+		if !skipReceipt {
+			pub.publishReceipt(receipt, letter, err)
+		}
+		return err
+	}
 
-	err := chanHost.Channel.Publish(
+	receipt.attempt(chanHost)
+	err = chanHost.channel.Publish(
 		letter.Envelope.Exchange,
 		letter.Envelope.RoutingKey,
 		letter.Envelope.Mandatory,
 		letter.Envelope.Immediate,
-		amqp.Publishing{
-			ContentType:   letter.Envelope.ContentType,
-			Body:          letter.Body,
-			Headers:       letter.Envelope.Headers,
-			DeliveryMode:  letter.Envelope.DeliveryMode,
-			Priority:      letter.Envelope.Priority,
-			MessageId:     letter.LetterID.String(),
-			CorrelationId: letter.Envelope.CorrelationID,
-			Type:          letter.Envelope.Type,
-			Timestamp:     time.Now().UTC(),
-			AppId:         pub.ConnectionPool.Config.ApplicationName,
-		},
+		newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 	)
+	err = newChannelError(chanHost, err)
+	pub.observePublish(letter, err)
+	pub.circuitResult(err)
 
 	if !skipReceipt {
-		pub.publishReceipt(letter, err)
+		pub.publishReceipt(receipt, letter, err)
+	}
+
+	pub.ConnectionPool.ReturnChannel(chanHost, err != nil)
+	return err
+}
+
+// PublishContext sends a single message to the address on the letter using a cached ChannelHost.
+// Returns an *AcquisitionError if a ChannelHost isn't available before the context is done.
+//
+// For proper resilience (at least once delivery guarantee over shaky network) use PublishWithConfirmationContext
+func (pub *Publisher) PublishContext(ctx context.Context, letter *Letter, skipReceipt bool) error {
+
+	receipt := newPublishReceipt(letter)
+	chanHost, letter, err := pub.getPublishChannel(ctx, letter, false)
+	if err != nil {
+		pub.observePublish(letter, err)
+		if !skipReceipt {
+			pub.publishReceipt(receipt, letter, err)
+		}
+		return err
+	}
+
+	receipt.attempt(chanHost)
+	err = chanHost.channel.Publish(
+		letter.Envelope.Exchange,
+		letter.Envelope.RoutingKey,
+		letter.Envelope.Mandatory,
+		letter.Envelope.Immediate,
+		newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
+	)
+	err = newChannelError(chanHost, err)
+	pub.observePublish(letter, err)
+	pub.circuitResult(err)
+
+	if !skipReceipt {
+		pub.publishReceipt(receipt, letter, err)
 	}
 
 	pub.ConnectionPool.ReturnChannel(chanHost, err != nil)
@@ -144,8 +230,35 @@ func (pub *Publisher) PublishWithError(letter *Letter, skipReceipt bool) error {
 // Subscribe to PublishReceipts to see success and errors.
 // For proper resilience (at least once delivery guarantee over shaky network) use PublishWithConfirmation
 func (pub *Publisher) PublishWithTransient(letter *Letter) error {
+	return pub.PublishWithTransientContext(context.Background(), letter)
+}
+
+// PublishWithTransientContext sends a single message to the address on the letter using a transient (new) RabbitMQ channel.
+// Returns an *AcquisitionError if a channel can't be created before the context is done.
+func (pub *Publisher) PublishWithTransientContext(ctx context.Context, letter *Letter) error {
+
+	if err := pub.enterCircuit(); err != nil {
+		pub.observePublish(letter, err)
+		return err
+	}
+
+	letter, err := pub.claimCheck(ctx, letter)
+	if err != nil {
+		pub.observePublish(letter, err)
+		return err
+	}
+
+	if err := pub.throttle(ctx, letter); err != nil {
+		pub.observePublish(letter, err)
+		return err
+	}
 
-	channel := pub.ConnectionPool.GetTransientChannel(false)
+	channel, err := pub.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		pub.observePublish(letter, err)
+		pub.circuitResult(err)
+		return err
+	}
 	defer func() {
 		defer func() {
 			_ = recover()
@@ -153,24 +266,18 @@ func (pub *Publisher) PublishWithTransient(letter *Letter) error {
 		channel.Close()
 	}()
 
-	return channel.Publish(
+	err = channel.Publish(
 		letter.Envelope.Exchange,
 		letter.Envelope.RoutingKey,
 		letter.Envelope.Mandatory,
 		letter.Envelope.Immediate,
-		amqp.Publishing{
-			ContentType:   letter.Envelope.ContentType,
-			Body:          letter.Body,
-			Headers:       letter.Envelope.Headers,
-			DeliveryMode:  letter.Envelope.DeliveryMode,
-			Priority:      letter.Envelope.Priority,
-			MessageId:     letter.LetterID.String(),
-			CorrelationId: letter.Envelope.CorrelationID,
-			Type:          letter.Envelope.Type,
-			Timestamp:     time.Now().UTC(),
-			AppId:         pub.ConnectionPool.Config.ApplicationName,
-		},
+		newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 	)
+	err = newChannelError(nil, err)
+	pub.observePublish(letter, err)
+	pub.circuitResult(err)
+
+	return err
 }
 
 // PublishWithConfirmation sends a single message to the address on the letter with confirmation capabilities.
@@ -184,60 +291,61 @@ func (pub *Publisher) PublishWithConfirmation(letter *Letter, timeout time.Durat
 		timeout = pub.publishTimeOutDuration
 	}
 
+	receipt := newPublishReceipt(letter)
 	for {
 		// Has to use an Ackable channel for Publish Confirmations.
-		chanHost := pub.ConnectionPool.GetChannelFromPool()
-		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost, published, err := pub.getPublishChannel(context.Background(), letter, true)
+		letter = published // offloaded once, the republishes reuse the claim checked copy
+		if err != nil {
+			pub.observePublish(letter, err)
+			pub.publishReceipt(receipt, letter, err)
+			return
+		}
 
-	Publish:
 		timeoutAfter := time.After(timeout) // timeoutAfter resets everytime we try to publish.
-		err := chanHost.Channel.Publish(
+		receipt.attempt(chanHost)
+		confirmation, err := chanHost.PublishWithDeferredConfirmation(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				Priority:      letter.Envelope.Priority,
-				MessageId:     letter.LetterID.String(),
-				CorrelationId: letter.Envelope.CorrelationID,
-				Type:          letter.Envelope.Type,
-				Timestamp:     time.Now().UTC(),
-				AppId:         pub.ConnectionPool.Config.ApplicationName,
-			},
+			newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 		)
+		pub.observePublish(letter, err)
 		if err != nil {
+			pub.circuitResult(err)
+			pub.observePublishRetry(letter)
 			pub.ConnectionPool.ReturnChannel(chanHost, true)
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
-		for {
-			select {
-			case <-timeoutAfter:
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterID: %s wasn't received in a timely manner - recommend retry/requeue", letter.LetterID.String()))
-				pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
-				return
-
-			case confirmation := <-chanHost.Confirmations:
+		pub.ConnectionPool.ReturnChannel(chanHost, false) // others can publish on it while we await our confirmation
 
-				if !confirmation.Ack {
-					goto Publish //nack has occurred, republish
-				}
-
-				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
-				pub.ConnectionPool.ReturnChannel(chanHost, false)
-				return
+		select {
+		case <-timeoutAfter:
+			pub.observeConfirmationTimeout(letter)
+			pub.circuitResult(ErrConfirmTimeout)
+			pub.publishReceipt(receipt, letter, newConfirmTimeoutError(letter))
+			return
+		case <-confirmation.Done():
+		}
 
-			default:
+		receipt.confirmed(confirmation.ConfirmedAt())
+		pub.observeConfirmation(letter, confirmation.Acked(), receipt.ConfirmLatency)
+		pub.circuitConfirmation(confirmation)
+		if returned := confirmation.Returned(); returned != nil {
+			pub.publishReceipt(receipt, letter, newUnroutableError(letter, returned)) // republishing won't route it
+			return
+		}
 
-				time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
-			}
+		if !confirmation.Acked() {
+			pub.observePublishRetry(letter)
+			continue // nacked, or the channel closed before confirming, republish
 		}
+
+		// Happy Path, publish was received by server and we didn't timeout client side.
+		pub.publishReceipt(receipt, letter, nil)
+		return
 	}
 }
 
@@ -252,58 +360,57 @@ func (pub *Publisher) PublishWithConfirmationError(letter *Letter, timeout time.
 		timeout = pub.publishTimeOutDuration
 	}
 
+	receipt := newPublishReceipt(letter)
 	for {
 		// Has to use an Ackable channel for Publish Confirmations.
-		chanHost := pub.ConnectionPool.GetChannelFromPool()
-		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost, published, err := pub.getPublishChannel(context.Background(), letter, true)
+		letter = published // offloaded once, the republishes reuse the claim checked copy
+		if err != nil {
+			pub.observePublish(letter, err)
+			return err
+		}
 
-	Publish:
 		timeoutAfter := time.After(timeout) // timeoutAfter resets everytime we try to publish.
-		err := chanHost.Channel.Publish(
+		receipt.attempt(chanHost)
+		confirmation, err := chanHost.PublishWithDeferredConfirmation(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				Priority:      letter.Envelope.Priority,
-				MessageId:     letter.LetterID.String(),
-				CorrelationId: letter.Envelope.CorrelationID,
-				Type:          letter.Envelope.Type,
-				Timestamp:     time.Now().UTC(),
-				AppId:         pub.ConnectionPool.Config.ApplicationName,
-			},
+			newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 		)
+		pub.observePublish(letter, err)
 		if err != nil {
+			pub.circuitResult(err)
+			pub.observePublishRetry(letter)
 			pub.ConnectionPool.ReturnChannel(chanHost, true)
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
-		for {
-			select {
-			case <-timeoutAfter:
-				pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
-				return fmt.Errorf("publish confirmation for LetterID: %s wasn't received in a timely manner - recommend retry/requeue", letter.LetterID.String())
-
-			case confirmation := <-chanHost.Confirmations:
-
-				if !confirmation.Ack {
-					goto Publish //nack has occurred, republish
-				}
+		pub.ConnectionPool.ReturnChannel(chanHost, false) // others can publish on it while we await our confirmation
 
-				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.ConnectionPool.ReturnChannel(chanHost, false)
-				return nil
+		select {
+		case <-timeoutAfter:
+			pub.observeConfirmationTimeout(letter)
+			pub.circuitResult(ErrConfirmTimeout)
+			return newConfirmTimeoutError(letter)
+		case <-confirmation.Done():
+		}
 
-			default:
+		receipt.confirmed(confirmation.ConfirmedAt())
+		pub.observeConfirmation(letter, confirmation.Acked(), receipt.ConfirmLatency)
+		pub.circuitConfirmation(confirmation)
+		if returned := confirmation.Returned(); returned != nil {
+			return newUnroutableError(letter, returned) // republishing won't route it
+		}
 
-				time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
-			}
+		if !confirmation.Acked() {
+			pub.observePublishRetry(letter)
+			continue // nacked, or the channel closed before confirming, republish
 		}
+
+		// Happy Path, publish was received by server and we didn't timeout client side.
+		return nil
 	}
 }
 
@@ -313,59 +420,60 @@ func (pub *Publisher) PublishWithConfirmationError(letter *Letter, timeout time.
 // A confirmation failure keeps trying to publish (at least until timeout failure occurs.)
 func (pub *Publisher) PublishWithConfirmationContext(ctx context.Context, letter *Letter) {
 
+	receipt := newPublishReceipt(letter)
 	for {
 		// Has to use an Ackable channel for Publish Confirmations.
-		chanHost := pub.ConnectionPool.GetChannelFromPool()
-		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost, published, err := pub.getPublishChannel(ctx, letter, true)
+		letter = published // offloaded once, the republishes reuse the claim checked copy
+		if err != nil {
+			pub.observePublish(letter, err)
+			pub.publishReceipt(receipt, letter, err)
+			return
+		}
 
-	Publish:
-		err := chanHost.Channel.Publish(
+		receipt.attempt(chanHost)
+		confirmation, err := chanHost.PublishWithDeferredConfirmation(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				Priority:      letter.Envelope.Priority,
-				MessageId:     letter.LetterID.String(),
-				CorrelationId: letter.Envelope.CorrelationID,
-				Type:          letter.Envelope.Type,
-				Timestamp:     time.Now().UTC(),
-				AppId:         pub.ConnectionPool.Config.ApplicationName,
-			},
+			newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 		)
+		pub.observePublish(letter, err)
 		if err != nil {
+			pub.circuitResult(err)
+			pub.observePublishRetry(letter)
 			pub.ConnectionPool.ReturnChannel(chanHost, true)
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
-		for {
-			select {
-			case <-ctx.Done():
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterID: %s wasn't received before context expired - recommend retry/requeue", letter.LetterID.String()))
-				pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
-				return
-
-			case confirmation := <-chanHost.Confirmations:
-
-				if !confirmation.Ack {
-					goto Publish //nack has occurred, republish
-				}
+		pub.ConnectionPool.ReturnChannel(chanHost, false) // others can publish on it while we await our confirmation
 
-				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
-				pub.ConnectionPool.ReturnChannel(chanHost, false)
-				return
+		select {
+		case <-ctx.Done():
+			pub.observeConfirmationTimeout(letter)
+			pub.circuitResult(ErrConfirmTimeout)
+			pub.publishReceipt(receipt, letter, newConfirmTimeoutError(letter))
+			return
+		case <-confirmation.Done():
+		}
 
-			default:
+		receipt.confirmed(confirmation.ConfirmedAt())
+		pub.observeConfirmation(letter, confirmation.Acked(), receipt.ConfirmLatency)
+		pub.circuitConfirmation(confirmation)
+		if returned := confirmation.Returned(); returned != nil {
+			pub.publishReceipt(receipt, letter, newUnroutableError(letter, returned)) // republishing won't route it
+			return
+		}
 
-				time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
-			}
+		if !confirmation.Acked() {
+			pub.observePublishRetry(letter)
+			continue // nacked, or the channel closed before confirming, republish
 		}
+
+		// Happy Path, publish was received by server and we didn't timeout client side.
+		pub.publishReceipt(receipt, letter, nil)
+		return
 	}
 }
 
@@ -374,57 +482,89 @@ func (pub *Publisher) PublishWithConfirmationContext(ctx context.Context, letter
 // A timeout failure drops the letter back in the PublishReceipts.
 // A confirmation failure keeps trying to publish (at least until timeout failure occurs.)
 func (pub *Publisher) PublishWithConfirmationContextError(ctx context.Context, letter *Letter) error {
+	return pub.publishWithConfirmationContextError(ctx, letter, newPublishReceipt(letter), false)
+}
+
+// publishWithConfirmationContextError is PublishWithConfirmationContextError recording its publishes on the receipt, a queued
+// letter always waits for the circuit breaker and the rate limit, and the tokens of its first publish were already spent
+// by the auto-publisher.
+func (pub *Publisher) publishWithConfirmationContextError(
+	ctx context.Context,
+	letter *Letter,
+	receipt *PublishReceipt,
+	queued bool) error {
+
+	enter := pub.enterCircuit
+	throttle := pub.throttle
+	if queued {
+		enter = func() error { return pub.awaitCircuit(ctx) }
+		throttle = pub.throttleQueued
+	}
 
+	letter, err := pub.claimCheck(ctx, letter)
+	if err != nil {
+		return err
+	}
+
+	throttled := queued
 	for {
+		if err := enter(); err != nil {
+			return err
+		}
+
+		if !throttled {
+			if err := throttle(ctx, letter); err != nil {
+				return err
+			}
+		}
+		throttled = false
+
 		// Has to use an Ackable channel for Publish Confirmations.
-		chanHost := pub.ConnectionPool.GetChannelFromPool()
-		chanHost.FlushConfirms() // Flush all previous publish confirmations
+		chanHost, err := pub.getChannel(ctx, true)
+		if err != nil {
+			pub.circuitResult(err)
+			return err
+		}
 
-	Publish:
-		err := chanHost.Channel.Publish(
+		receipt.attempt(chanHost)
+		confirmation, err := chanHost.PublishWithDeferredConfirmation(
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				Priority:      letter.Envelope.Priority,
-				MessageId:     letter.LetterID.String(),
-				CorrelationId: letter.Envelope.CorrelationID,
-				Type:          letter.Envelope.Type,
-				Timestamp:     time.Now().UTC(),
-				AppId:         pub.ConnectionPool.Config.ApplicationName,
-			},
+			newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 		)
+		pub.observePublish(letter, err)
 		if err != nil {
+			pub.circuitResult(err)
+			pub.observePublishRetry(letter)
 			pub.ConnectionPool.ReturnChannel(chanHost, true)
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
-		for {
-			select {
-			case <-ctx.Done():
-				pub.ConnectionPool.ReturnChannel(chanHost, false) // not a channel error
-				return fmt.Errorf("publish confirmation for LetterID: %s wasn't received before context expired - recommend retry/requeue", letter.LetterID.String())
-
-			case confirmation := <-chanHost.Confirmations:
+		pub.ConnectionPool.ReturnChannel(chanHost, false) // others can publish on it while we await our confirmation
 
-				if !confirmation.Ack {
-					goto Publish //nack has occurred, republish
-				}
-
-				pub.ConnectionPool.ReturnChannel(chanHost, false)
-				return nil
+		select {
+		case <-ctx.Done():
+			pub.observeConfirmationTimeout(letter)
+			pub.circuitResult(ErrConfirmTimeout)
+			return newConfirmTimeoutError(letter)
+		case <-confirmation.Done():
+		}
 
-			default:
+		receipt.confirmed(confirmation.ConfirmedAt())
+		pub.observeConfirmation(letter, confirmation.Acked(), receipt.ConfirmLatency)
+		pub.circuitConfirmation(confirmation)
+		if returned := confirmation.Returned(); returned != nil {
+			return newUnroutableError(letter, returned) // republishing won't route it
+		}
 
-				time.Sleep(time.Duration(time.Millisecond * 1)) // limits CPU spin up
-			}
+		if !confirmation.Acked() {
+			pub.observePublishRetry(letter)
+			continue // nacked, or the channel closed before confirming, republish
 		}
+
+		return nil
 	}
 }
 
@@ -439,67 +579,111 @@ func (pub *Publisher) PublishWithConfirmationTransient(letter *Letter, timeout t
 		timeout = pub.publishTimeOutDuration
 	}
 
+	receipt := newPublishReceipt(letter)
+	letter, err := pub.claimCheck(context.Background(), letter)
+	if err != nil {
+		pub.observePublish(letter, err)
+		pub.publishReceipt(receipt, letter, err)
+		return
+	}
+
 	for {
+		if err := pub.enterCircuit(); err != nil {
+			pub.observePublish(letter, err)
+			pub.publishReceipt(receipt, letter, err)
+			return
+		}
+
 		// Has to use an Ackable channel for Publish Confirmations.
-		channel := pub.ConnectionPool.GetTransientChannel(true)
-		confirms := make(chan amqp.Confirmation, 1)
-		channel.NotifyPublish(confirms)
+		channel, err := pub.ConnectionPool.GetTransientChannelContext(context.Background(), true)
+		if err != nil { // the BackoffPolicy ran out of attempts
+			pub.observePublish(letter, err)
+			pub.circuitResult(err)
+			pub.publishReceipt(receipt, letter, err)
+			return
+		}
+		// Confirmations are matched to the publish by its delivery tag.
+		confirms := newConfirmTracker(
+			channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+			channel.NotifyReturn(make(chan amqp.Return, 1)),
+			pub.ConnectionPool.emitReturn)
 
 	Publish:
+		if err := pub.throttle(context.Background(), letter); err != nil {
+			pub.observePublish(letter, err)
+			pub.publishReceipt(receipt, letter, err)
+			channel.Close()
+			return
+		}
+
 		timeoutAfter := time.After(timeout)
-		err := channel.Publish(
+		receipt.attempt(nil)
+		confirmation, err := confirms.publish(
+			channel,
 			letter.Envelope.Exchange,
 			letter.Envelope.RoutingKey,
 			letter.Envelope.Mandatory,
 			letter.Envelope.Immediate,
-			amqp.Publishing{
-				ContentType:   letter.Envelope.ContentType,
-				Body:          letter.Body,
-				Headers:       letter.Envelope.Headers,
-				DeliveryMode:  letter.Envelope.DeliveryMode,
-				Priority:      letter.Envelope.Priority,
-				MessageId:     letter.LetterID.String(),
-				CorrelationId: letter.Envelope.CorrelationID,
-				Type:          letter.Envelope.Type,
-				Timestamp:     time.Now().UTC(),
-				AppId:         pub.ConnectionPool.Config.ApplicationName,
-			},
+			newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
 		)
+		pub.observePublish(letter, err)
 		if err != nil {
+			pub.circuitResult(err)
+			pub.observePublishRetry(letter)
 			channel.Close()
-			if pub.sleepOnErrorInterval < 0 {
+			if pub.sleepOnErrorInterval > 0 {
 				time.Sleep(pub.sleepOnErrorInterval)
 			}
 			continue // Take it again! From the top!
 		}
 
-		// Wait for very next confirmation on this channel, which should be our confirmation.
-		for {
-			select {
-			case <-timeoutAfter:
-				pub.publishReceipt(letter, fmt.Errorf("publish confirmation for LetterID: %s wasn't received in a timely manner (%dms) - recommend retry/requeue", letter.LetterID.String(), timeout))
-				channel.Close()
-				return
-
-			case confirmation := <-confirms:
+		select {
+		case <-timeoutAfter:
+			pub.observeConfirmationTimeout(letter)
+			pub.circuitResult(ErrConfirmTimeout)
+			pub.publishReceipt(receipt, letter, newConfirmTimeoutError(letter))
+			channel.Close()
+			return
+		case <-confirmation.Done():
+		}
 
-				if !confirmation.Ack {
-					goto Publish //nack has occurred, republish
-				}
+		receipt.confirmed(confirmation.ConfirmedAt())
+		pub.observeConfirmation(letter, confirmation.Acked(), receipt.ConfirmLatency)
+		pub.circuitConfirmation(confirmation)
+		if returned := confirmation.Returned(); returned != nil { // the server returns a mandatory publish before acking it
+			pub.publishReceipt(receipt, letter, newUnroutableError(letter, returned))
+			channel.Close()
+			return
+		}
 
-				// Happy Path, publish was received by server and we didn't timeout client side.
-				pub.publishReceipt(letter, nil)
+		if !confirmation.Acked() {
+			pub.observePublishRetry(letter)
+			if confirmation.Err() != nil {
+				channel.Close()
+				continue // the channel closed before confirming, republish on a new one
+			}
+			if err := pub.enterCircuit(); err != nil {
+				pub.publishReceipt(receipt, letter, err)
 				channel.Close()
 				return
-
-			default:
-
-				time.Sleep(time.Duration(time.Millisecond * 4)) // limits CPU spin up
 			}
+			goto Publish //nack has occurred, republish
 		}
+
+		// Happy Path, publish was received by server and we didn't timeout client side.
+		pub.publishReceipt(receipt, letter, nil)
+		channel.Close()
+		return
 	}
 }
 
+// Returns yields the mandatory publishes the server returned as unroutable, from every channel of the ConnectionPool
+// (other Publishers on the same ConnectionPool see them too). With confirmations, a returned publish also fails its
+// PublishReceipt with ErrUnroutable, without confirmations its receipt has already been sent.
+func (pub *Publisher) Returns() <-chan *ReturnMessage {
+	return pub.ConnectionPool.Returns()
+}
+
 // PublishReceipts yields all the success and failures during all publish events. Highly recommend susbscribing to this.
 func (pub *Publisher) PublishReceipts() <-chan *PublishReceipt {
 	return pub.publishReceipts
@@ -511,13 +695,17 @@ func (pub *Publisher) StartAutoPublishing() {
 	defer pub.pubLock.Unlock()
 
 	if !pub.autoStarted {
+		var ctx context.Context
+		ctx, pub.autoCancel = context.WithCancel(context.Background())
+		pub.autoDone = make(chan struct{})
 		pub.autoStarted = true
-		go pub.startAutoPublishingLoop()
+		go pub.startAutoPublishingLoop(ctx, pub.autoDone)
 	}
 }
 
 // StartAutoPublish starts auto-publishing letters queued up - is locking.
-func (pub *Publisher) startAutoPublishingLoop() {
+func (pub *Publisher) startAutoPublishingLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
 
 AutoPublishLoop:
 	for {
@@ -532,7 +720,7 @@ AutoPublishLoop:
 		}
 
 		// Deliver letters queued in the publisher, returns true when we are to stop publishing.
-		if pub.deliverLetters() {
+		if pub.deliverLetters(ctx) {
 			break AutoPublishLoop
 		}
 	}
@@ -542,40 +730,42 @@ AutoPublishLoop:
 	pub.pubLock.Unlock()
 }
 
-func (pub *Publisher) deliverLetters() bool {
+func (pub *Publisher) deliverLetters(ctx context.Context) bool {
 
 	// Allow parallel publishing with transient channels.
-	parallelPublishSemaphore := make(chan struct{}, pub.ConnectionPool.Config.MaxCacheChannelCount/2+1)
+	parallelPublishSemaphore := make(chan struct{}, pub.ConnectionPool.ackableChannelCount/2+1)
 
 	for {
 
 		// Publish the letter.
 	PublishLoop:
 		for {
-			select {
-			case letter := <-pub.letters:
-
-				parallelPublishSemaphore <- struct{}{}
-				go func(letter *Letter) {
-					pub.PublishWithConfirmation(letter, pub.publishTimeOutDuration)
-					<-parallelPublishSemaphore
-				}(letter)
-
-			default:
+			if ctx.Err() != nil {
+				break PublishLoop // shutting down, the letters left stay queued
+			}
 
+			// Urgent letters overtake bulk ones, the highest priority lane is checked first every time.
+			letter := pub.nextLetter()
+			if letter == nil {
 				if pub.sleepOnIdleInterval > 0 {
 					time.Sleep(pub.sleepOnIdleInterval)
 				}
 				break PublishLoop
-
 			}
+
+			_ = pub.throttleQueued(ctx, letter) // only fails once cancelled, then autoPublish gives up on the letter too
+			parallelPublishSemaphore <- struct{}{}
+			pub.autoPublishGroup.Add(1)
+			go func(letter *Letter) {
+				pub.autoPublish(ctx, letter)
+				<-parallelPublishSemaphore
+			}(letter)
 		}
 
 		// Detect if we should stop publishing.
 		select {
 		case stop := <-pub.autoStop:
 			if stop {
-				close(pub.letters)
 				return true
 			}
 		default:
@@ -601,7 +791,7 @@ func (pub *Publisher) QueueLetters(letters []*Letter) bool {
 
 	for _, letter := range letters {
 
-		if ok := pub.safeSend(letter); !ok {
+		if ok := pub.QueueLetter(letter); !ok {
 			return false
 		}
 	}
@@ -610,46 +800,38 @@ func (pub *Publisher) QueueLetters(letters []*Letter) bool {
 }
 
 // QueueLetter queues up a letter that will be consumed by AutoPublish. By default, AutoPublish uses PublishWithConfirmation as the mechanism for publishing.
+// The letter goes in the lowest priority lane, returns false when it wasn't queued (see QueueLetterContext for why).
 func (pub *Publisher) QueueLetter(letter *Letter) bool {
 
-	return pub.safeSend(letter)
+	return pub.QueueLetterContext(context.Background(), letter) == nil
 }
 
-// safeSend should handle a scenario on publishing to a closed channel.
-func (pub *Publisher) safeSend(letter *Letter) (closed bool) {
-	defer func() {
-		if recover() != nil {
-			closed = false
-		}
-	}()
+// publishReceipt finishes the receipt with the outcome of publishing the letter and sends it to the receipt channel.
+func (pub *Publisher) publishReceipt(receipt *PublishReceipt, letter *Letter, err error) {
 
-	pub.letters <- letter
-	return true // success
-}
-
-// publishReceipt sends the status to the receipt channel.
-func (pub *Publisher) publishReceipt(letter *Letter, err error) {
-
-	go func(*Letter, error) {
-		publishReceipt := &PublishReceipt{
-			LetterID: letter.LetterID,
-			Error:    err,
-		}
-
-		if err == nil {
-			publishReceipt.Success = true
-		} else {
-			publishReceipt.FailedLetter = letter
-		}
-
-		pub.publishReceipts <- publishReceipt
-	}(letter, err)
+	receipt.finish(letter, err)
+	go func(receipt *PublishReceipt) {
+		pub.publishReceipts <- receipt
+	}(receipt)
 }
 
 // Shutdown cleanly shutdown the publisher and resets it's internal state.
+// Letters still queued are not published, use ShutdownContext to drain them first.
 func (pub *Publisher) Shutdown(shutdownPools bool) {
 
-	pub.stopAutoPublish()
+	pub.closeQueue()
+
+	pub.pubLock.Lock()
+	cancel, done := pub.autoCancel, pub.autoDone
+	pub.pubLock.Unlock()
+
+	if cancel != nil {
+		cancel() // gives up on letters in flight, they stay in the Outbox to be replayed
+		pub.stopAutoPublish()
+		<-done
+	}
+	pub.autoPublishGroup.Wait() // nothing is marked done in the Outbox once it is closed
+	pub.closeOutbox()
 
 	if shutdownPools { // in case the ChannelPool is shared between structs, you can prevent it from shutting down
 		pub.ConnectionPool.Shutdown()
@@ -1,6 +1,7 @@
 package tcr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -22,6 +23,7 @@ type RabbitService struct {
 	consumers            map[string]*Consumer
 	shutdownSignal       chan bool
 	shutdown             bool
+	publishes            *sync.WaitGroup // publish calls in progress, awaited by ShutdownContext
 	monitorSleepInterval time.Duration
 	serviceLock          *sync.Mutex
 }
@@ -51,7 +53,23 @@ func NewRabbitServiceWithConnectionPool(
 	processPublishReceipts func(*PublishReceipt),
 	processError func(error)) (*RabbitService, error) {
 
-	publisher := NewPublisherFromConfig(config, connectionPool)
+	publisher, err := NewPublisherFromConfigWithError(config, connectionPool)
+	if err != nil {
+		return nil, fmt.Errorf("publisher %w", err)
+	}
+
+	if config.PublisherConfig.OutboxDirectory != "" {
+		outbox, err := OpenOutbox(
+			config.PublisherConfig.OutboxDirectory,
+			int64(config.PublisherConfig.OutboxSegmentSize),
+			config.PublisherConfig.OutboxSync)
+		if err != nil {
+			return nil, fmt.Errorf("publisher %w", err)
+		}
+
+		publisher.SetOutbox(outbox)
+	}
+
 	return NewRabbitServiceWithPublisher(publisher, config, passphrase, salt, processPublishReceipts, processError)
 }
 
@@ -75,6 +93,7 @@ func NewRabbitServiceWithPublisher(
 		shutdownSignal:       make(chan bool, 1),
 		consumers:            make(map[string]*Consumer),
 		monitorSleepInterval: time.Duration(200) * time.Millisecond,
+		publishes:            &sync.WaitGroup{},
 		serviceLock:          &sync.Mutex{},
 	}
 
@@ -84,6 +103,12 @@ func NewRabbitServiceWithPublisher(
 		return nil, err
 	}
 
+	if config.ClaimCheckConfig != nil && config.ClaimCheckConfig.Enabled {
+		if err := rs.setupClaimCheck(config.ClaimCheckConfig); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create a HashKey for Encryption
 	if config.EncryptionConfig.Enabled && len(passphrase) > 0 && len(salt) > 0 {
 		rs.Config.EncryptionConfig.Hashkey = GetHashWithArgon(
@@ -139,6 +164,31 @@ func (rs *RabbitService) createConsumers(consumerConfigs map[string]*ConsumerCon
 	return nil
 }
 
+// setupClaimCheck offloads large bodies of the Publisher to a FileClaimCheckStore and rehydrates them on every consumer.
+func (rs *RabbitService) setupClaimCheck(config *ClaimCheckConfig) error {
+
+	if config.Directory == "" {
+		return errors.New("claim check directory can't be empty")
+	}
+
+	store, err := NewFileClaimCheckStore(config.Directory)
+	if err != nil {
+		return err
+	}
+
+	threshold := config.Threshold
+	if threshold == 0 {
+		threshold = defaultClaimCheckThreshold
+	}
+
+	rs.Publisher.SetClaimCheck(store, threshold)
+	for _, consumer := range rs.consumers {
+		consumer.SetClaimCheck(store, config.DeleteAfterAck)
+	}
+
+	return nil
+}
+
 // PublishWithConfirmation tries to publish and wait for a confirmation.
 func (rs *RabbitService) PublishWithConfirmation(
 	input interface{},
@@ -146,9 +196,10 @@ func (rs *RabbitService) PublishWithConfirmation(
 	wrapPayload bool,
 	headers amqp.Table) error {
 
-	if rs.shutdown {
-		return errors.New("unable to publish as service shutdown triggered")
+	if !rs.enterPublish() {
+		return fmt.Errorf("unable to publish as service %w", ErrShutdown)
 	}
+	defer rs.publishes.Done()
 
 	if input == nil || (exchangeName == "" && routingKey == "") {
 		return errors.New("can't have a nil body or an empty exchangename with empty routing key")
@@ -190,6 +241,61 @@ func (rs *RabbitService) PublishWithConfirmation(
 	return nil
 }
 
+// PublishBatchWithConfirmation creates a payload for every input (optionally wrapped in a ModdedLetter) and publishes them
+// all with confirmations, see Publisher.PublishBatchWithConfirmation.
+// The receipts of the result are in the order of the inputs.
+func (rs *RabbitService) PublishBatchWithConfirmation(
+	ctx context.Context,
+	inputs []interface{},
+	exchangeName, routingKey, metadata string,
+	wrapPayload bool,
+	headers amqp.Table) (BatchResult, error) {
+
+	if !rs.enterPublish() {
+		return BatchResult{}, fmt.Errorf("unable to publish as service %w", ErrShutdown)
+	}
+	defer rs.publishes.Done()
+
+	if exchangeName == "" && routingKey == "" {
+		return BatchResult{}, errors.New("can't have an empty exchangename with empty routing key")
+	}
+
+	letters := make([]*Letter, len(inputs))
+	for i, input := range inputs {
+		if input == nil {
+			return BatchResult{}, fmt.Errorf("can't have a nil input (index %d)", i)
+		}
+
+		var letterID = uuid.New()
+		var data []byte
+		var err error
+		if wrapPayload {
+			data, err = CreateWrappedPayload(input, letterID, metadata, rs.Config.CompressionConfig, rs.Config.EncryptionConfig)
+		} else {
+			data, err = CreatePayload(input, rs.Config.CompressionConfig, rs.Config.EncryptionConfig)
+		}
+		if err != nil {
+			return BatchResult{}, err
+		}
+
+		letters[i] = &Letter{
+			LetterID: letterID,
+			Body:     data,
+			Envelope: &Envelope{
+				Exchange:     exchangeName,
+				RoutingKey:   routingKey,
+				ContentType:  "application/json",
+				Mandatory:    false,
+				Immediate:    false,
+				DeliveryMode: 2,
+				Headers:      headers,
+			},
+		}
+	}
+
+	return rs.Publisher.PublishBatchWithConfirmation(ctx, letters)
+}
+
 // Publish tries to publish directly without retry and data optionally wrapped in a ModdedLetter.
 func (rs *RabbitService) Publish(
 	input interface{},
@@ -197,9 +303,10 @@ func (rs *RabbitService) Publish(
 	wrapPayload bool,
 	headers amqp.Table) error {
 
-	if rs.shutdown {
-		return errors.New("unable to publish as service shutdown triggered")
+	if !rs.enterPublish() {
+		return fmt.Errorf("unable to publish as service %w", ErrShutdown)
 	}
+	defer rs.publishes.Done()
 
 	if input == nil || (exchangeName == "" && routingKey == "") {
 		return errors.New("can't have a nil input or an empty exchangename with empty routing key")
@@ -244,9 +351,10 @@ func (rs *RabbitService) PublishData(
 	exchangeName, routingKey string,
 	headers amqp.Table) error {
 
-	if rs.shutdown {
-		return errors.New("unable to publish as service shutdown triggered")
+	if !rs.enterPublish() {
+		return fmt.Errorf("unable to publish as service %w", ErrShutdown)
 	}
+	defer rs.publishes.Done()
 
 	if data == nil || (exchangeName == "" && routingKey == "") {
 		return errors.New("can't have a nil input or an empty exchangename with empty routing key")
@@ -274,9 +382,10 @@ func (rs *RabbitService) PublishData(
 // PublishLetter wraps around Publisher to simply Publish.
 func (rs *RabbitService) PublishLetter(letter *Letter) error {
 
-	if rs.shutdown {
-		return errors.New("unable to publish as service shutdown triggered")
+	if !rs.enterPublish() {
+		return fmt.Errorf("unable to publish as service %w", ErrShutdown)
 	}
+	defer rs.publishes.Done()
 
 	if letter.LetterID.String() == "" {
 		letter.LetterID = uuid.New()
@@ -287,20 +396,32 @@ func (rs *RabbitService) PublishLetter(letter *Letter) error {
 	return nil
 }
 
+// PublishInTransaction wraps around Publisher to publish the letters atomically, see Publisher.PublishInTransaction.
+func (rs *RabbitService) PublishInTransaction(ctx context.Context, letters []*Letter) error {
+
+	if !rs.enterPublish() {
+		return fmt.Errorf("unable to publish as service %w", ErrShutdown)
+	}
+	defer rs.publishes.Done()
+
+	return rs.Publisher.PublishInTransaction(ctx, letters)
+}
+
 // QueueLetter wraps around AutoPublisher to simply QueueLetter.
 // Error indicates message was not queued.
 func (rs *RabbitService) QueueLetter(letter *Letter) error {
 
-	if rs.shutdown {
-		return errors.New("unable to queue letter as service shutdown triggered")
+	if !rs.enterPublish() {
+		return fmt.Errorf("unable to queue letter as service %w", ErrShutdown)
 	}
+	defer rs.publishes.Done()
 
 	if letter.LetterID.String() == "" {
 		letter.LetterID = uuid.New()
 	}
 
-	if ok := rs.Publisher.QueueLetter(letter); !ok {
-		return errors.New("unable to queue letter... most likely cause is autopublisher chan was shut")
+	if err := rs.Publisher.QueueLetterContext(context.Background(), letter); err != nil {
+		return fmt.Errorf("unable to queue letter: %w", err)
 	}
 
 	return nil
@@ -332,14 +453,12 @@ func (rs *RabbitService) CentralErr() <-chan error {
 }
 
 // Shutdown stops the service and shuts down the ChannelPool.
+// Queued letters and buffered ReceivedMessages are dropped, use ShutdownContext for a graceful shutdown.
 func (rs *RabbitService) Shutdown(stopConsumers bool) {
 
+	rs.signalShutdown()
 	rs.Publisher.Shutdown(false)
 
-	time.Sleep(time.Second)
-	rs.shutdownSignal <- true
-	time.Sleep(time.Second)
-
 	if stopConsumers {
 		for _, consumer := range rs.consumers {
 			err := consumer.StopConsuming(true, true)
@@ -352,13 +471,46 @@ func (rs *RabbitService) Shutdown(stopConsumers bool) {
 	rs.ConnectionPool.Shutdown()
 }
 
+func (rs *RabbitService) isShutdown() bool {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+	return rs.shutdown
+}
+
+// enterPublish returns false once the service is shutting down, otherwise the publish is tracked until publishes.Done.
+func (rs *RabbitService) enterPublish() bool {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if rs.shutdown {
+		return false
+	}
+
+	rs.publishes.Add(1)
+	return true
+}
+
+// signalShutdown stops new publishes right away and the background monitors, only the first signal is needed.
+func (rs *RabbitService) signalShutdown() {
+	rs.serviceLock.Lock()
+	rs.shutdown = true
+	rs.serviceLock.Unlock()
+
+	select {
+	case rs.shutdownSignal <- true:
+	default:
+	}
+}
+
 func (rs *RabbitService) monitorForShutdown() {
 
 MonitorLoop:
 	for {
 		select {
 		case <-rs.shutdownSignal:
+			rs.serviceLock.Lock()
 			rs.shutdown = true
+			rs.serviceLock.Unlock()
 			break MonitorLoop // Prevent leaking goroutine
 		default:
 			time.Sleep(rs.monitorSleepInterval)
@@ -375,7 +527,7 @@ MonitorLoop:
 		for _, consumer := range rs.consumers {
 		IndividualConsumerLoop:
 			for {
-				if rs.shutdown {
+				if rs.isShutdown() {
 					break MonitorLoop // Prevent leaking goroutine
 				}
 
@@ -396,7 +548,7 @@ func (rs *RabbitService) invokeProcessPublishReceipts(processReceipts func(*Publ
 
 ProcessLoop:
 	for {
-		if rs.shutdown {
+		if rs.isShutdown() {
 			break ProcessLoop // Prevent leaking goroutine
 		}
 
@@ -414,15 +566,17 @@ func (rs *RabbitService) processPublishReceipts() {
 
 ProcessLoop:
 	for {
-		if rs.shutdown {
+		if rs.isShutdown() {
 			break ProcessLoop // Prevent leaking goroutine
 		}
 
 		select {
 		case receipt := <-rs.Publisher.PublishReceipts():
 			if !receipt.Success {
-				if receipt.FailedLetter != nil {
-					if receipt.FailedLetter.RetryCount < rs.Config.PublisherConfig.MaxRetryCount {
+				if errors.Is(receipt.Error, ErrQueueFull) {
+					rs.centralErr <- fmt.Errorf("failed to publish LetterID %s, it was dropped from a full lane", receipt.LetterID.String())
+				} else if receipt.FailedLetter != nil {
+					if receipt.FailedLetter.RetryCount < rs.Publisher.maxRetryCount {
 						receipt.FailedLetter.RetryCount++
 						rs.centralErr <- fmt.Errorf("failed to publish LetterID %s... retrying (count: %d)", receipt.LetterID.String(), receipt.FailedLetter.RetryCount)
 						if ok := rs.Publisher.QueueLetter(receipt.FailedLetter); !ok {
@@ -430,6 +584,9 @@ ProcessLoop:
 						}
 					} else {
 						rs.centralErr <- fmt.Errorf("failed to retry publish a LetterID %s, it has exhausted all of it's retries", receipt.LetterID.String())
+						if outbox := rs.Publisher.getOutbox(); outbox != nil {
+							_ = outbox.Discard(receipt.LetterID) // not replayed on restart either
+						}
 					}
 				} else {
 					rs.centralErr <- fmt.Errorf("failed to publish a LetterID %s and unable to retry as a copy of the letter was not received", receipt.LetterID.String())
@@ -447,7 +604,7 @@ func (rs *RabbitService) invokeProcessError(processError func(error)) {
 
 ProcessLoop:
 	for {
-		if rs.shutdown {
+		if rs.isShutdown() {
 			break ProcessLoop // Prevent leaking goroutine
 		}
 
@@ -465,7 +622,7 @@ func (rs *RabbitService) processErrors() {
 
 ProcessLoop:
 	for {
-		if rs.shutdown {
+		if rs.isShutdown() {
 			break ProcessLoop // Prevent leaking goroutine
 		}
 		select {
@@ -0,0 +1,287 @@
+package tcr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// RateLimitWait waits for the rate limit to allow a publish (default).
+	RateLimitWait = "wait"
+
+	// RateLimitFailFast returns ErrRateLimited instead of waiting for the rate limit to allow a publish.
+	RateLimitFailFast = "failfast"
+
+	// RateLimitScopeGlobal shares one limit across every publish of the Publisher (default).
+	RateLimitScopeGlobal = "global"
+
+	// RateLimitScopeExchange gives every exchange its own limit.
+	RateLimitScopeExchange = "exchange"
+
+	// RateLimitScopeRoutingKey gives every exchange and routing key pair its own limit.
+	RateLimitScopeRoutingKey = "routingkey"
+
+	// rateLimitSweepSize is how many scopes a rateLimiter holds before it first evicts the idle ones.
+	rateLimitSweepSize = 1024
+)
+
+func validateRateLimit(config *RateLimitConfig) error {
+
+	if config == nil {
+		return nil
+	}
+
+	switch config.Mode {
+	case "", RateLimitWait, RateLimitFailFast:
+	default:
+		return fmt.Errorf("rate limit mode %q is not supported", config.Mode)
+	}
+
+	switch config.Scope {
+	case "", RateLimitScopeGlobal, RateLimitScopeExchange, RateLimitScopeRoutingKey:
+	default:
+		return fmt.Errorf("rate limit scope %q is not supported", config.Scope)
+	}
+
+	return nil
+}
+
+// tokenBucket refills at rate tokens a second, holding at most one second worth of tokens.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: now}
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+
+	if elapsed := now.Sub(tb.last).Seconds(); elapsed > 0 {
+		tb.tokens += elapsed * tb.rate
+		if tb.tokens > tb.rate {
+			tb.tokens = tb.rate
+		}
+		tb.last = now
+	}
+}
+
+// cost caps a cost at the size of the bucket, a message bigger than a second worth of bytes still gets through.
+func (tb *tokenBucket) cost(n float64) float64 {
+	if n > tb.rate {
+		return tb.rate
+	}
+	return n
+}
+
+// allow reports whether the tokens are available right now.
+func (tb *tokenBucket) allow(n float64) bool {
+	return tb.tokens >= tb.cost(n)
+}
+
+// reserve takes the tokens, going into debt when they aren't available, and returns how long until the debt is paid.
+func (tb *tokenBucket) reserve(n float64) time.Duration {
+
+	tb.tokens -= tb.cost(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// refund gives back tokens reserved by a publish that gave up waiting.
+func (tb *tokenBucket) refund(n float64) {
+	tb.tokens += tb.cost(n)
+}
+
+// full returns true when the bucket has refilled all the way, it is no different from a new one.
+func (tb *tokenBucket) full() bool {
+	return tb == nil || tb.tokens >= tb.rate
+}
+
+// rateBuckets are the message and byte limits of one scope, nil when that limit isn't set.
+type rateBuckets struct {
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func (rb *rateBuckets) refill(now time.Time) {
+	if rb.messages != nil {
+		rb.messages.refill(now)
+	}
+	if rb.bytes != nil {
+		rb.bytes.refill(now)
+	}
+}
+
+// rateLimiter throttles publishing with token buckets per scope of the RateLimitConfig.
+type rateLimiter struct {
+	config  RateLimitConfig
+	buckets map[string]*rateBuckets
+	sweepAt int // evicts the idle scopes once there are this many buckets
+	lock    *sync.Mutex
+}
+
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+
+	if config == nil || (config.MessagesPerSecond == 0 && config.BytesPerSecond == 0) {
+		return nil
+	}
+
+	return &rateLimiter{
+		config:  *config,
+		buckets: make(map[string]*rateBuckets),
+		sweepAt: rateLimitSweepSize,
+		lock:    &sync.Mutex{},
+	}
+}
+
+func (rl *rateLimiter) scope(letter *Letter) string {
+
+	switch rl.config.Scope {
+	case RateLimitScopeExchange:
+		return letter.Envelope.Exchange
+	case RateLimitScopeRoutingKey:
+		return letter.Envelope.Exchange + "\x00" + letter.Envelope.RoutingKey
+	default:
+		return ""
+	}
+}
+
+// bucketsFor returns the buckets of the letter's scope, creating them on first use. The caller holds the lock.
+func (rl *rateLimiter) bucketsFor(letter *Letter, now time.Time) *rateBuckets {
+
+	key := rl.scope(letter)
+	buckets, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= rl.sweepAt {
+			rl.evictIdle(now)
+		}
+
+		buckets = &rateBuckets{}
+		if rl.config.MessagesPerSecond > 0 {
+			buckets.messages = newTokenBucket(float64(rl.config.MessagesPerSecond), now)
+		}
+		if rl.config.BytesPerSecond > 0 {
+			buckets.bytes = newTokenBucket(float64(rl.config.BytesPerSecond), now)
+		}
+		rl.buckets[key] = buckets
+	}
+
+	buckets.refill(now)
+
+	return buckets
+}
+
+// evictIdle drops the buckets that refilled all the way, so high cardinality scopes (ex. routing keys) don't pile up.
+// The next sweep waits for the buckets left to double. The caller holds the lock.
+func (rl *rateLimiter) evictIdle(now time.Time) {
+
+	for key, buckets := range rl.buckets {
+		buckets.refill(now)
+		if buckets.messages.full() && buckets.bytes.full() {
+			delete(rl.buckets, key)
+		}
+	}
+
+	rl.sweepAt = 2 * len(rl.buckets)
+	if rl.sweepAt < rateLimitSweepSize {
+		rl.sweepAt = rateLimitSweepSize
+	}
+}
+
+// take spends the tokens for publishing the letter. Unless failFast, it waits until they are available or the context is done.
+func (rl *rateLimiter) take(ctx context.Context, letter *Letter, failFast bool) error {
+
+	size := float64(len(letter.Body))
+
+	rl.lock.Lock()
+	buckets := rl.bucketsFor(letter, time.Now())
+
+	if failFast &&
+		((buckets.messages != nil && !buckets.messages.allow(1)) || (buckets.bytes != nil && !buckets.bytes.allow(size))) {
+		rl.lock.Unlock()
+		return ErrRateLimited
+	}
+
+	var delay time.Duration
+	if buckets.messages != nil {
+		delay = buckets.messages.reserve(1)
+	}
+	if buckets.bytes != nil {
+		if bytesDelay := buckets.bytes.reserve(size); bytesDelay > delay {
+			delay = bytesDelay
+		}
+	}
+	rl.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		rl.lock.Lock()
+		if buckets.messages != nil {
+			buckets.messages.refund(1)
+		}
+		if buckets.bytes != nil {
+			buckets.bytes.refund(size)
+		}
+		rl.lock.Unlock()
+
+		return fmt.Errorf("rate limited publish of LetterID: %s: %w", letter.LetterID.String(), ctx.Err())
+	}
+}
+
+// SetRateLimit throttles the publishing of the Publisher (auto-publishing included), nil removes the current limit.
+func (pub *Publisher) SetRateLimit(config *RateLimitConfig) error {
+
+	if err := validateRateLimit(config); err != nil {
+		return err
+	}
+
+	pub.pubRWLock.Lock()
+	defer pub.pubRWLock.Unlock()
+	pub.rateLimiter = newRateLimiter(config)
+
+	return nil
+}
+
+func (pub *Publisher) getRateLimiter() *rateLimiter {
+	pub.pubRWLock.RLock()
+	defer pub.pubRWLock.RUnlock()
+	return pub.rateLimiter
+}
+
+// throttle waits for the rate limit of the Publisher to allow publishing the letter, or returns ErrRateLimited with RateLimitFailFast.
+func (pub *Publisher) throttle(ctx context.Context, letter *Letter) error {
+
+	limiter := pub.getRateLimiter()
+	if limiter == nil {
+		return nil
+	}
+
+	return limiter.take(ctx, letter, limiter.config.Mode == RateLimitFailFast)
+}
+
+// throttleQueued waits for the rate limit of the Publisher to allow auto-publishing a queued letter, whatever its mode.
+func (pub *Publisher) throttleQueued(ctx context.Context, letter *Letter) error {
+
+	limiter := pub.getRateLimiter()
+	if limiter == nil {
+		return nil
+	}
+
+	return limiter.take(ctx, letter, false)
+}
@@ -0,0 +1,202 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownPollInterval is how often a graceful shutdown checks whether the Publisher backlog has drained.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// ShutdownReport lists the letters a graceful shutdown could not publish before its context was done.
+type ShutdownReport struct {
+	Unpublished []*Letter // never published: still queued, or failed to publish during the drain
+	InFlight    []*Letter // published but unconfirmed, they may or may not have reached the server
+}
+
+// autoPublish publishes a queued letter with confirmation, giving up when the auto-publisher is cancelled.
+func (pub *Publisher) autoPublish(autoCtx context.Context, letter *Letter) {
+	defer pub.autoPublishGroup.Done()
+	defer atomic.AddInt64(&pub.queued, -1)
+
+	ctx := autoCtx
+	if pub.publishTimeOutDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pub.publishTimeOutDuration)
+		defer cancel()
+	}
+
+	receipt := newPublishReceipt(letter)
+	err := pub.publishWithConfirmationContextError(ctx, letter, receipt, true)
+	if err != nil && autoCtx.Err() != nil {
+		err = newShutdownError(letter)
+	}
+	// Published, or failed for good (republishing won't route it). When this fails, the letter is published again on replay.
+	if outbox := pub.getOutbox(); outbox != nil && (err == nil || errors.Is(err, ErrUnroutable)) {
+		_ = outbox.done(letter.LetterID)
+	}
+	pub.publishReceipt(receipt, letter, err)
+	if err == nil || !pub.isQueueClosed() {
+		return // published, or free to be requeued from its PublishReceipt
+	}
+
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	if autoCtx.Err() != nil {
+		pub.abandoned = append(pub.abandoned, letter)
+	} else {
+		pub.undelivered = append(pub.undelivered, letter)
+	}
+}
+
+// closeQueue stops QueueLetter from accepting letters.
+func (pub *Publisher) closeQueue() {
+	pub.queueLock.Lock()
+	defer pub.queueLock.Unlock()
+
+	if !pub.queueClosed {
+		pub.queueClosed = true
+		close(pub.queueClosing)
+	}
+}
+
+func (pub *Publisher) isQueueClosed() bool {
+	pub.queueLock.RLock()
+	defer pub.queueLock.RUnlock()
+	return pub.queueClosed
+}
+
+// ShutdownContext stops QueueLetter from accepting letters, publishes the queued up letters with confirmations,
+// and waits for letters in flight. The ConnectionPool is left running.
+// When the context is done first, publishing stops and the report lists what was left behind alongside the context error.
+func (pub *Publisher) ShutdownContext(ctx context.Context) (*ShutdownReport, error) {
+
+	pub.StartAutoPublishing() // a backlog is drained even if auto-publishing was never started
+	pub.closeQueue()
+
+	var err error
+	for atomic.LoadInt64(&pub.queued) > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("publisher shutdown: %w", ctx.Err())
+		case <-time.After(shutdownPollInterval):
+		}
+	}
+
+	pub.pubLock.Lock()
+	cancel, done := pub.autoCancel, pub.autoDone
+	pub.pubLock.Unlock()
+
+	cancel() // gives up on letters still in flight
+	pub.stopAutoPublish()
+	<-done
+	pub.autoPublishGroup.Wait()
+	pub.closeOutbox()
+
+	report := &ShutdownReport{}
+	for letter := pub.nextLetter(); letter != nil; letter = pub.nextLetter() {
+		report.Unpublished = append(report.Unpublished, letter)
+		atomic.AddInt64(&pub.queued, -1)
+	}
+
+	pub.pubLock.Lock()
+	report.Unpublished = append(report.Unpublished, pub.undelivered...)
+	report.InFlight = pub.abandoned
+	pub.undelivered, pub.abandoned = nil, nil
+	pub.pubLock.Unlock()
+
+	return report, err
+}
+
+// StopConsumingContext stops the consumer gracefully. The consumer is cancelled on the server, deliveries already
+// on their way are still handed out, and it waits until every ackable message has been acknowledged, nacked, or rejected.
+// Returns the context error when the context is done first.
+func (con *Consumer) StopConsumingContext(ctx context.Context) error {
+	con.conLock.Lock()
+
+	if !con.started {
+		con.conLock.Unlock()
+		return fmt.Errorf("can't stop a stopped consumer")
+	}
+
+	con.stopGraceful = true
+	con.stopDone = ctx.Done()
+	done := con.consumeDone
+	con.consumeStop <- true
+	con.conLock.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("consumer %s shutdown: %w", con.ConsumerName, ctx.Err())
+	}
+
+	settled := make(chan struct{})
+	go func() {
+		con.handlers.Wait()
+		close(settled)
+	}()
+
+	select {
+	case <-settled:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("consumer %s shutdown: %w", con.ConsumerName, ctx.Err())
+	}
+}
+
+// ShutdownContext gracefully stops the service: the publish methods and QueueLetter stop accepting letters right away,
+// the publishes in progress are awaited and the backlog is published with confirmations, consumers are cancelled and
+// their in-flight messages settled, then channels and connections are closed.
+// When the context is done first the report lists the letters that were left behind, and the context error is returned.
+// The ConnectionPool is shut down either way.
+func (rs *RabbitService) ShutdownContext(ctx context.Context) (*ShutdownReport, error) {
+
+	rs.signalShutdown() // publishing through the service stops first
+
+	published := make(chan struct{})
+	go func() {
+		rs.publishes.Wait()
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-ctx.Done():
+	}
+
+	report, err := rs.Publisher.ShutdownContext(ctx)
+
+	wg := &sync.WaitGroup{}
+	consumerErrs := make(chan error, len(rs.consumers))
+	for _, consumer := range rs.consumers {
+		if !consumer.Started() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(consumer *Consumer) {
+			defer wg.Done()
+			if err := consumer.StopConsumingContext(ctx); err != nil {
+				consumerErrs <- err
+			}
+		}(consumer)
+	}
+	wg.Wait()
+	close(consumerErrs)
+
+	for consumerErr := range consumerErrs {
+		if err == nil {
+			err = consumerErr
+		}
+	}
+
+	rs.ConnectionPool.Shutdown()
+
+	return report, err
+}
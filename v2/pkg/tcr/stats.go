@@ -0,0 +1,167 @@
+package tcr
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// waitSampleSize is the number of most recent channel waits kept for percentiles.
+const waitSampleSize = 1024
+
+// PoolStats is a point in time snapshot of a ConnectionPool.
+type PoolStats struct {
+	Connections              []ConnectionStats
+	FlaggedConnections       int
+	Reconnects               uint64
+	CachedChannels           uint64 // ackable and plain
+	ChannelsAvailable        int
+	ChannelsCheckedOut       int
+	AckableChannelsAvailable int
+	PlainChannelsAvailable   int
+	ChannelCheckouts         uint64
+	ChannelReturns           uint64
+	ErroredChannelReturns    uint64
+	ChannelWait              WaitStats
+}
+
+// ConnectionStats is a point in time snapshot of a ConnectionHost in the ConnectionPool.
+type ConnectionStats struct {
+	ConnectionID   uint64
+	Closed         bool
+	Blocked        bool
+	Flagged        bool
+	Reconnects     uint64
+	LastError      error
+	LastErrorTime  time.Time
+	CachedChannels uint64
+}
+
+// WaitStats summarizes how long callers waited for a cached channel over the most recent checkouts.
+type WaitStats struct {
+	Samples int
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+// Stats returns a snapshot of the ConnectionPool, its ConnectionHosts, and channel usage.
+func (cp *ConnectionPool) Stats() PoolStats {
+
+	cp.poolRWLock.RLock()
+	hosts := make([]*ConnectionHost, len(cp.connectionHosts))
+	copy(hosts, cp.connectionHosts)
+	flagged := make(map[uint64]bool, len(cp.flaggedConnections))
+	for id, isFlagged := range cp.flaggedConnections {
+		flagged[id] = isFlagged
+	}
+	cp.poolRWLock.RUnlock()
+
+	ackableAvailable := len(cp.ackableChannels)
+	plainAvailable := len(cp.plainChannels)
+	cached := cp.ackableChannelCount + cp.plainChannelCount
+	stats := PoolStats{
+		Connections:              make([]ConnectionStats, 0, len(hosts)),
+		CachedChannels:           cached,
+		ChannelsAvailable:        ackableAvailable + plainAvailable,
+		ChannelsCheckedOut:       int(cached) - ackableAvailable - plainAvailable,
+		AckableChannelsAvailable: ackableAvailable,
+		PlainChannelsAvailable:   plainAvailable,
+		ChannelCheckouts:         atomic.LoadUint64(&cp.channelCheckouts),
+		ChannelReturns:           atomic.LoadUint64(&cp.channelReturns),
+		ErroredChannelReturns:    atomic.LoadUint64(&cp.erroredReturns),
+		ChannelWait:              cp.channelWaits.stats(),
+	}
+
+	for _, connHost := range hosts {
+		connStats := connHost.stats()
+		connStats.Flagged = flagged[connHost.ConnectionID]
+
+		if connStats.Flagged {
+			stats.FlaggedConnections++
+		}
+		stats.Reconnects += connStats.Reconnects
+		stats.Connections = append(stats.Connections, connStats)
+	}
+
+	return stats
+}
+
+func (ch *ConnectionHost) stats() ConnectionStats {
+
+	ch.stateLock.RLock()
+	defer ch.stateLock.RUnlock()
+
+	return ConnectionStats{
+		ConnectionID:   ch.ConnectionID,
+		Closed:         ch.connection == nil || ch.connection.IsClosed(),
+		Blocked:        ch.blocked,
+		Reconnects:     ch.reconnects,
+		LastError:      ch.lastError,
+		LastErrorTime:  ch.lastErrorTime,
+		CachedChannels: atomic.LoadUint64(&ch.CachedChannelCount),
+	}
+}
+
+// waitSampler keeps a ring of the most recent wait durations.
+type waitSampler struct {
+	lock    *sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newWaitSampler() *waitSampler {
+
+	return &waitSampler{
+		lock:    &sync.Mutex{},
+		samples: make([]time.Duration, 0, waitSampleSize),
+	}
+}
+
+func (ws *waitSampler) record(wait time.Duration) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if len(ws.samples) < waitSampleSize {
+		ws.samples = append(ws.samples, wait)
+		return
+	}
+
+	ws.samples[ws.next] = wait
+	ws.next = (ws.next + 1) % waitSampleSize
+}
+
+func (ws *waitSampler) stats() WaitStats {
+
+	ws.lock.Lock()
+	sorted := make([]time.Duration, len(ws.samples))
+	copy(sorted, ws.samples)
+	ws.lock.Unlock()
+
+	if len(sorted) == 0 {
+		return WaitStats{}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return WaitStats{
+		Samples: len(sorted),
+		P50:     percentile(sorted, 50),
+		P90:     percentile(sorted, 90),
+		P99:     percentile(sorted, 99),
+		Max:     sorted[len(sorted)-1],
+	}
+}
+
+// percentile uses the nearest rank method on sorted samples.
+func percentile(sorted []time.Duration, p int) time.Duration {
+
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -3,7 +3,10 @@ package tcr
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"strings"
 )
 
 // CreateTLSConfig creates a x509 TLS Config for use in TLS-based communication.
@@ -28,3 +31,154 @@ func CreateTLSConfig(pemLocation string, localLocation string) (*tls.Config, err
 	cfg.Certificates = append(cfg.Certificates, cert)
 	return cfg, nil
 }
+
+// NewTLSClientConfig creates the tls.Config used to dial AMQPS from a TLSConfig.
+func NewTLSClientConfig(config *TLSConfig) (*tls.Config, error) {
+
+	cfg := &tls.Config{
+		ServerName:         config.CertServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify, // development only
+	}
+
+	rootCAs, err := loadCertPool(config.PEMCertLocation, config.CACertPEM)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootCAs = rootCAs
+
+	cert, ok, err := loadClientCertificate(config)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cfg.Certificates = []tls.Certificate{cert}
+	} else if config.SASLExternal {
+		return nil, errors.New("tls sasl external requires a client certificate")
+	}
+
+	if cfg.MinVersion, err = parseTLSVersion(config.MinVersion); err != nil {
+		return nil, err
+	}
+
+	if cfg.CipherSuites, err = parseCipherSuites(config.CipherSuites); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadCertPool loads the CA certificates from a file and/or PEM, nil (the system pool) when neither is set.
+func loadCertPool(location string, pem string) (*x509.CertPool, error) {
+
+	if location == "" && pem == "" {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if location != "" {
+		ca, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("tls no ca certificates found in %s", location)
+		}
+	}
+
+	if pem != "" && !pool.AppendCertsFromPEM([]byte(pem)) {
+		return nil, errors.New("tls no ca certificates found in cacertpem")
+	}
+
+	return pool, nil
+}
+
+// loadClientCertificate loads the client certificate from PEM, separate cert/key files, or the legacy combined LocalCertLocation.
+func loadClientCertificate(config *TLSConfig) (tls.Certificate, bool, error) {
+
+	var cert tls.Certificate
+	var err error
+
+	switch {
+	case config.ClientCertPEM != "" || config.ClientKeyPEM != "":
+		cert, err = tls.X509KeyPair([]byte(config.ClientCertPEM), []byte(config.ClientKeyPEM))
+	case config.ClientCertLocation != "":
+		keyLocation := config.ClientKeyLocation
+		if keyLocation == "" {
+			keyLocation = config.ClientCertLocation
+		}
+		cert, err = tls.LoadX509KeyPair(config.ClientCertLocation, keyLocation)
+	case config.LocalCertLocation != "":
+		cert, err = tls.LoadX509KeyPair(config.LocalCertLocation, config.LocalCertLocation)
+	default:
+		return cert, false, nil
+	}
+
+	if err != nil {
+		return cert, false, err
+	}
+
+	return cert, true, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("tls version %q is not supported", version)
+	}
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("tls cipher suite %q is not supported", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// tlsURI upgrades an amqp:// URI to amqps://, keeping the credentials, host, port, and vhost.
+func tlsURI(uri string) string {
+
+	if strings.HasPrefix(uri, "amqp://") {
+		return "amqps://" + strings.TrimPrefix(uri, "amqp://")
+	}
+
+	return uri
+}
+
+// externalAuth is the SASL EXTERNAL mechanism, the server authenticates the client by its TLS certificate.
+type externalAuth struct{}
+
+func (auth *externalAuth) Mechanism() string {
+	return "EXTERNAL"
+}
+
+func (auth *externalAuth) Response() string {
+	return ""
+}
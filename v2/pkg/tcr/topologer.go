@@ -1,6 +1,7 @@
 package tcr
 
 import (
+	"context"
 	"errors"
 
 	"github.com/streadway/amqp"
@@ -29,23 +30,29 @@ func NewTopologer(cp *ConnectionPool) *Topologer {
 
 // BuildTopology builds a topology based on a TopologyConfig - stops on first error.
 func (top *Topologer) BuildTopology(config *TopologyConfig, ignoreErrors bool) error {
+	return top.BuildTopologyContext(context.Background(), config, ignoreErrors)
+}
+
+// BuildTopologyContext builds a topology based on a TopologyConfig - stops on first error.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) BuildTopologyContext(ctx context.Context, config *TopologyConfig, ignoreErrors bool) error {
 
-	err := top.BuildExchanges(config.Exchanges, ignoreErrors)
+	err := top.BuildExchangesContext(ctx, config.Exchanges, ignoreErrors)
 	if err != nil && !ignoreErrors {
 		return err
 	}
 
-	err = top.BuildQueues(config.Queues, ignoreErrors)
+	err = top.BuildQueuesContext(ctx, config.Queues, ignoreErrors)
 	if err != nil && !ignoreErrors {
 		return err
 	}
 
-	err = top.BindQueues(config.QueueBindings, ignoreErrors)
+	err = top.BindQueuesContext(ctx, config.QueueBindings, ignoreErrors)
 	if err != nil && !ignoreErrors {
 		return err
 	}
 
-	err = top.BindExchanges(config.ExchangeBindings, ignoreErrors)
+	err = top.BindExchangesContext(ctx, config.ExchangeBindings, ignoreErrors)
 	if err != nil && !ignoreErrors {
 		return err
 	}
@@ -55,13 +62,19 @@ func (top *Topologer) BuildTopology(config *TopologyConfig, ignoreErrors bool) e
 
 // BuildExchanges loops through and builds Exchanges - stops on first error.
 func (top *Topologer) BuildExchanges(exchanges []*Exchange, ignoreErrors bool) error {
+	return top.BuildExchangesContext(context.Background(), exchanges, ignoreErrors)
+}
+
+// BuildExchangesContext loops through and builds Exchanges - stops on first error.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) BuildExchangesContext(ctx context.Context, exchanges []*Exchange, ignoreErrors bool) error {
 
 	if len(exchanges) == 0 {
 		return nil
 	}
 
 	for _, exchange := range exchanges {
-		err := top.CreateExchangeFromConfig(exchange)
+		err := top.CreateExchangeFromConfigContext(ctx, exchange)
 		if err != nil && !ignoreErrors {
 			return err
 		}
@@ -72,13 +85,19 @@ func (top *Topologer) BuildExchanges(exchanges []*Exchange, ignoreErrors bool) e
 
 // BuildQueues loops through and builds Queues - stops on first error.
 func (top *Topologer) BuildQueues(queues []*Queue, ignoreErrors bool) error {
+	return top.BuildQueuesContext(context.Background(), queues, ignoreErrors)
+}
+
+// BuildQueuesContext loops through and builds Queues - stops on first error.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) BuildQueuesContext(ctx context.Context, queues []*Queue, ignoreErrors bool) error {
 
 	if len(queues) == 0 {
 		return nil
 	}
 
 	for _, queue := range queues {
-		err := top.CreateQueueFromConfig(queue)
+		err := top.CreateQueueFromConfigContext(ctx, queue)
 		if err != nil && !ignoreErrors {
 			return err
 		}
@@ -89,13 +108,19 @@ func (top *Topologer) BuildQueues(queues []*Queue, ignoreErrors bool) error {
 
 // BindQueues loops through and binds Queues to Exchanges - stops on first error.
 func (top *Topologer) BindQueues(bindings []*QueueBinding, ignoreErrors bool) error {
+	return top.BindQueuesContext(context.Background(), bindings, ignoreErrors)
+}
+
+// BindQueuesContext loops through and binds Queues to Exchanges - stops on first error.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) BindQueuesContext(ctx context.Context, bindings []*QueueBinding, ignoreErrors bool) error {
 
 	if len(bindings) == 0 {
 		return nil
 	}
 
 	for _, queueBinding := range bindings {
-		err := top.QueueBind(queueBinding)
+		err := top.QueueBindContext(ctx, queueBinding)
 		if err != nil && !ignoreErrors {
 			return err
 		}
@@ -106,13 +131,19 @@ func (top *Topologer) BindQueues(bindings []*QueueBinding, ignoreErrors bool) er
 
 // BindExchanges loops thrrough and binds Exchanges to Exchanges - stops on first error.
 func (top *Topologer) BindExchanges(bindings []*ExchangeBinding, ignoreErrors bool) error {
+	return top.BindExchangesContext(context.Background(), bindings, ignoreErrors)
+}
+
+// BindExchangesContext loops thrrough and binds Exchanges to Exchanges - stops on first error.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) BindExchangesContext(ctx context.Context, bindings []*ExchangeBinding, ignoreErrors bool) error {
 
 	if len(bindings) == 0 {
 		return nil
 	}
 
 	for _, exchangeBinding := range bindings {
-		err := top.ExchangeBind(exchangeBinding)
+		err := top.ExchangeBindContext(ctx, exchangeBinding)
 		if err != nil && !ignoreErrors {
 			return err
 		}
@@ -127,8 +158,22 @@ func (top *Topologer) CreateExchange(
 	exchangeType string,
 	passiveDeclare, durable, autoDelete, internal, noWait bool,
 	args map[string]interface{}) error {
+	return top.CreateExchangeContext(context.Background(), exchangeName, exchangeType, passiveDeclare, durable, autoDelete, internal, noWait, args)
+}
+
+// CreateExchangeContext builds an Exchange topology.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) CreateExchangeContext(
+	ctx context.Context,
+	exchangeName string,
+	exchangeType string,
+	passiveDeclare, durable, autoDelete, internal, noWait bool,
+	args map[string]interface{}) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	if passiveDeclare {
@@ -140,8 +185,17 @@ func (top *Topologer) CreateExchange(
 
 // CreateExchangeFromConfig builds an Exchange topology from a config Exchange element.
 func (top *Topologer) CreateExchangeFromConfig(exchange *Exchange) error {
+	return top.CreateExchangeFromConfigContext(context.Background(), exchange)
+}
+
+// CreateExchangeFromConfigContext builds an Exchange topology from a config Exchange element.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) CreateExchangeFromConfigContext(ctx context.Context, exchange *Exchange) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	if exchange.PassiveDeclare {
@@ -167,8 +221,17 @@ func (top *Topologer) CreateExchangeFromConfig(exchange *Exchange) error {
 
 // ExchangeBind binds an exchange to an Exchange.
 func (top *Topologer) ExchangeBind(exchangeBinding *ExchangeBinding) error {
+	return top.ExchangeBindContext(context.Background(), exchangeBinding)
+}
+
+// ExchangeBindContext binds an exchange to an Exchange.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) ExchangeBindContext(ctx context.Context, exchangeBinding *ExchangeBinding) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	return channel.ExchangeBind(
@@ -183,8 +246,20 @@ func (top *Topologer) ExchangeBind(exchangeBinding *ExchangeBinding) error {
 func (top *Topologer) ExchangeDelete(
 	exchangeName string,
 	ifUnused, noWait bool) error {
+	return top.ExchangeDeleteContext(context.Background(), exchangeName, ifUnused, noWait)
+}
+
+// ExchangeDeleteContext removes the exchange from the server.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) ExchangeDeleteContext(
+	ctx context.Context,
+	exchangeName string,
+	ifUnused, noWait bool) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	return channel.ExchangeDelete(exchangeName, ifUnused, noWait)
@@ -192,8 +267,17 @@ func (top *Topologer) ExchangeDelete(
 
 // ExchangeUnbind removes the binding of an Exchange to an Exchange.
 func (top *Topologer) ExchangeUnbind(exchangeName, routingKey, parentExchangeName string, noWait bool, args map[string]interface{}) error {
+	return top.ExchangeUnbindContext(context.Background(), exchangeName, routingKey, parentExchangeName, noWait, args)
+}
+
+// ExchangeUnbindContext removes the binding of an Exchange to an Exchange.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) ExchangeUnbindContext(ctx context.Context, exchangeName, routingKey, parentExchangeName string, noWait bool, args map[string]interface{}) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	return channel.ExchangeUnbind(
@@ -213,23 +297,49 @@ func (top *Topologer) CreateQueue(
 	exclusive bool,
 	noWait bool,
 	args map[string]interface{}) error {
+	return top.CreateQueueContext(context.Background(), queueName, passiveDeclare, durable, autoDelete, exclusive, noWait, args)
+}
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+// CreateQueueContext builds a Queue topology.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) CreateQueueContext(
+	ctx context.Context,
+	queueName string,
+	passiveDeclare bool,
+	durable bool,
+	autoDelete bool,
+	exclusive bool,
+	noWait bool,
+	args map[string]interface{}) error {
+
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	if passiveDeclare {
-		_, err := channel.QueueDeclarePassive(queueName, durable, autoDelete, exclusive, noWait, amqp.Table(args))
+		_, err = channel.QueueDeclarePassive(queueName, durable, autoDelete, exclusive, noWait, amqp.Table(args))
 		return err
 	}
 
-	_, err := channel.QueueDeclare(queueName, durable, autoDelete, exclusive, noWait, amqp.Table(args))
+	_, err = channel.QueueDeclare(queueName, durable, autoDelete, exclusive, noWait, amqp.Table(args))
 	return err
 }
 
 // CreateQueueFromConfig builds a Queue topology from a config Exchange element.
 func (top *Topologer) CreateQueueFromConfig(queue *Queue) error {
+	return top.CreateQueueFromConfigContext(context.Background(), queue)
+}
+
+// CreateQueueFromConfigContext builds a Queue topology from a config Exchange element.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) CreateQueueFromConfigContext(ctx context.Context, queue *Queue) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	// classic is automatic and supports all classic properties, quorum type does not so this helps keep things functional
@@ -247,18 +357,27 @@ func (top *Topologer) CreateQueueFromConfig(queue *Queue) error {
 	}
 
 	if queue.PassiveDeclare {
-		_, err := channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
+		_, err = channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
 		return err
 	}
 
-	_, err := channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
+	_, err = channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
 	return err
 }
 
 // QueueDelete removes the queue from the server (and all bindings) and returns messages purged (count).
 func (top *Topologer) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	return top.QueueDeleteContext(context.Background(), name, ifUnused, ifEmpty, noWait)
+}
+
+// QueueDeleteContext removes the queue from the server (and all bindings) and returns messages purged (count).
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) QueueDeleteContext(ctx context.Context, name string, ifUnused, ifEmpty, noWait bool) (int, error) {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return 0, err
+	}
 	defer channel.Close()
 
 	return channel.QueueDelete(name, ifUnused, ifEmpty, noWait)
@@ -266,8 +385,17 @@ func (top *Topologer) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (
 
 // QueueBind binds an Exchange to a Queue.
 func (top *Topologer) QueueBind(queueBinding *QueueBinding) error {
+	return top.QueueBindContext(context.Background(), queueBinding)
+}
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+// QueueBindContext binds an Exchange to a Queue.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) QueueBindContext(ctx context.Context, queueBinding *QueueBinding) error {
+
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	return channel.QueueBind(
@@ -280,6 +408,12 @@ func (top *Topologer) QueueBind(queueBinding *QueueBinding) error {
 
 // PurgeQueues purges each Queue provided.
 func (top *Topologer) PurgeQueues(queueNames []string, noWait bool) (int, error) {
+	return top.PurgeQueuesContext(context.Background(), queueNames, noWait)
+}
+
+// PurgeQueuesContext purges each Queue provided.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) PurgeQueuesContext(ctx context.Context, queueNames []string, noWait bool) (int, error) {
 
 	if len(queueNames) == 0 {
 		return 0, errors.New("can't purge an empty array of queues")
@@ -287,7 +421,7 @@ func (top *Topologer) PurgeQueues(queueNames []string, noWait bool) (int, error)
 
 	total := 0
 	for i := 0; i < len(queueNames); i++ {
-		count, err := top.PurgeQueue(queueNames[i], noWait)
+		count, err := top.PurgeQueueContext(ctx, queueNames[i], noWait)
 		if err != nil {
 			return total, err
 		}
@@ -300,8 +434,17 @@ func (top *Topologer) PurgeQueues(queueNames []string, noWait bool) (int, error)
 
 // PurgeQueue removes all messages from the Queue that are not waiting to be Acknowledged and returns the count.
 func (top *Topologer) PurgeQueue(queueName string, noWait bool) (int, error) {
+	return top.PurgeQueueContext(context.Background(), queueName, noWait)
+}
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+// PurgeQueueContext removes all messages from the Queue that are not waiting to be Acknowledged and returns the count.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) PurgeQueueContext(ctx context.Context, queueName string, noWait bool) (int, error) {
+
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return 0, err
+	}
 	defer channel.Close()
 
 	return channel.QueuePurge(
@@ -311,8 +454,17 @@ func (top *Topologer) PurgeQueue(queueName string, noWait bool) (int, error) {
 
 // UnbindQueue removes the binding of a Queue to an Exchange.
 func (top *Topologer) UnbindQueue(queueName, routingKey, exchangeName string, args map[string]interface{}) error {
+	return top.UnbindQueueContext(context.Background(), queueName, routingKey, exchangeName, args)
+}
+
+// UnbindQueueContext removes the binding of a Queue to an Exchange.
+// Returns an *AcquisitionError when a channel can't be acquired before the context is done.
+func (top *Topologer) UnbindQueueContext(ctx context.Context, queueName, routingKey, exchangeName string, args map[string]interface{}) error {
 
-	channel := top.ConnectionPool.GetTransientChannel(false)
+	channel, err := top.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		return err
+	}
 	defer channel.Close()
 
 	return channel.QueueUnbind(
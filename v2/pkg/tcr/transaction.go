@@ -0,0 +1,125 @@
+package tcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// PublishInTransaction publishes the letters atomically, to as many exchanges as they address, on a dedicated transient
+// channel in transaction mode (tx.select). Either every letter is routed by the commit (tx.commit) or none of them are:
+// the transaction is rolled back (tx.rollback) when a publish fails or the context is done before the commit.
+// When the commit gets no answer from the server (ex. the connection dropped) the error matches ErrTransactionUnknown,
+// the letters may have been committed.
+//
+// The channel never enters the channel caches of the ConnectionPool, a transactional channel can't be put in confirm
+// mode, and it is closed once the transaction is over whatever its outcome. Mandatory letters are not tracked, an
+// unroutable letter is still committed.
+func (pub *Publisher) PublishInTransaction(ctx context.Context, letters []*Letter) error {
+
+	if len(letters) == 0 {
+		return nil
+	}
+
+	if err := pub.enterCircuit(); err != nil {
+		pub.observeTransaction(letters, err)
+		return err
+	}
+
+	published := make([]*Letter, len(letters)) // the letters as published, claim checked ones are copies
+	for i, letter := range letters {
+		letter, err := pub.claimCheck(ctx, letter)
+		if err != nil {
+			pub.observeTransaction(letters, err)
+			return err
+		}
+
+		if err := pub.throttle(ctx, letter); err != nil {
+			pub.observeTransaction(letters, err)
+			return err
+		}
+
+		published[i] = letter
+	}
+
+	channel, err := pub.ConnectionPool.GetTransientChannelContext(ctx, false)
+	if err != nil {
+		pub.observeTransaction(letters, err)
+		pub.circuitResult(err)
+		return err
+	}
+	defer func() {
+		defer func() {
+			_ = recover()
+		}()
+		channel.Close()
+	}()
+
+	err = pub.publishTransaction(ctx, channel, published)
+	pub.observeTransaction(letters, err)
+	pub.circuitResult(err)
+
+	return err
+}
+
+func (pub *Publisher) publishTransaction(ctx context.Context, channel AMQPChannel, letters []*Letter) error {
+
+	if err := channel.Tx(); err != nil {
+		return fmt.Errorf("unable to start transaction: %w", newChannelError(nil, err))
+	}
+
+	for _, letter := range letters {
+		if err := ctx.Err(); err != nil {
+			return pub.rollbackTransaction(channel, fmt.Errorf("publish transaction: %w", err))
+		}
+
+		err := channel.Publish(
+			letter.Envelope.Exchange,
+			letter.Envelope.RoutingKey,
+			letter.Envelope.Mandatory,
+			letter.Envelope.Immediate,
+			newPublishing(letter, pub.ConnectionPool.Config.ApplicationName),
+		)
+		if err != nil {
+			err = fmt.Errorf("unable to publish LetterID: %s in transaction: %w", letter.LetterID.String(), newChannelError(nil, err))
+			return pub.rollbackTransaction(channel, err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return pub.rollbackTransaction(channel, fmt.Errorf("publish transaction: %w", err))
+	}
+
+	if err := channel.TxCommit(); err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Server && amqpErr.Recover { // a channel exception, the server discarded it
+			return fmt.Errorf("unable to commit transaction, none of its letters were published: %w", newChannelError(nil, err))
+		}
+
+		err = fmt.Errorf("%w, its letters may or may not have been published: %s", ErrTransactionUnknown, err.Error())
+		return fmt.Errorf("unable to commit transaction: %w", newChannelError(nil, err))
+	}
+
+	return nil
+}
+
+// rollbackTransaction rolls back the publishes of the transaction, a rollback that fails leaves them to be discarded
+// by the server when the channel is closed.
+func (pub *Publisher) rollbackTransaction(channel AMQPChannel, err error) error {
+
+	if rollbackErr := channel.TxRollback(); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %s)", err, rollbackErr.Error())
+	}
+
+	return err
+}
+
+// observeTransaction reports the outcome of the transaction for each of its letters.
+func (pub *Publisher) observeTransaction(letters []*Letter, err error) {
+
+	for _, letter := range letters {
+		pub.observePublish(letter, err)
+	}
+}
@@ -0,0 +1,117 @@
+package tcr
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Transport dials the AMQP connections managed by a ConnectionHost.
+// The default Transport is streadway/amqp, in-memory brokers (see tcrtest) can be supplied via PoolConfig.
+type Transport interface {
+	Dial(uri string, config amqp.Config) (AMQPConnection, error)
+}
+
+// AMQPConnection is the subset of amqp.Connection used by a ConnectionHost.
+type AMQPConnection interface {
+	Channel() (AMQPChannel, error)
+	Close() error
+	IsClosed() bool
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking
+}
+
+// AMQPChannel mirrors the method set of amqp.Channel so ChannelHosts and transient channels can be backed by any Transport.
+type AMQPChannel interface {
+	Close() error
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	NotifyFlow(c chan bool) chan bool
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyCancel(c chan string) chan string
+	NotifyConfirm(ack, nack chan uint64) (chan uint64, chan uint64)
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Cancel(consumer string, noWait bool) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueInspect(name string) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	QueueUnbind(name, key, exchange string, args amqp.Table) error
+	QueuePurge(name string, noWait bool) (int, error)
+	QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error)
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDelete(name string, ifUnused, noWait bool) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error
+	ExchangeUnbind(destination, key, source string, noWait bool, args amqp.Table) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Get(queue string, autoAck bool) (msg amqp.Delivery, ok bool, err error)
+	Tx() error
+	TxCommit() error
+	TxRollback() error
+	Flow(active bool) error
+	Confirm(noWait bool) error
+	Recover(requeue bool) error
+	Ack(tag uint64, multiple bool) error
+	Nack(tag uint64, multiple bool, requeue bool) error
+	Reject(tag uint64, requeue bool) error
+}
+
+// NewAMQPTransport creates the default Transport, backed by streadway/amqp.
+func NewAMQPTransport() Transport {
+	return &amqpTransport{}
+}
+
+type amqpTransport struct{}
+
+// Dial opens a new amqp.Connection.
+func (at *amqpTransport) Dial(uri string, config amqp.Config) (AMQPConnection, error) {
+
+	conn, err := amqp.DialConfig(uri, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &amqpConnection{Connection: conn}, nil
+}
+
+// amqpConnection adapts amqp.Connection to AMQPConnection.
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+// Channel opens a new amqp.Channel (never returns a typed nil).
+func (ac *amqpConnection) Channel() (AMQPChannel, error) {
+
+	channel, err := ac.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// unwrapConnection returns the amqp.Connection behind an AMQPConnection of the default Transport, nil otherwise.
+func unwrapConnection(conn AMQPConnection) *amqp.Connection {
+	if ac, ok := conn.(*amqpConnection); ok {
+		return ac.Connection
+	}
+	return nil
+}
+
+// unwrapChannel returns the amqp.Channel behind an AMQPChannel of the default Transport, nil otherwise.
+func unwrapChannel(channel AMQPChannel) *amqp.Channel {
+	amqpChannel, _ := channel.(*amqp.Channel)
+	return amqpChannel
+}
+
+func dialConfig(heartbeat, connectionTimeout time.Duration, connectionName string) amqp.Config {
+	return amqp.Config{
+		Heartbeat: heartbeat,
+		Dial:      amqp.DefaultDial(connectionTimeout),
+		Properties: amqp.Table{
+			"connection_name": connectionName,
+		},
+	}
+}
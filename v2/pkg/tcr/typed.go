@@ -0,0 +1,177 @@
+package tcr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TypedPublisher publishes values of T with a Publisher, encoding them with its Codec before compressing and encrypting them.
+type TypedPublisher[T any] struct {
+	Publisher   *Publisher
+	Codec       Codec
+	Compression *CompressionConfig
+	Encryption  *EncryptionConfig
+}
+
+// NewTypedPublisher creates a TypedPublisher on the Publisher, a nil codec encodes values as JSON.
+// The compression and encryption are optional, use the ones of the RabbitSeasoning to match CreatePayload.
+func NewTypedPublisher[T any](
+	publisher *Publisher,
+	codec Codec,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) *TypedPublisher[T] {
+
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	return &TypedPublisher[T]{
+		Publisher:   publisher,
+		Codec:       codec,
+		Compression: compression,
+		Encryption:  encryption,
+	}
+}
+
+// Encode encodes the value with the Codec, then compresses and encrypts it.
+func (tp *TypedPublisher[T]) Encode(value T) ([]byte, error) {
+
+	data, err := tp.Codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return modifyPayload(data, tp.Compression, tp.Encryption)
+}
+
+// Letter encodes the value into a persistent Letter for the exchange and routing key, ready to publish or queue.
+func (tp *TypedPublisher[T]) Letter(value T, exchange, routingKey string) (*Letter, error) {
+
+	body, err := tp.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Letter{
+		LetterID: uuid.New(),
+		Body:     body,
+		Envelope: &Envelope{
+			Exchange:     exchange,
+			RoutingKey:   routingKey,
+			ContentType:  tp.Codec.ContentType(),
+			DeliveryMode: 2,
+		},
+	}, nil
+}
+
+// Publish encodes the value and publishes it, see Publisher.PublishWithError.
+func (tp *TypedPublisher[T]) Publish(value T, exchange, routingKey string, skipReceipt bool) error {
+
+	letter, err := tp.Letter(value, exchange, routingKey)
+	if err != nil {
+		return err
+	}
+
+	return tp.Publisher.PublishWithError(letter, skipReceipt)
+}
+
+// PublishWithConfirmation encodes the value and publishes it with confirmation, see Publisher.PublishWithConfirmationError.
+func (tp *TypedPublisher[T]) PublishWithConfirmation(value T, exchange, routingKey string, timeout time.Duration) error {
+
+	letter, err := tp.Letter(value, exchange, routingKey)
+	if err != nil {
+		return err
+	}
+
+	return tp.Publisher.PublishWithConfirmationError(letter, timeout)
+}
+
+// PublishWithConfirmationContext encodes the value and publishes it with confirmation, see Publisher.PublishWithConfirmationContextError.
+func (tp *TypedPublisher[T]) PublishWithConfirmationContext(ctx context.Context, value T, exchange, routingKey string) error {
+
+	letter, err := tp.Letter(value, exchange, routingKey)
+	if err != nil {
+		return err
+	}
+
+	return tp.Publisher.PublishWithConfirmationContextError(ctx, letter)
+}
+
+// TypedConsumer consumes values of T with a Consumer, decrypting and decompressing every message before decoding it.
+type TypedConsumer[T any] struct {
+	Consumer    *Consumer
+	Codec       Codec // nil picks the codec registered for the content type of each message, JSON when there is none
+	Compression *CompressionConfig
+	Encryption  *EncryptionConfig
+}
+
+// NewTypedConsumer creates a TypedConsumer on the Consumer. The compression and encryption have to match the publisher's.
+func NewTypedConsumer[T any](
+	consumer *Consumer,
+	codec Codec,
+	compression *CompressionConfig,
+	encryption *EncryptionConfig) *TypedConsumer[T] {
+
+	return &TypedConsumer[T]{
+		Consumer:    consumer,
+		Codec:       codec,
+		Compression: compression,
+		Encryption:  encryption,
+	}
+}
+
+// Decode decrypts and decompresses the body of the message, then decodes it as a T.
+func (tc *TypedConsumer[T]) Decode(message *ReceivedMessage) (T, error) {
+
+	var value T
+
+	buffer := bytes.NewBuffer(message.Body)
+	if err := ReadPayload(buffer, tc.Compression, tc.Encryption); err != nil {
+		return value, err
+	}
+
+	err := tc.codecFor(message).Unmarshal(buffer.Bytes(), &value)
+	return value, err
+}
+
+func (tc *TypedConsumer[T]) codecFor(message *ReceivedMessage) Codec {
+
+	if tc.Codec != nil {
+		return tc.Codec
+	}
+
+	if codec := GetCodec(message.Delivery.ContentType); codec != nil {
+		return codec
+	}
+
+	return JSONCodec{}
+}
+
+// StartConsuming starts the Consumer handing every message to the handler decoded, alongside the ReceivedMessage to ack it.
+// A message that fails to decode goes to the Errors of the Consumer instead, and is rejected without requeueing when ackable.
+func (tc *TypedConsumer[T]) StartConsuming(handler func(T, *ReceivedMessage)) {
+
+	con := tc.Consumer
+	con.StartConsumingWithAction(func(message *ReceivedMessage) {
+
+		value, err := tc.Decode(message)
+		if err != nil {
+			err = fmt.Errorf("consumer %s failed to decode MessageID: %s: %w", con.ConsumerName, message.MessageID, err)
+			if observer := con.getObserver(); observer != nil {
+				observer.ObserveConsumerError(con.ConsumerName, err)
+			}
+			con.errors <- err
+
+			if message.IsAckable {
+				_ = message.Reject(false) // it would never decode
+			}
+			return
+		}
+
+		handler(value, message)
+	})
+}
@@ -1,6 +1,9 @@
 package tcr
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // JSONUtcTimestamp quickly creates a string RFC3339 format in UTC
 func JSONUtcTimestamp() string {
@@ -11,3 +14,21 @@ func JSONUtcTimestamp() string {
 func JSONUtcTimestampFromTime(t time.Time) string {
 	return t.Format(time.RFC3339)
 }
+
+// sleepContext sleeps for the duration or until the context is done, returning the context error if it is.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,593 @@
+// Package tcrtest provides an in-memory RabbitMQ broker for testing tcr without a live server.
+//
+// Assign a Broker to PoolConfig.Transport and every ConnectionHost, ChannelHost, Publisher, Consumer,
+// and Topologer created from that pool will talk to it instead of dialing RabbitMQ.
+package tcrtest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/streadway/amqp"
+)
+
+// ErrDialRefused is returned when the Broker has been told to refuse new connections.
+var ErrDialRefused = errors.New("tcrtest: dial refused by broker")
+
+const (
+	// ExchangeDirect is the direct exchange type.
+	ExchangeDirect = "direct"
+
+	// ExchangeFanout is the fanout exchange type.
+	ExchangeFanout = "fanout"
+
+	// ExchangeTopic is the topic exchange type.
+	ExchangeTopic = "topic"
+
+	// ExchangeHeaders is the headers exchange type.
+	ExchangeHeaders = "headers"
+)
+
+// Broker is an in-memory stand-in for a RabbitMQ server and implements tcr.Transport.
+// It supports direct, topic, fanout, and headers routing, exchange to exchange bindings, queues,
+// consumers with prefetch, publisher confirms, mandatory returns, transactions, and
+// connection/channel closure - including server initiated closure for fault injection.
+type Broker struct {
+	lock         *sync.Mutex
+	cond         *sync.Cond
+	exchanges    map[string]*exchange
+	queues       map[string]*queue
+	connections  map[uint64]*Connection
+	connectionID uint64
+	queueID      uint64
+	refuseDials  bool
+	stoppedNodes map[string]bool
+	blocked      bool
+	blockedNodes map[string]bool
+	nackCount    int
+	dropConfirms bool
+	holdConfirms bool
+	users        map[string]string
+}
+
+type exchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	bindings   []*binding
+}
+
+type binding struct {
+	destination string
+	toExchange  bool
+	key         string
+	args        amqp.Table
+}
+
+type queue struct {
+	name       string
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	owner      *Connection
+	args       amqp.Table
+	messages   []*message
+	consumers  []*consumer
+}
+
+type message struct {
+	exchange    string
+	routingKey  string
+	publishing  amqp.Publishing
+	redelivered bool
+}
+
+// NewBroker creates an empty Broker with the default and amq.* exchanges declared.
+func NewBroker() *Broker {
+
+	lock := &sync.Mutex{}
+	b := &Broker{
+		lock:         lock,
+		cond:         sync.NewCond(lock),
+		exchanges:    make(map[string]*exchange),
+		queues:       make(map[string]*queue),
+		connections:  make(map[uint64]*Connection),
+		stoppedNodes: make(map[string]bool),
+		blockedNodes: make(map[string]bool),
+		users:        make(map[string]string),
+	}
+
+	b.exchanges[""] = &exchange{name: "", kind: ExchangeDirect, durable: true}
+	b.exchanges["amq.direct"] = &exchange{name: "amq.direct", kind: ExchangeDirect, durable: true}
+	b.exchanges["amq.fanout"] = &exchange{name: "amq.fanout", kind: ExchangeFanout, durable: true}
+	b.exchanges["amq.topic"] = &exchange{name: "amq.topic", kind: ExchangeTopic, durable: true}
+	b.exchanges["amq.headers"] = &exchange{name: "amq.headers", kind: ExchangeHeaders, durable: true}
+	b.exchanges["amq.match"] = &exchange{name: "amq.match", kind: ExchangeHeaders, durable: true}
+
+	return b
+}
+
+// Dial opens a new in-memory connection, satisfying tcr.Transport.
+func (b *Broker) Dial(uri string, config amqp.Config) (tcr.AMQPConnection, error) {
+
+	parsed, err := amqp.ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	node := nodeAddress(parsed)
+	if b.refuseDials || b.stoppedNodes[node] {
+		return nil, ErrDialRefused
+	}
+
+	username, password := parsed.Username, parsed.Password
+	for _, auth := range config.SASL {
+		if plain, ok := auth.(*amqp.PlainAuth); ok {
+			username, password = plain.Username, plain.Password
+			break
+		}
+	}
+
+	if len(b.users) > 0 && b.users[username] != password {
+		return nil, amqp.ErrCredentials
+	}
+
+	name, _ := config.Properties["connection_name"].(string)
+
+	b.connectionID++
+	conn := &Connection{
+		broker:   b,
+		id:       b.connectionID,
+		name:     name,
+		uri:      uri,
+		node:     node,
+		username: username,
+		password: password,
+		channels: make(map[uint64]*Channel),
+	}
+	b.connections[conn.id] = conn
+
+	return conn, nil
+}
+
+// RefuseDials makes every following Dial fail with ErrDialRefused until called again with false.
+func (b *Broker) RefuseDials(refuse bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refuseDials = refuse
+}
+
+// SetUser sets the password of a user, once any user is set Dial refuses (amqp.ErrCredentials) unknown users and wrong passwords.
+// Like RabbitMQ, connections that are already open are not affected by a password change.
+func (b *Broker) SetUser(username, password string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.users[username] = password
+}
+
+// KillConnections closes every open connection server side (320 CONNECTION_FORCED) and returns how many were closed.
+func (b *Broker) KillConnections() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.killConnectionsLocked("")
+}
+
+// StopNode simulates a cluster node going down, the Broker treats every host:port dialed as a node sharing one set of queues.
+// Connections to the node are closed server side and dials to it are refused until StartNode.
+func (b *Broker) StopNode(node string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stoppedNodes[node] = true
+	return b.killConnectionsLocked(node)
+}
+
+// StartNode brings a node stopped by StopNode back up.
+func (b *Broker) StartNode(node string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.stoppedNodes, node)
+}
+
+// NodeConnectionCount returns the number of open connections to a node (host:port).
+func (b *Broker) NodeConnectionCount(node string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	count := 0
+	for _, conn := range b.connections {
+		if conn.node == node {
+			count++
+		}
+	}
+
+	return count
+}
+
+// killConnectionsLocked closes the connections to a node, or every connection when node is empty.
+func (b *Broker) killConnectionsLocked(node string) int {
+
+	count := 0
+	for _, conn := range b.connections {
+		if node != "" && conn.node != node {
+			continue
+		}
+
+		conn.shutdownLocked(&amqp.Error{
+			Code:    amqp.ConnectionForced,
+			Reason:  "CONNECTION_FORCED - broker forced connection closure with reason 'shutdown'",
+			Server:  true,
+			Recover: true,
+		})
+		count++
+	}
+
+	return count
+}
+
+func nodeAddress(uri amqp.URI) string {
+	return uri.Host + ":" + strconv.Itoa(uri.Port)
+}
+
+// Block emits connection.blocked to every open connection and stalls publishing until Unblock.
+func (b *Broker) Block(reason string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.blocked = true
+	for _, conn := range b.connections {
+		conn.notifyBlockedLocked(amqp.Blocking{Active: true, Reason: reason})
+	}
+}
+
+// Unblock emits connection.unblocked to every open connection and resumes publishing.
+func (b *Broker) Unblock() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.blocked = false
+	for _, conn := range b.connections {
+		conn.notifyBlockedLocked(amqp.Blocking{Active: false})
+	}
+	b.cond.Broadcast()
+}
+
+// BlockNode emits connection.blocked to the open connections of a node (host:port) and stalls their publishing until UnblockNode.
+func (b *Broker) BlockNode(node string, reason string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.blockedNodes[node] = true
+	for _, conn := range b.connections {
+		if conn.node == node {
+			conn.notifyBlockedLocked(amqp.Blocking{Active: true, Reason: reason})
+		}
+	}
+}
+
+// UnblockNode emits connection.unblocked to the open connections of a node and resumes their publishing.
+func (b *Broker) UnblockNode(node string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.blockedNodes, node)
+	for _, conn := range b.connections {
+		if conn.node == node {
+			conn.notifyBlockedLocked(amqp.Blocking{Active: false})
+		}
+	}
+	b.cond.Broadcast()
+}
+
+// NackNext negatively confirms (and drops) the next count publishes made on confirm mode channels.
+func (b *Broker) NackNext(count int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nackCount = count
+}
+
+// DropConfirms routes publishes as normal but never confirms them, useful for simulating confirmation timeouts.
+func (b *Broker) DropConfirms(drop bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.dropConfirms = drop
+}
+
+// HoldConfirms holds back the acks of publishes on confirm mode channels. Releasing them acks everything held on
+// a channel at once, like a multiple ack does: a single confirmation carrying the highest delivery tag.
+func (b *Broker) HoldConfirms(hold bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.holdConfirms = hold
+	if hold {
+		return
+	}
+
+	for _, conn := range b.connections {
+		for _, ch := range conn.channels {
+			if !ch.closed {
+				ch.releaseHeldAckLocked()
+			}
+		}
+	}
+}
+
+// ConnectionCount returns the number of open connections.
+func (b *Broker) ConnectionCount() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.connections)
+}
+
+// ChannelCount returns the number of open channels across all connections.
+func (b *Broker) ChannelCount() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	count := 0
+	for _, conn := range b.connections {
+		count += len(conn.channels)
+	}
+	return count
+}
+
+// HasExchange returns true if the exchange has been declared.
+func (b *Broker) HasExchange(name string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, ok := b.exchanges[name]
+	return ok
+}
+
+// HasQueue returns true if the queue has been declared.
+func (b *Broker) HasQueue(name string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, ok := b.queues[name]
+	return ok
+}
+
+// MessageCount returns the number of messages ready for delivery in a queue.
+func (b *Broker) MessageCount(queueName string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if q, ok := b.queues[queueName]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+// ConsumerCount returns the number of active consumers on a queue.
+func (b *Broker) ConsumerCount(queueName string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if q, ok := b.queues[queueName]; ok {
+		return len(q.consumers)
+	}
+	return 0
+}
+
+// Messages returns a copy of the messages ready for delivery in a queue, oldest first.
+func (b *Broker) Messages(queueName string) []amqp.Publishing {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil
+	}
+
+	publishings := make([]amqp.Publishing, len(q.messages))
+	for i, msg := range q.messages {
+		publishings[i] = msg.publishing
+	}
+	return publishings
+}
+
+// routeLocked finds every queue a message reaches, following exchange to exchange bindings.
+func (b *Broker) routeLocked(exchangeName, key string, headers amqp.Table) []*queue {
+
+	if exchangeName == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*queue{q}
+		}
+		return nil
+	}
+
+	found := make([]*queue, 0)
+	b.routeExchangeLocked(exchangeName, key, headers, make(map[string]bool), make(map[*queue]bool), &found)
+	return found
+}
+
+func (b *Broker) routeExchangeLocked(
+	exchangeName, key string,
+	headers amqp.Table,
+	visited map[string]bool,
+	seen map[*queue]bool,
+	found *[]*queue) {
+
+	ex, ok := b.exchanges[exchangeName]
+	if !ok || visited[exchangeName] {
+		return
+	}
+	visited[exchangeName] = true
+
+	for _, bind := range ex.bindings {
+		if !bindingMatches(ex.kind, bind, key, headers) {
+			continue
+		}
+
+		if bind.toExchange {
+			b.routeExchangeLocked(bind.destination, key, headers, visited, seen, found)
+			continue
+		}
+
+		if q, ok := b.queues[bind.destination]; ok && !seen[q] {
+			seen[q] = true
+			*found = append(*found, q)
+		}
+	}
+}
+
+// enqueueLocked adds a copy of the message to the queue and wakes any waiting consumers.
+func (b *Broker) enqueueLocked(q *queue, exchangeName, key string, publishing amqp.Publishing) {
+
+	body := make([]byte, len(publishing.Body))
+	copy(body, publishing.Body)
+	publishing.Body = body
+
+	q.messages = append(q.messages, &message{
+		exchange:   exchangeName,
+		routingKey: key,
+		publishing: publishing,
+	})
+
+	b.cond.Broadcast()
+}
+
+// deleteQueueLocked removes a queue, its bindings, and cancels its consumers.
+func (b *Broker) deleteQueueLocked(q *queue) int {
+
+	count := len(q.messages)
+	for _, con := range q.consumers {
+		con.channel.notifyCancelLocked(con.tag)
+		con.stopLocked()
+		delete(con.channel.consumers, con.tag)
+	}
+
+	q.consumers = nil
+	q.messages = nil
+	delete(b.queues, q.name)
+
+	for _, ex := range b.exchanges {
+		ex.removeBindings(q.name, false)
+	}
+
+	b.cond.Broadcast()
+	return count
+}
+
+func (ex *exchange) removeBindings(destination string, toExchange bool) {
+
+	bindings := ex.bindings[:0]
+	for _, bind := range ex.bindings {
+		if bind.destination == destination && bind.toExchange == toExchange {
+			continue
+		}
+		bindings = append(bindings, bind)
+	}
+	ex.bindings = bindings
+}
+
+func (ex *exchange) removeBinding(destination, key string, toExchange bool) bool {
+
+	for i, bind := range ex.bindings {
+		if bind.destination == destination && bind.key == key && bind.toExchange == toExchange {
+			ex.bindings = append(ex.bindings[:i], ex.bindings[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (ex *exchange) addBinding(bind *binding) {
+
+	for _, existing := range ex.bindings {
+		if existing.destination == bind.destination && existing.key == bind.key && existing.toExchange == bind.toExchange {
+			return
+		}
+	}
+	ex.bindings = append(ex.bindings, bind)
+}
+
+func validExchangeKind(kind string) bool {
+
+	switch kind {
+	case ExchangeDirect, ExchangeFanout, ExchangeTopic, ExchangeHeaders:
+		return true
+	default:
+		return strings.HasPrefix(kind, "x-")
+	}
+}
+
+func bindingMatches(kind string, bind *binding, key string, headers amqp.Table) bool {
+
+	switch kind {
+	case ExchangeFanout:
+		return true
+	case ExchangeTopic:
+		return topicMatches(strings.Split(bind.key, "."), strings.Split(key, "."))
+	case ExchangeHeaders:
+		return headersMatch(bind.args, headers)
+	default:
+		return bind.key == key
+	}
+}
+
+// topicMatches compares routing key words against a binding pattern where * is exactly one word and # is zero or more.
+func topicMatches(pattern, words []string) bool {
+
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+// headersMatch evaluates headers exchange binding arguments, x-match defaults to all.
+func headersMatch(args amqp.Table, headers amqp.Table) bool {
+
+	matchAny := false
+	if xMatch, ok := args["x-match"].(string); ok {
+		matchAny = strings.HasPrefix(xMatch, "any")
+	}
+
+	total, matched := 0, 0
+	for key, expected := range args {
+		if strings.HasPrefix(key, "x-") {
+			continue
+		}
+
+		total++
+		actual, ok := headers[key]
+		if !ok {
+			continue
+		}
+
+		// A binding argument without a value matches on the presence of the header alone.
+		if expected == nil || fmt.Sprint(expected) == fmt.Sprint(actual) {
+			matched++
+		}
+	}
+
+	if matchAny {
+		return matched > 0
+	}
+
+	return matched == total
+}
@@ -0,0 +1,1032 @@
+package tcrtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// Channel is an in-memory channel on a Connection and implements tcr.AMQPChannel and amqp.Acknowledger.
+type Channel struct {
+	broker      *Broker
+	conn        *Connection
+	id          uint64
+	closed      bool
+	confirm     bool
+	tx          bool
+	txPending   []*pendingPublish
+	publishSeq  uint64
+	heldAck     uint64 // highest delivery tag acked while the broker holds confirms
+	deliveryTag uint64
+	consumerSeq uint64
+	prefetch    int
+	flowActive  bool
+	unacked     map[uint64]*pendingAck
+	consumers   map[string]*consumer
+	closes      []chan *amqp.Error
+	flows       []chan bool
+	returns     []chan amqp.Return
+	cancels     []chan string
+	confirms    []chan amqp.Confirmation
+	outbox      *outbox
+}
+
+type pendingAck struct {
+	queue *queue
+	msg   *message
+}
+
+type pendingPublish struct {
+	exchange   string
+	key        string
+	mandatory  bool
+	publishing amqp.Publishing
+}
+
+// ID returns the channel number on its connection.
+func (ch *Channel) ID() uint64 {
+	return ch.id
+}
+
+// Close closes the channel client side, requeueing unacknowledged deliveries.
+func (ch *Channel) Close() error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	ch.shutdownLocked(nil)
+	return nil
+}
+
+// NotifyClose registers a listener for channel closure.
+func (ch *Channel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		close(c)
+	} else {
+		ch.closes = append(ch.closes, c)
+	}
+
+	return c
+}
+
+// NotifyFlow registers a listener for server flow control (never sent by the in-memory broker).
+func (ch *Channel) NotifyFlow(c chan bool) chan bool {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		close(c)
+	} else {
+		ch.flows = append(ch.flows, c)
+	}
+
+	return c
+}
+
+// NotifyReturn registers a listener for unroutable mandatory publishes.
+func (ch *Channel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		close(c)
+	} else {
+		ch.returns = append(ch.returns, c)
+	}
+
+	return c
+}
+
+// NotifyCancel registers a listener for server side consumer cancellation (ex., queue deleted).
+func (ch *Channel) NotifyCancel(c chan string) chan string {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		close(c)
+	} else {
+		ch.cancels = append(ch.cancels, c)
+	}
+
+	return c
+}
+
+// NotifyConfirm splits publisher confirmations into ack and nack delivery tags.
+func (ch *Channel) NotifyConfirm(ack, nack chan uint64) (chan uint64, chan uint64) {
+
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, cap(ack)+cap(nack)))
+
+	go func() {
+		for confirmation := range confirms {
+			if confirmation.Ack {
+				ack <- confirmation.DeliveryTag
+			} else {
+				nack <- confirmation.DeliveryTag
+			}
+		}
+
+		close(ack)
+		if nack != ack {
+			close(nack)
+		}
+	}()
+
+	return ack, nack
+}
+
+// NotifyPublish registers a listener for publisher confirmations, in publish order.
+func (ch *Channel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		close(confirm)
+	} else {
+		ch.confirms = append(ch.confirms, confirm)
+	}
+
+	return confirm
+}
+
+// Qos sets the prefetch count for consumers on this channel, prefetchSize and global are ignored.
+func (ch *Channel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	ch.prefetch = prefetchCount
+	ch.broker.cond.Broadcast()
+	return nil
+}
+
+// Cancel stops a consumer and closes its deliveries chan.
+func (ch *Channel) Cancel(consumer string, noWait bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if con, ok := ch.consumers[consumer]; ok {
+		ch.broker.detachConsumerLocked(con)
+	}
+
+	return nil
+}
+
+// QueueDeclare declares (or verifies) a queue, an empty name generates one.
+func (ch *Channel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+
+	if name == "" {
+		ch.broker.queueID++
+		name = fmt.Sprintf("amq.gen-%d", ch.broker.queueID)
+	}
+
+	if q, ok := ch.broker.queues[name]; ok {
+		if err := ch.verifyQueueAccessLocked(q); err != nil {
+			return amqp.Queue{}, err
+		}
+
+		if q.durable != durable {
+			return amqp.Queue{}, ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'durable' for queue '%s' in vhost '/'", name))
+		}
+
+		return q.info(), nil
+	}
+
+	if queueType, _ := args["x-queue-type"].(string); queueType == "quorum" && (!durable || exclusive || autoDelete) {
+		return amqp.Queue{}, ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - invalid property for quorum queue '%s' in vhost '/'", name))
+	}
+
+	q := &queue{
+		name:       name,
+		durable:    durable,
+		autoDelete: autoDelete,
+		exclusive:  exclusive,
+		args:       args,
+	}
+	if exclusive {
+		q.owner = ch.conn
+	}
+
+	ch.broker.queues[name] = q
+	return q.info(), nil
+}
+
+// QueueDeclarePassive verifies a queue exists.
+func (ch *Channel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return ch.QueueInspect(name)
+}
+
+// QueueInspect returns the current message and consumer counts of a queue.
+func (ch *Channel) QueueInspect(name string) (amqp.Queue, error) {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	q, err := ch.findQueueLocked(name)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	return q.info(), nil
+}
+
+// QueueBind binds a queue to an exchange.
+func (ch *Channel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	q, ex, err := ch.findBindingTargetsLocked(name, exchange)
+	if err != nil {
+		return err
+	}
+
+	ex.addBinding(&binding{destination: q.name, key: key, args: args})
+	return nil
+}
+
+// QueueUnbind removes a binding between a queue and an exchange.
+func (ch *Channel) QueueUnbind(name, key, exchange string, args amqp.Table) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	q, ex, err := ch.findBindingTargetsLocked(name, exchange)
+	if err != nil {
+		return err
+	}
+
+	ex.removeBinding(q.name, key, false)
+	return nil
+}
+
+// QueuePurge removes all ready messages from a queue and returns the count.
+func (ch *Channel) QueuePurge(name string, noWait bool) (int, error) {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	q, err := ch.findQueueLocked(name)
+	if err != nil {
+		return 0, err
+	}
+
+	count := len(q.messages)
+	q.messages = nil
+	return count, nil
+}
+
+// QueueDelete deletes a queue (deleting a missing queue is a no-op) and returns the count of messages purged.
+func (ch *Channel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return 0, amqp.ErrClosed
+	}
+
+	q, ok := ch.broker.queues[name]
+	if !ok {
+		return 0, nil
+	}
+
+	if err := ch.verifyQueueAccessLocked(q); err != nil {
+		return 0, err
+	}
+
+	if ifUnused && len(q.consumers) > 0 {
+		return 0, ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - queue '%s' in vhost '/' in use", name))
+	}
+
+	if ifEmpty && len(q.messages) > 0 {
+		return 0, ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - queue '%s' in vhost '/' is not empty", name))
+	}
+
+	return ch.broker.deleteQueueLocked(q), nil
+}
+
+// Consume starts delivering messages from a queue, an empty consumer tag generates one.
+func (ch *Channel) Consume(queueName, consumerTag string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	q, err := ch.findQueueLocked(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range q.consumers {
+		if exclusive || existing.exclusive {
+			return nil, ch.failLocked(amqp.AccessRefused, fmt.Sprintf("ACCESS_REFUSED - queue '%s' in vhost '/' in exclusive use", queueName))
+		}
+	}
+
+	if consumerTag == "" {
+		ch.consumerSeq++
+		consumerTag = fmt.Sprintf("ctag-%d.%d.%d", ch.conn.id, ch.id, ch.consumerSeq)
+	}
+
+	if _, ok := ch.consumers[consumerTag]; ok {
+		return nil, ch.failLocked(amqp.NotAllowed, fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumerTag))
+	}
+
+	con := &consumer{
+		tag:        consumerTag,
+		queue:      q,
+		channel:    ch,
+		autoAck:    autoAck,
+		exclusive:  exclusive,
+		deliveries: make(chan amqp.Delivery),
+		done:       make(chan struct{}),
+	}
+
+	q.consumers = append(q.consumers, con)
+	ch.consumers[consumerTag] = con
+
+	go con.run()
+
+	return con.deliveries, nil
+}
+
+// ExchangeDeclare declares (or verifies) an exchange.
+func (ch *Channel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if ex, ok := ch.broker.exchanges[name]; ok {
+		if ex.kind != kind {
+			return ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s' in vhost '/': received '%s' but current is '%s'", name, kind, ex.kind))
+		}
+
+		if ex.durable != durable {
+			return ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'durable' for exchange '%s' in vhost '/'", name))
+		}
+
+		return nil
+	}
+
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return ch.failLocked(amqp.AccessRefused, fmt.Sprintf("ACCESS_REFUSED - exchange name '%s' contains reserved prefix 'amq.*'", name))
+	}
+
+	if !validExchangeKind(kind) {
+		return ch.failLocked(amqp.CommandInvalid, fmt.Sprintf("COMMAND_INVALID - unknown exchange type '%s'", kind))
+	}
+
+	ch.broker.exchanges[name] = &exchange{
+		name:       name,
+		kind:       kind,
+		durable:    durable,
+		autoDelete: autoDelete,
+		internal:   internal,
+	}
+
+	return nil
+}
+
+// ExchangeDeclarePassive verifies an exchange exists.
+func (ch *Channel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	_, err := ch.findExchangeLocked(name)
+	return err
+}
+
+// ExchangeDelete deletes an exchange and every binding to or from it (deleting a missing exchange is a no-op).
+func (ch *Channel) ExchangeDelete(name string, ifUnused, noWait bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return ch.failLocked(amqp.AccessRefused, fmt.Sprintf("ACCESS_REFUSED - operation not permitted on exchange '%s'", name))
+	}
+
+	ex, ok := ch.broker.exchanges[name]
+	if !ok {
+		return nil
+	}
+
+	if ifUnused && len(ex.bindings) > 0 {
+		return ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - exchange '%s' in vhost '/' in use", name))
+	}
+
+	delete(ch.broker.exchanges, name)
+	for _, other := range ch.broker.exchanges {
+		other.removeBindings(name, true)
+	}
+
+	return nil
+}
+
+// ExchangeBind binds the destination exchange to the source exchange.
+func (ch *Channel) ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if _, err := ch.findExchangeLocked(destination); err != nil {
+		return err
+	}
+
+	src, err := ch.findExchangeLocked(source)
+	if err != nil {
+		return err
+	}
+
+	src.addBinding(&binding{destination: destination, toExchange: true, key: key, args: args})
+	return nil
+}
+
+// ExchangeUnbind removes a binding between two exchanges.
+func (ch *Channel) ExchangeUnbind(destination, key, source string, noWait bool, args amqp.Table) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if _, err := ch.findExchangeLocked(destination); err != nil {
+		return err
+	}
+
+	src, err := ch.findExchangeLocked(source)
+	if err != nil {
+		return err
+	}
+
+	src.removeBinding(destination, key, true)
+	return nil
+}
+
+// Publish routes a message, publishing is asynchronous so routing failures close the channel instead of returning an error.
+// Publishing stalls while the broker is blocked, just as RabbitMQ stops reading from blocked connections.
+func (ch *Channel) Publish(exchangeName, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	for (ch.broker.blocked || ch.broker.blockedNodes[ch.conn.node]) && !ch.closed {
+		ch.broker.cond.Wait()
+	}
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if immediate {
+		_ = ch.failLocked(amqp.NotImplemented, "NOT_IMPLEMENTED - immediate=true")
+		return nil
+	}
+
+	ex, ok := ch.broker.exchanges[exchangeName]
+	if !ok {
+		_ = ch.failLocked(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", exchangeName))
+		return nil
+	}
+
+	if ex.internal {
+		_ = ch.failLocked(amqp.AccessRefused, fmt.Sprintf("ACCESS_REFUSED - cannot publish to internal exchange '%s' in vhost '/'", exchangeName))
+		return nil
+	}
+
+	if ch.tx {
+		ch.txPending = append(ch.txPending, &pendingPublish{
+			exchange:   exchangeName,
+			key:        key,
+			mandatory:  mandatory,
+			publishing: msg,
+		})
+		return nil
+	}
+
+	var tag uint64
+	if ch.confirm {
+		ch.publishSeq++
+		tag = ch.publishSeq
+
+		if ch.broker.nackCount > 0 {
+			ch.broker.nackCount--
+			ch.releaseHeldAckLocked() // confirmations stay in delivery tag order
+			ch.pushConfirmLocked(tag, false)
+			return nil
+		}
+	}
+
+	ch.routeLocked(exchangeName, key, mandatory, msg)
+
+	if ch.confirm && !ch.broker.dropConfirms {
+		if ch.broker.holdConfirms {
+			ch.heldAck = tag
+		} else {
+			ch.pushConfirmLocked(tag, true)
+		}
+	}
+
+	return nil
+}
+
+// Get pops a single message from a queue.
+func (ch *Channel) Get(queueName string, autoAck bool) (amqp.Delivery, bool, error) {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	q, err := ch.findQueueLocked(queueName)
+	if err != nil {
+		return amqp.Delivery{}, false, err
+	}
+
+	if len(q.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+
+	msg := q.pop()
+	tag := ch.nextDeliveryTagLocked()
+	if !autoAck {
+		ch.unacked[tag] = &pendingAck{queue: q, msg: msg}
+	}
+
+	delivery := msg.delivery(ch, tag, "")
+	delivery.MessageCount = uint32(len(q.messages))
+
+	return delivery, true, nil
+}
+
+// Tx puts the channel in transaction mode, only publishes are transactional in the in-memory broker.
+func (ch *Channel) Tx() error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if ch.confirm {
+		return ch.failLocked(amqp.PreconditionFailed, "PRECONDITION_FAILED - cannot switch from confirm to tx mode")
+	}
+
+	ch.tx = true
+	return nil
+}
+
+// TxCommit routes every publish made since the last commit or rollback.
+func (ch *Channel) TxCommit() error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if !ch.tx {
+		return ch.failLocked(amqp.PreconditionFailed, "PRECONDITION_FAILED - channel is not transactional")
+	}
+
+	for _, pending := range ch.txPending {
+		ch.routeLocked(pending.exchange, pending.key, pending.mandatory, pending.publishing)
+	}
+	ch.txPending = nil
+
+	return nil
+}
+
+// TxRollback discards every publish made since the last commit or rollback.
+func (ch *Channel) TxRollback() error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if !ch.tx {
+		return ch.failLocked(amqp.PreconditionFailed, "PRECONDITION_FAILED - channel is not transactional")
+	}
+
+	ch.txPending = nil
+	return nil
+}
+
+// Flow pauses (or resumes) deliveries to consumers on this channel.
+func (ch *Channel) Flow(active bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	ch.flowActive = active
+	ch.broker.cond.Broadcast()
+	return nil
+}
+
+// Confirm puts the channel in publisher confirm mode.
+func (ch *Channel) Confirm(noWait bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	if ch.tx {
+		return ch.failLocked(amqp.PreconditionFailed, "PRECONDITION_FAILED - cannot switch from tx to confirm mode")
+	}
+
+	ch.confirm = true
+	return nil
+}
+
+// Recover requeues every unacknowledged delivery on this channel.
+func (ch *Channel) Recover(requeue bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	ch.requeueLocked(ch.unackedTagsLocked(0, true))
+	return nil
+}
+
+// Ack acknowledges one (or multiple) deliveries, an unknown tag closes the channel.
+func (ch *Channel) Ack(tag uint64, multiple bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	tags := ch.unackedTagsLocked(tag, multiple)
+	if len(tags) == 0 {
+		_ = ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag))
+		return nil
+	}
+
+	for _, t := range tags {
+		delete(ch.unacked, t)
+	}
+
+	ch.broker.cond.Broadcast()
+	return nil
+}
+
+// Nack negatively acknowledges one (or multiple) deliveries, requeueing or dropping them.
+func (ch *Channel) Nack(tag uint64, multiple bool, requeue bool) error {
+	ch.broker.lock.Lock()
+	defer ch.broker.lock.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+
+	tags := ch.unackedTagsLocked(tag, multiple)
+	if len(tags) == 0 {
+		_ = ch.failLocked(amqp.PreconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag))
+		return nil
+	}
+
+	if requeue {
+		ch.requeueLocked(tags)
+		return nil
+	}
+
+	for _, t := range tags {
+		delete(ch.unacked, t)
+	}
+
+	ch.broker.cond.Broadcast()
+	return nil
+}
+
+// Reject negatively acknowledges a single delivery.
+func (ch *Channel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+// routeLocked delivers a message to every bound queue, returning it when mandatory and unroutable.
+func (ch *Channel) routeLocked(exchangeName, key string, mandatory bool, msg amqp.Publishing) {
+
+	queues := ch.broker.routeLocked(exchangeName, key, msg.Headers)
+	if len(queues) == 0 {
+		if mandatory {
+			ch.pushReturnLocked(exchangeName, key, msg)
+		}
+		return
+	}
+
+	for _, q := range queues {
+		ch.broker.enqueueLocked(q, exchangeName, key, msg)
+	}
+}
+
+func (ch *Channel) pushConfirmLocked(tag uint64, ack bool) {
+
+	confirmation := amqp.Confirmation{DeliveryTag: tag, Ack: ack}
+	listeners := append([]chan amqp.Confirmation(nil), ch.confirms...)
+
+	ch.outbox.push(func(done <-chan struct{}) {
+		for _, listener := range listeners {
+			select {
+			case listener <- confirmation:
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+// releaseHeldAckLocked sends the acks held back by Broker.HoldConfirms as a single multiple ack.
+func (ch *Channel) releaseHeldAckLocked() {
+
+	if ch.heldAck == 0 {
+		return
+	}
+
+	ch.pushConfirmLocked(ch.heldAck, true)
+	ch.heldAck = 0
+}
+
+func (ch *Channel) pushReturnLocked(exchangeName, key string, msg amqp.Publishing) {
+
+	ret := amqp.Return{
+		ReplyCode:       amqp.NoRoute,
+		ReplyText:       "NO_ROUTE",
+		Exchange:        exchangeName,
+		RoutingKey:      key,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		Headers:         msg.Headers,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+	listeners := append([]chan amqp.Return(nil), ch.returns...)
+
+	ch.outbox.push(func(done <-chan struct{}) {
+		for _, listener := range listeners {
+			select {
+			case listener <- ret:
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+func (ch *Channel) notifyCancelLocked(consumerTag string) {
+
+	listeners := append([]chan string(nil), ch.cancels...)
+
+	ch.outbox.push(func(done <-chan struct{}) {
+		for _, listener := range listeners {
+			select {
+			case listener <- consumerTag:
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+func (ch *Channel) nextDeliveryTagLocked() uint64 {
+	ch.deliveryTag++
+	return ch.deliveryTag
+}
+
+// unackedTagsLocked resolves delivery tags in ascending order, multiple includes every tag up to (and including) tag, zero meaning all.
+func (ch *Channel) unackedTagsLocked(tag uint64, multiple bool) []uint64 {
+
+	tags := make([]uint64, 0)
+	if !multiple {
+		if _, ok := ch.unacked[tag]; ok {
+			tags = append(tags, tag)
+		}
+		return tags
+	}
+
+	for t := range ch.unacked {
+		if tag == 0 || t <= tag {
+			tags = append(tags, t)
+		}
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
+
+// requeueLocked puts unacknowledged deliveries back at the head of their queues, flagged as redelivered.
+func (ch *Channel) requeueLocked(tags []uint64) {
+
+	for i := len(tags) - 1; i >= 0; i-- {
+		pending, ok := ch.unacked[tags[i]]
+		if !ok {
+			continue
+		}
+		delete(ch.unacked, tags[i])
+
+		if current, ok := ch.broker.queues[pending.queue.name]; ok && current == pending.queue {
+			pending.msg.redelivered = true
+			current.messages = append([]*message{pending.msg}, current.messages...)
+		}
+	}
+
+	ch.broker.cond.Broadcast()
+}
+
+// failLocked closes the channel with a server error (channel exception) and returns it.
+func (ch *Channel) failLocked(code int, reason string) error {
+
+	err := &amqp.Error{
+		Code:   code,
+		Reason: reason,
+		Server: true,
+	}
+
+	ch.shutdownLocked(err)
+	return err
+}
+
+func (ch *Channel) shutdownLocked(err *amqp.Error) {
+
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+
+	for _, con := range ch.consumers {
+		ch.broker.detachConsumerLocked(con)
+	}
+
+	ch.requeueLocked(ch.unackedTagsLocked(0, true))
+	ch.txPending = nil
+
+	// Stop notifications before closing listeners so nothing is ever sent on a closed chan.
+	ch.outbox.close()
+
+	for _, receiver := range ch.closes {
+		if err != nil {
+			select {
+			case receiver <- err:
+			default:
+			}
+		}
+		close(receiver)
+	}
+	ch.closes = nil
+
+	for _, receiver := range ch.confirms {
+		close(receiver)
+	}
+	ch.confirms = nil
+
+	for _, receiver := range ch.returns {
+		close(receiver)
+	}
+	ch.returns = nil
+
+	for _, receiver := range ch.cancels {
+		close(receiver)
+	}
+	ch.cancels = nil
+
+	for _, receiver := range ch.flows {
+		close(receiver)
+	}
+	ch.flows = nil
+
+	delete(ch.conn.channels, ch.id)
+	ch.broker.cond.Broadcast()
+}
+
+func (ch *Channel) findQueueLocked(name string) (*queue, error) {
+
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	q, ok := ch.broker.queues[name]
+	if !ok {
+		return nil, ch.failLocked(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name))
+	}
+
+	if err := ch.verifyQueueAccessLocked(q); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (ch *Channel) findExchangeLocked(name string) (*exchange, error) {
+
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	ex, ok := ch.broker.exchanges[name]
+	if !ok {
+		return nil, ch.failLocked(amqp.NotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", name))
+	}
+
+	return ex, nil
+}
+
+func (ch *Channel) findBindingTargetsLocked(queueName, exchangeName string) (*queue, *exchange, error) {
+
+	q, err := ch.findQueueLocked(queueName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if exchangeName == "" {
+		return nil, nil, ch.failLocked(amqp.AccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+	}
+
+	ex, err := ch.findExchangeLocked(exchangeName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return q, ex, nil
+}
+
+func (ch *Channel) verifyQueueAccessLocked(q *queue) error {
+
+	if q.exclusive && q.owner != ch.conn {
+		return ch.failLocked(amqp.ResourceLocked, fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s' in vhost '/'", q.name))
+	}
+
+	return nil
+}
+
+func (q *queue) info() amqp.Queue {
+	return amqp.Queue{
+		Name:      q.name,
+		Messages:  len(q.messages),
+		Consumers: len(q.consumers),
+	}
+}
+
+func (q *queue) pop() *message {
+
+	msg := q.messages[0]
+	q.messages[0] = nil
+	q.messages = q.messages[1:]
+	return msg
+}
+
+func (msg *message) delivery(ch *Channel, tag uint64, consumerTag string) amqp.Delivery {
+
+	return amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         msg.publishing.Headers,
+		ContentType:     msg.publishing.ContentType,
+		ContentEncoding: msg.publishing.ContentEncoding,
+		DeliveryMode:    msg.publishing.DeliveryMode,
+		Priority:        msg.publishing.Priority,
+		CorrelationId:   msg.publishing.CorrelationId,
+		ReplyTo:         msg.publishing.ReplyTo,
+		Expiration:      msg.publishing.Expiration,
+		MessageId:       msg.publishing.MessageId,
+		Timestamp:       msg.publishing.Timestamp,
+		Type:            msg.publishing.Type,
+		UserId:          msg.publishing.UserId,
+		AppId:           msg.publishing.AppId,
+		ConsumerTag:     consumerTag,
+		DeliveryTag:     tag,
+		Redelivered:     msg.redelivered,
+		Exchange:        msg.exchange,
+		RoutingKey:      msg.routingKey,
+		Body:            msg.publishing.Body,
+	}
+}
@@ -0,0 +1,192 @@
+package tcrtest
+
+import (
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/streadway/amqp"
+)
+
+// Connection is an in-memory connection to a Broker and implements tcr.AMQPConnection.
+type Connection struct {
+	broker    *Broker
+	id        uint64
+	name      string
+	uri       string
+	node      string
+	username  string
+	password  string
+	closed    bool
+	channels  map[uint64]*Channel
+	channelID uint64
+	closes    []chan *amqp.Error
+	blocks    []chan amqp.Blocking
+}
+
+// ID returns the broker assigned identifier for this connection.
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// Name returns the connection_name client property provided on Dial.
+func (c *Connection) Name() string {
+	return c.name
+}
+
+// URI returns the URI provided on Dial.
+func (c *Connection) URI() string {
+	return c.uri
+}
+
+// Node returns the host:port of the node dialed.
+func (c *Connection) Node() string {
+	return c.node
+}
+
+// Username returns the user the connection authenticated as.
+func (c *Connection) Username() string {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.username
+}
+
+// Password returns the password (or token) the connection authenticated with, or was last updated to.
+func (c *Connection) Password() string {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.password
+}
+
+// UpdateSecret replaces the password of the open connection (connection.update-secret), satisfying tcr.SecretUpdater.
+func (c *Connection) UpdateSecret(newSecret, reason string) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	if len(c.broker.users) > 0 && c.broker.users[c.username] != newSecret {
+		return amqp.ErrCredentials
+	}
+
+	c.password = newSecret
+	return nil
+}
+
+// Channel opens a new in-memory channel.
+func (c *Connection) Channel() (tcr.AMQPChannel, error) {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	c.channelID++
+	ch := &Channel{
+		broker:     c.broker,
+		conn:       c,
+		id:         c.channelID,
+		flowActive: true,
+		unacked:    make(map[uint64]*pendingAck),
+		consumers:  make(map[string]*consumer),
+		outbox:     newOutbox(),
+	}
+	c.channels[ch.id] = ch
+
+	return ch, nil
+}
+
+// Close closes the connection and all of its channels client side.
+func (c *Connection) Close() error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+
+	if c.closed {
+		return amqp.ErrClosed
+	}
+
+	c.shutdownLocked(nil)
+	return nil
+}
+
+// IsClosed returns true once the connection has been closed by either side.
+func (c *Connection) IsClosed() bool {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.closed
+}
+
+// NotifyClose registers a listener for connection closure, closed immediately if the connection already is.
+func (c *Connection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+
+	if c.closed {
+		close(receiver)
+	} else {
+		c.closes = append(c.closes, receiver)
+	}
+
+	return receiver
+}
+
+// NotifyBlocked registers a listener for connection.blocked and connection.unblocked.
+func (c *Connection) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+
+	if c.closed {
+		close(receiver)
+	} else {
+		c.blocks = append(c.blocks, receiver)
+	}
+
+	return receiver
+}
+
+func (c *Connection) notifyBlockedLocked(blocking amqp.Blocking) {
+	for _, receiver := range c.blocks {
+		select {
+		case receiver <- blocking:
+		default: // never stall the broker on a full listener
+		}
+	}
+}
+
+// shutdownLocked closes all channels, sends err (when server initiated) to listeners, and drops exclusive queues.
+func (c *Connection) shutdownLocked(err *amqp.Error) {
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	for _, ch := range c.channels {
+		ch.shutdownLocked(err)
+	}
+
+	for _, receiver := range c.closes {
+		if err != nil {
+			select {
+			case receiver <- err:
+			default:
+			}
+		}
+		close(receiver)
+	}
+	c.closes = nil
+
+	for _, receiver := range c.blocks {
+		close(receiver)
+	}
+	c.blocks = nil
+
+	for _, q := range c.broker.queues {
+		if q.exclusive && q.owner == c {
+			c.broker.deleteQueueLocked(q)
+		}
+	}
+
+	delete(c.broker.connections, c.id)
+	c.broker.cond.Broadcast()
+}
@@ -0,0 +1,110 @@
+package tcrtest
+
+import "github.com/streadway/amqp"
+
+// consumer pushes messages from a queue to the deliveries chan returned by Channel.Consume.
+type consumer struct {
+	tag        string
+	queue      *queue
+	channel    *Channel
+	autoAck    bool
+	exclusive  bool
+	deliveries chan amqp.Delivery
+	done       chan struct{}
+	stopped    bool
+}
+
+func (con *consumer) run() {
+	defer close(con.deliveries)
+
+	b := con.channel.broker
+	for {
+		b.lock.Lock()
+		for !con.stopped && !con.readyLocked() {
+			b.cond.Wait()
+		}
+
+		if con.stopped {
+			b.lock.Unlock()
+			return
+		}
+
+		msg := con.queue.pop()
+		tag := con.channel.nextDeliveryTagLocked()
+		if !con.autoAck {
+			con.channel.unacked[tag] = &pendingAck{queue: con.queue, msg: msg}
+		}
+		delivery := msg.delivery(con.channel, tag, con.tag)
+		b.lock.Unlock()
+
+		select {
+		case con.deliveries <- delivery:
+		case <-con.done:
+			con.recall(tag, msg)
+			return
+		}
+	}
+}
+
+// recall puts back a message that was popped but never handed to the application.
+func (con *consumer) recall(tag uint64, msg *message) {
+
+	b := con.channel.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !con.autoAck {
+		// Channel closure may have already requeued it.
+		if _, ok := con.channel.unacked[tag]; ok {
+			delete(con.channel.unacked, tag)
+		} else {
+			return
+		}
+	}
+
+	if current, ok := b.queues[con.queue.name]; ok && current == con.queue {
+		current.messages = append([]*message{msg}, current.messages...)
+		b.cond.Broadcast()
+	}
+}
+
+func (con *consumer) readyLocked() bool {
+
+	if len(con.queue.messages) == 0 || !con.channel.flowActive {
+		return false
+	}
+
+	return con.autoAck || con.channel.prefetch <= 0 || len(con.channel.unacked) < con.channel.prefetch
+}
+
+func (con *consumer) stopLocked() {
+
+	if con.stopped {
+		return
+	}
+
+	con.stopped = true
+	close(con.done)
+	con.channel.broker.cond.Broadcast()
+}
+
+// detachConsumerLocked stops a consumer, removing it from its queue and channel, auto-delete queues go with their last consumer.
+func (b *Broker) detachConsumerLocked(con *consumer) {
+
+	con.stopLocked()
+	delete(con.channel.consumers, con.tag)
+
+	q := con.queue
+	for i, existing := range q.consumers {
+		if existing == con {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+
+	if q.autoDelete && len(q.consumers) == 0 {
+		if current, ok := b.queues[q.name]; ok && current == q {
+			b.deleteQueueLocked(q)
+		}
+	}
+}
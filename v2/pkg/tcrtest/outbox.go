@@ -0,0 +1,97 @@
+package tcrtest
+
+import "sync"
+
+// outbox serializes asynchronous notifications (confirms, returns, cancels) for a single channel.
+// Order is preserved and the publisher is never blocked by a slow listener, much like the frame reader in streadway/amqp.
+// The sending goroutine is only started by the first notification.
+type outbox struct {
+	lock    *sync.Mutex
+	started bool
+	closed  bool
+	pending []func(done <-chan struct{})
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newOutbox() *outbox {
+
+	return &outbox{
+		lock:    &sync.Mutex{},
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (o *outbox) push(send func(done <-chan struct{})) {
+
+	o.lock.Lock()
+	if o.closed {
+		o.lock.Unlock()
+		return
+	}
+
+	o.pending = append(o.pending, send)
+	if !o.started {
+		o.started = true
+		go o.run()
+	}
+	o.lock.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) run() {
+	defer close(o.stopped)
+
+	for {
+		o.lock.Lock()
+		if len(o.pending) == 0 {
+			o.lock.Unlock()
+
+			select {
+			case <-o.wake:
+				continue
+			case <-o.done:
+				return
+			}
+		}
+
+		send := o.pending[0]
+		o.pending[0] = nil
+		o.pending = o.pending[1:]
+		o.lock.Unlock()
+
+		send(o.done)
+
+		select {
+		case <-o.done:
+			return
+		default:
+		}
+	}
+}
+
+// close drops undelivered notifications and waits for the sender to exit so listeners can be safely closed.
+func (o *outbox) close() {
+
+	o.lock.Lock()
+	if o.closed {
+		o.lock.Unlock()
+		return
+	}
+
+	o.closed = true
+	started := o.started
+	o.lock.Unlock()
+
+	close(o.done)
+	if started {
+		<-o.stopped
+	}
+}
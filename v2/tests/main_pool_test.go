@@ -69,7 +69,7 @@ func TestCreateConnectionPoolAndGetAckableChannel(t *testing.T) {
 	cp, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
 	assert.NoError(t, err)
 
-	chanHost := cp.GetChannelFromPool()
+	chanHost := cp.GetChannelFromPool(true)
 	assert.NotNil(t, chanHost)
 
 	cp.Shutdown()
@@ -84,7 +84,7 @@ func TestCreateConnectionPoolAndGetChannel(t *testing.T) {
 	cp, err := tcr.NewConnectionPool(Seasoning.PoolConfig)
 	assert.NoError(t, err)
 
-	chanHost := cp.GetChannelFromPool()
+	chanHost := cp.GetChannelFromPool(true)
 	assert.NotNil(t, chanHost)
 	chanHost.Close()
 
@@ -111,7 +111,7 @@ func TestConnectionGetChannelAndReturnLoop(t *testing.T) {
 
 	for i := 0; i < 1000000; i++ {
 
-		chanHost := ConnectionPool.GetChannelFromPool()
+		chanHost := ConnectionPool.GetChannelFromPool(true)
 
 		ConnectionPool.ReturnChannel(chanHost, false)
 	}
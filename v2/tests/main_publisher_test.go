@@ -30,7 +30,7 @@ func TestBasicPublish(t *testing.T) {
 
 	// Test
 	timeStart = time.Now()
-	amqpConn, err := amqp.Dial(Seasoning.PoolConfig.URI)
+	amqpConn, err := dial()
 	if err != nil {
 		return
 	}
@@ -77,7 +77,7 @@ func TestBasicPublishToNonExistentExchange(t *testing.T) {
 	defer leaktest.Check(t)()
 
 	letter := tcr.CreateMockLetter("DoesNotExist", "TcrTestQueue", nil)
-	amqpConn, err := amqp.Dial(Seasoning.PoolConfig.URI)
+	amqpConn, err := dial()
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -119,7 +119,7 @@ func TestCreatePublisherAndPublish(t *testing.T) {
 	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
 	publisher.Publish(letter, false)
 
-	ConnectionPool.Shutdown()
+	shutdownRabbitService(t)
 }
 
 func TestPublishAndWaitForReceipt(t *testing.T) {
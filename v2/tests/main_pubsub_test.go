@@ -73,6 +73,7 @@ WaitForConsumer:
 
 // TestLargeConsumingAfterLargePublish is a combination test of Consuming and Publishing
 func TestLargeConsumingAfterLargePublish(t *testing.T) {
+	skipInMemory(t)           // load test
 	defer leaktest.Check(t)() // Fail on leaked goroutines.
 
 	timeoutAfter := time.After(time.Minute * 5)
@@ -111,7 +112,8 @@ WaitForReceiptsLoop:
 	for {
 		select {
 		case <-timeoutAfter:
-			return
+			t.Error("publish receipts timeout")
+			break WaitForReceiptsLoop // still reports, the test is waiting on done
 		case receipt := <-pub.PublishReceipts():
 
 			if receipt.Success {
@@ -149,7 +151,8 @@ WaitForConsumer:
 	for {
 		select {
 		case <-timeoutAfter:
-			return
+			t.Error("consumer timeout")
+			break WaitForConsumer // still reports, the test is waiting on done
 
 		case message := <-con.ReceivedMessages():
 
@@ -169,6 +172,7 @@ WaitForConsumer:
 
 // TestLargeConsumingAfterLargePublishConfirmation is a combination test of Consuming and Publishing with confirmation.
 func TestLargeConsumingAfterLargePublishConfirmation(t *testing.T) {
+	skipInMemory(t)           // load test
 	defer leaktest.Check(t)() // Fail on leaked goroutines.
 
 	timeoutAfter := time.After(time.Minute * 2)
@@ -200,6 +204,7 @@ func TestLargeConsumingAfterLargePublishConfirmation(t *testing.T) {
 
 // TestLargePublishConfirmation is a combination test of Consuming and Publishing with confirmation.
 func TestLargePublishConfirmation(t *testing.T) {
+	skipInMemory(t)           // load test
 	defer leaktest.Check(t)() // Fail on leaked goroutines.
 
 	timeoutAfter := time.After(time.Minute * 2)
@@ -30,11 +30,11 @@ func TestConnectionGetChannelAndReturnSlowLoop(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			chanHost := ConnectionPool.GetChannelFromPool()
+			chanHost := ConnectionPool.GetChannelFromPool(true)
 
 			time.Sleep(time.Millisecond * 100) // artificially create channel poool contention by long exposure
 
-			err := chanHost.Channel.Publish("", "TcrTestQueue", false, false, amqp.Publishing{
+			err := chanHost.TransportChannel().Publish("", "TcrTestQueue", false, false, amqp.Publishing{
 				ContentType:  "plaintext/text",
 				Body:         body,
 				DeliveryMode: 2,
@@ -47,7 +47,7 @@ func TestConnectionGetChannelAndReturnSlowLoop(t *testing.T) {
 	}
 
 	wg.Wait() // wait for the final batch of requests to finish
-	ConnectionPool.Shutdown()
+	shutdownRabbitService(t)
 }
 
 // TestOutageAndQueueLetterAccuracy is similar to the above. It is designed to be slow test connection recovery by severing all connections
@@ -84,7 +84,7 @@ func TestOutageAndQueueLetterAccuracy(t *testing.T) {
 
 	<-time.After(time.Second * 5) // refresh rate of management API is 5 seconds, this just allows you to see the queue
 
-	RabbitService.Shutdown(true)
+	shutdownRabbitService(t)
 }
 
 // TestPublishWithHeaderAndVerify verifies headers are publishing.
@@ -108,7 +108,7 @@ func TestPublishWithHeaderAndVerify(t *testing.T) {
 		fmt.Printf("Header Received: %s\r\n", testHeader.(string))
 	}
 
-	RabbitService.Shutdown(true)
+	shutdownRabbitService(t)
 }
 
 // TestPublishWithHeaderAndConsumerReceivedHeader verifies headers are being consumed into ReceivedData.
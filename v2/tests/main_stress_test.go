@@ -11,6 +11,7 @@ import (
 )
 
 func TestWithStress(t *testing.T) {
+	skipInMemory(t) // soak test
 
 	timeDuration := time.Duration(2 * time.Hour)
 	pubTimeOut := time.After(timeDuration)
@@ -101,6 +102,7 @@ PublishLoop:
 }
 
 func TestDurationAccuracy(t *testing.T) {
+	skipInMemory(t) // soak test
 
 	timeDuration := time.Duration(5 * time.Minute)
 	pubTimeOut := time.After(timeDuration)
@@ -262,6 +264,7 @@ func verifyAccuracyT(t *testing.T, conMap cmap.ConcurrentMap) {
 }
 
 func TestPublishConsumeCountAccuracy(t *testing.T) {
+	skipInMemory(t) // load test
 
 	fmt.Printf("Benchmark Starts: %s\r\n", time.Now())
 	publisher := tcr.NewPublisherFromConfig(Seasoning, ConnectionPool)
@@ -302,7 +305,7 @@ func TestPublishConsumeCountAccuracy(t *testing.T) {
 	// off based on how much we think it should have consumed. This can lead to a difference
 	// in pub/sub counts and leave messages in the queue. So we manually verify the queue
 	// too.
-	RabbitService.Shutdown(true)
+	shutdownRabbitService(t)
 }
 
 func publishAccuracyLoop(
@@ -446,6 +449,7 @@ AcknowledgeLoop:
 var conmap cmap.ConcurrentMap
 
 func TestWithRabbitServiceStress(t *testing.T) {
+	skipInMemory(t) // soak test
 
 	timeDuration := time.Duration(2 * time.Hour)
 	pubTimeOut := time.After(timeDuration)
@@ -3,8 +3,11 @@ package main_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
 )
 
 var Seasoning *tcr.RabbitSeasoning
@@ -13,45 +16,96 @@ var RabbitService *tcr.RabbitService
 var AckableConsumerConfig *tcr.ConsumerConfig
 var ConsumerConfig *tcr.ConsumerConfig
 
+// Transport is the tcrtest in-memory broker with TCR_TEST_TRANSPORT=memory, nil dials RabbitMQ.
+var Transport tcr.Transport
+
 func TestMain(m *testing.M) {
 
+	// TCR_TEST_TRANSPORT=memory runs the suite hermetically against the tcrtest in-memory broker.
+	if os.Getenv("TCR_TEST_TRANSPORT") == "memory" {
+		Transport = tcrtest.NewBroker()
+	}
+
+	if err := setup(); err != nil {
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// setup loads a fresh Seasoning (tests are free to change it) and builds the shared RabbitService and TcrTestQueue.
+func setup() error {
+
 	var err error
 	Seasoning, err = tcr.ConvertJSONFileToConfig("testseasoning.json") // Load Configuration On Startup
 	if err != nil {
-		return
+		return err
 	}
+	Seasoning.PoolConfig.Transport = Transport
 
 	RabbitService, err = tcr.NewRabbitService(Seasoning, "", "", nil, nil)
 	if err != nil {
-		return
+		return err
 	}
 
 	ConnectionPool = RabbitService.ConnectionPool
 
 	AckableConsumerConfig, err = RabbitService.GetConsumerConfig("TurboCookedRabbitConsumer-Ackable")
 	if err != nil {
-		return
+		return err
 	}
 
 	ConsumerConfig, err = RabbitService.GetConsumerConfig("TurboCookedRabbitConsumer")
 	if err != nil {
-		return
+		return err
 	}
 
+	// Start from an empty queue, tests that don't consume everything they publish would leave messages for the next one.
+	_, _ = RabbitService.Topologer.QueueDelete("TcrTestQueue", false, false, false)
 	err = RabbitService.Topologer.CreateQueue("TcrTestQueue", false, true, false, false, false, nil)
 	if err != nil {
-		return
+		return err
 	}
 
-	os.Exit(m.Run())
+	// leaktest only ignores goroutines already running when a test starts, let those of the RabbitService get going.
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// dial connects straight to the broker like amqp.Dial, bypassing the ConnectionPool.
+func dial() (tcr.AMQPConnection, error) {
+
+	transport := Transport
+	if transport == nil {
+		transport = tcr.NewAMQPTransport()
+	}
+
+	return transport.Dial(Seasoning.PoolConfig.URI, amqp.Config{Heartbeat: 10 * time.Second, Locale: "en_US"})
+}
+
+// skipInMemory skips tests that only make sense against RabbitMQ (ex. soak tests) when running against the in-memory broker.
+func skipInMemory(t *testing.T) {
+	if Transport != nil {
+		t.Skip("needs RabbitMQ, skipped with TCR_TEST_TRANSPORT=memory")
+	}
+}
+
+// shutdownRabbitService shuts the shared RabbitService down, a new one is built for the next test once this one is done.
+func shutdownRabbitService(t testing.TB) {
+	RabbitService.Shutdown(true)
+	t.Cleanup(func() {
+		if err := setup(); err != nil {
+			t.Error(err)
+		}
+	})
 }
 
 func TestCleanup(t *testing.T) {
 	_, _ = RabbitService.Topologer.QueueDelete("TcrTestQueue", false, false, false)
-	RabbitService.Shutdown(true)
+	shutdownRabbitService(t)
 }
 
 func BenchCleanup(b *testing.B) {
 	_, _ = RabbitService.Topologer.QueueDelete("TcrTestQueue", false, false, false)
-	RabbitService.Shutdown(true)
+	shutdownRabbitService(b)
 }
@@ -77,6 +77,11 @@ func TestUnbindQueue(t *testing.T) {
 
 	topologer := tcr.NewTopologer(connectionPool)
 
+	// The binding comes from the test topology, build it in case the test runs on its own.
+	topologyConfig, err := tcr.ConvertJSONFileToTopologyConfig("testtopology.json")
+	assert.NoError(t, err)
+	assert.NoError(t, topologer.BuildTopology(topologyConfig, true))
+
 	err = topologer.UnbindQueue("QueueAttachedToExch01", "RoutingKey1", "MyTestExchange.Child01", nil)
 	assert.NoError(t, err)
 }
@@ -0,0 +1,192 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffPolicyExhaustsAttempts(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	config := newTestSeasoning(broker).PoolConfig
+	config.BackoffPolicy = &tcr.BackoffPolicy{
+		Strategy:     tcr.BackoffExponential,
+		InitialDelay: 20,
+		Multiplier:   2,
+		MaxAttempts:  4,
+	}
+
+	lock := &sync.Mutex{}
+	var exhaustedErrs []*tcr.RecoveryExhaustedError
+	cp, err := tcr.NewConnectionPoolWithErrorHandler(config, func(err error) {
+		var exhaustedErr *tcr.RecoveryExhaustedError
+		if errors.As(err, &exhaustedErr) {
+			lock.Lock()
+			exhaustedErrs = append(exhaustedErrs, exhaustedErr)
+			lock.Unlock()
+		}
+	})
+	require.NoError(t, err)
+	defer cp.Shutdown()
+
+	broker.RefuseDials(true)
+	broker.KillConnections()
+
+	start := time.Now()
+	_, err = cp.GetConnection()
+	elapsed := time.Since(start)
+
+	var exhaustedErr *tcr.RecoveryExhaustedError
+	require.ErrorAs(t, err, &exhaustedErr)
+	assert.Equal(t, "connection", exhaustedErr.Resource)
+	assert.Equal(t, uint32(4), exhaustedErr.Attempts)
+	assert.Error(t, exhaustedErr.Err)
+	assert.GreaterOrEqual(t, elapsed, 140*time.Millisecond) // 20 + 40 + 80
+
+	lock.Lock()
+	assert.Len(t, exhaustedErrs, 1)
+	lock.Unlock()
+
+	_, err = cp.GetTransientChannelContext(context.Background(), false)
+	assert.ErrorAs(t, err, &exhaustedErr)
+
+	// The connection was returned flagged, the next caller starts a fresh recovery.
+	broker.RefuseDials(false)
+
+	connHost, err := cp.GetConnection()
+	require.NoError(t, err)
+	cp.ReturnConnection(connHost, false)
+}
+
+func TestBackoffWithoutDelayDoesNotSpin(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	config := newTestSeasoning(broker).PoolConfig
+	config.SleepOnErrorInterval = 0
+	config.BackoffPolicy = &tcr.BackoffPolicy{MaxAttempts: 3}
+
+	cp, err := tcr.NewConnectionPool(config)
+	require.NoError(t, err)
+	defer cp.Shutdown()
+
+	broker.RefuseDials(true)
+	broker.KillConnections()
+
+	start := time.Now()
+	_, err = cp.GetConnection()
+	require.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond) // two default delays
+}
+
+func TestBackoffPolicyFlagsExhaustedChannel(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	config := newTestSeasoning(broker).PoolConfig
+	config.BackoffPolicy = &tcr.BackoffPolicy{InitialDelay: 5, MaxAttempts: 2}
+
+	cp, err := tcr.NewConnectionPool(config)
+	require.NoError(t, err)
+	defer cp.Shutdown()
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	chanHost := cp.GetChannelFromPool(false)
+	broker.RefuseDials(true)
+	broker.KillConnections()
+	cp.ReturnChannel(chanHost, true) // recovery gives up, the channel is cached broken
+
+	broker.RefuseDials(false)
+
+	// Every checkout of the cache rebuilds what it hands out, the broken channel included.
+	for i := uint64(0); i < config.MaxCacheChannelCount; i++ {
+		chanHost = cp.GetChannelFromPool(false)
+		assert.NoError(t, chanHost.TransportChannel().Publish("", "TcrTestQueue", false, false, amqp.Publishing{Body: []byte("hi")}))
+		defer cp.ReturnChannel(chanHost, false)
+	}
+}
+
+func TestBackoffPolicyExhaustedChannelCheckout(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	config := newTestSeasoning(broker).PoolConfig
+	config.BackoffPolicy = &tcr.BackoffPolicy{InitialDelay: 5, MaxAttempts: 2}
+
+	cp, err := tcr.NewConnectionPool(config)
+	require.NoError(t, err)
+	defer cp.Shutdown()
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	broker.RefuseDials(true)
+	broker.KillConnections()
+
+	_, err = cp.GetChannelFromPoolContext(context.Background(), false)
+	var exhaustedErr *tcr.RecoveryExhaustedError
+	require.ErrorAs(t, err, &exhaustedErr)
+	assert.Equal(t, int(config.MaxCacheChannelCount), cp.Stats().PlainChannelsAvailable) // put back in the cache
+
+	// Without a context it keeps trying until the channel can be rebuilt.
+	got := make(chan *tcr.ChannelHost, 1)
+	go func() { got <- cp.GetChannelFromPool(false) }()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, got)
+	broker.RefuseDials(false)
+
+	select {
+	case chanHost := <-got:
+		assert.NoError(t, chanHost.TransportChannel().Publish("", "TcrTestQueue", false, false, amqp.Publishing{Body: []byte("hi")}))
+		cp.ReturnChannel(chanHost, false)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no channel after the broker came back")
+	}
+}
+
+func TestBackoffPolicyMaxDelay(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	config := newTestSeasoning(broker).PoolConfig
+	config.BackoffPolicy = &tcr.BackoffPolicy{
+		Strategy:     tcr.BackoffDecorrelatedJitter,
+		InitialDelay: 5,
+		MaxDelay:     10,
+		MaxAttempts:  6,
+	}
+
+	cp, err := tcr.NewConnectionPool(config)
+	require.NoError(t, err)
+	defer cp.Shutdown()
+
+	broker.RefuseDials(true)
+	broker.KillConnections()
+
+	start := time.Now()
+	_, err = cp.GetConnection()
+	elapsed := time.Since(start)
+
+	var exhaustedErr *tcr.RecoveryExhaustedError
+	require.ErrorAs(t, err, &exhaustedErr)
+	assert.GreaterOrEqual(t, elapsed, 25*time.Millisecond) // five delays of at least InitialDelay
+	assert.Less(t, elapsed, time.Second)                   // each capped at MaxDelay
+}
+
+func TestPoolRejectsInvalidBackoffPolicy(t *testing.T) {
+
+	config := newTestSeasoning(tcrtest.NewBroker()).PoolConfig
+	config.BackoffPolicy = &tcr.BackoffPolicy{Strategy: "fibonacci"}
+
+	_, err := tcr.NewConnectionPool(config)
+	assert.Error(t, err)
+
+	config.BackoffPolicy = &tcr.BackoffPolicy{Strategy: tcr.BackoffExponential, Multiplier: 0.5}
+
+	_, err = tcr.NewConnectionPool(config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,166 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBatch(count int) []*tcr.Letter {
+
+	letters := make([]*tcr.Letter, count)
+	for i := range letters {
+		letters[i] = tcr.CreateMockRandomLetter("TcrTestQueue")
+	}
+
+	return letters
+}
+
+func TestPublishBatchWithConfirmation(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	letters := newTestBatch(1000)
+	result, err := publisher.PublishBatchWithConfirmation(context.Background(), letters)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1000, result.Acked)
+	assert.Equal(t, 0, result.Failed)
+	assert.Empty(t, result.Failures())
+	for i, receipt := range result.Receipts {
+		assert.True(t, receipt.Success)
+		assert.Equal(t, letters[i].LetterID, receipt.LetterID)
+	}
+	assert.Equal(t, 1000, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestPublishBatchWithConfirmationRepublishesNacks(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	broker.NackNext(10)
+
+	letters := newTestBatch(20)
+	result, err := publisher.PublishBatchWithConfirmation(context.Background(), letters)
+	require.NoError(t, err)
+	assert.Equal(t, 20, result.Acked)
+	assert.Equal(t, 20, broker.MessageCount("TcrTestQueue")) // nacked publishes are dropped by the broker
+
+	for _, letter := range letters {
+		assert.Equal(t, uint32(0), letter.RetryCount) // the letters of the caller are left alone
+	}
+}
+
+func TestPublishBatchWithConfirmationGivesUpOnNacks(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.MaxRetryCount = 2
+	publisher := tcr.NewPublisherFromConfig(seasoning, cp)
+
+	broker.NackNext(1000)
+
+	result, err := publisher.PublishBatchWithConfirmation(context.Background(), newTestBatch(3))
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Acked)
+	require.Len(t, result.Failures(), 3)
+	for _, receipt := range result.Failures() {
+		assert.Error(t, receipt.Error)
+		assert.Equal(t, uint32(0), receipt.FailedLetter.RetryCount)
+	}
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+
+	broker.NackNext(1000)
+
+	letters := newTestBatch(1)
+	letters[0].RetryCount = 1 // republishes count on from the RetryCount of the letter
+	result, err = publisher.PublishBatchWithConfirmation(context.Background(), letters)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, uint32(1), letters[0].RetryCount)
+}
+
+func TestPublisherFromConfigLeavesConfigAlone(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.MaxRetryCount = 0
+	tcr.NewPublisherFromConfig(seasoning, cp)
+	assert.Equal(t, uint32(0), seasoning.PublisherConfig.MaxRetryCount)
+}
+
+func TestPublishBatchWithConfirmationTimesOut(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	broker.DropConfirms(true)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 200*time.Millisecond)
+	result, err := publisher.PublishBatchWithConfirmation(context.Background(), newTestBatch(5))
+	require.NoError(t, err)
+	assert.Equal(t, 5, result.Failed)
+	assert.Equal(t, 5, broker.MessageCount("TcrTestQueue")) // published once, never republished
+
+	publisher = tcr.NewPublisher(cp, 0, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	result, err = publisher.PublishBatchWithConfirmation(ctx, newTestBatch(5))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 0, result.Failed) // published, they may have made it: not failed
+	assert.Equal(t, 5, result.Unconfirmed)
+	assert.Empty(t, result.Failures())
+	require.Len(t, result.UnconfirmedReceipts(), 5)
+	for _, receipt := range result.Receipts {
+		assert.True(t, errors.Is(receipt.Error, tcr.ErrUnconfirmed))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	result, err = publisher.PublishBatchWithConfirmation(ctx, newTestBatch(5))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.NotZero(t, result.Failed)
+	assert.Equal(t, 5, result.Failed+result.Unconfirmed)
+	for _, receipt := range result.Failures() {
+		assert.True(t, errors.Is(receipt.Error, context.Canceled)) // never published
+	}
+}
+
+func TestServicePublishBatchWithConfirmation(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+	defer service.Shutdown(false)
+
+	inputs := make([]interface{}, 50)
+	for i := range inputs {
+		inputs[i] = map[string]int{"index": i}
+	}
+
+	result, err := service.PublishBatchWithConfirmation(context.Background(), inputs, "", "TcrTestQueue", "", true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 50, result.Acked)
+	assert.Equal(t, 50, broker.MessageCount("TcrTestQueue"))
+
+	_, err = service.PublishBatchWithConfirmation(context.Background(), []interface{}{nil}, "", "TcrTestQueue", "", false, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// settleCounter counts how the messages of a Consumer were settled.
+type settleCounter struct {
+	acks, nacks, rejects int64
+}
+
+func (sc *settleCounter) ObserveDelivery(string)               {}
+func (sc *settleCounter) ObserveAck(string, error)             { atomic.AddInt64(&sc.acks, 1) }
+func (sc *settleCounter) ObserveNack(string, bool, error)      { atomic.AddInt64(&sc.nacks, 1) }
+func (sc *settleCounter) ObserveReject(string, bool, error)    { atomic.AddInt64(&sc.rejects, 1) }
+func (sc *settleCounter) ObserveHandler(string, time.Duration) {}
+func (sc *settleCounter) ObserveConsumerError(string, error)   {}
+func (sc *settleCounter) count(counter *int64) func() int64 {
+	return func() int64 { return atomic.LoadInt64(counter) }
+}
+func (sc *settleCounter) equals(counter *int64, n int64) func() bool {
+	return func() bool { return sc.count(counter)() == n }
+}
+
+func newChunkLetter(index, count int32, body string) *tcr.Letter {
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = []byte(body)
+	letter.Envelope.Headers = map[string]interface{}{tcr.ChunkIndexHeader: index, tcr.ChunkCountHeader: count}
+	return letter
+}
+
+func TestChunksPublishedAndReassembled(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	report := bytes.Repeat([]byte("0123456789"), 75)
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = report
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	result, err := publisher.PublishChunksWithConfirmation(context.Background(), letter, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 8, result.Acked)
+
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 8)
+	for _, chunk := range published {
+		assert.Equal(t, letter.LetterID.String(), chunk.MessageId)
+		assert.Equal(t, int32(8), chunk.Headers[tcr.ChunkCountHeader])
+		assert.Equal(t, "HelloWorldHeader", chunk.Headers["x-tcr-testheader"])
+	}
+	assert.NotContains(t, letter.Envelope.Headers, tcr.ChunkIndexHeader)
+
+	counter := &settleCounter{}
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetObserver(counter)
+	chunked := tcr.NewChunkedConsumer(consumer, 0, 0)
+
+	received := make(chan *tcr.ReceivedMessage, 1)
+	chunked.StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		assert.Equal(t, int64(0), counter.count(&counter.acks)()) // nothing acked before the handler succeeds
+		received <- msg
+		return nil
+	})
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, report, msg.Body)
+		assert.Equal(t, letter.LetterID.String(), msg.MessageID)
+		assert.NotContains(t, msg.Headers(), tcr.ChunkIndexHeader)
+		assert.False(t, msg.IsAckable)
+	case <-time.After(5 * time.Second):
+		t.Fatal("chunks were not reassembled")
+	}
+
+	require.Eventually(t, counter.equals(&counter.acks, 8), 5*time.Second, 10*time.Millisecond)
+}
+
+func TestChunksRequeuedWhenHandlerFails(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	plain := tcr.CreateMockRandomLetter("TcrTestQueue")
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), plain))
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = []byte("carrots, lettuce, and clover")
+	_, err := publisher.PublishChunksWithConfirmation(context.Background(), letter, 10)
+	require.NoError(t, err)
+
+	counter := &settleCounter{}
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetObserver(counter)
+
+	var attempts int64
+	received := make(chan *tcr.ReceivedMessage, 2)
+	tcr.NewChunkedConsumer(consumer, 0, 0).StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		if msg.MessageID == letter.LetterID.String() && atomic.AddInt64(&attempts, 1) == 1 {
+			return errors.New("not yet")
+		}
+		received <- msg
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			if msg.MessageID == letter.LetterID.String() {
+				assert.Equal(t, letter.Body, msg.Body)
+			} else {
+				assert.Equal(t, plain.Body, msg.Body) // not a chunk, handed over as is
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("messages were not handled")
+		}
+	}
+
+	assert.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+	require.Eventually(t, counter.equals(&counter.acks, 4), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(3), counter.count(&counter.nacks)())
+	assert.Error(t, <-consumer.Errors())
+}
+
+func TestChunksExpireWhenIncomplete(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), newChunkLetter(0, 2, "half of it")))
+
+	counter := &settleCounter{}
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetObserver(counter)
+	tcr.NewChunkedConsumer(consumer, 50*time.Millisecond, 0).StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		t.Error("an incomplete letter was handled")
+		return nil
+	})
+
+	select {
+	case err := <-consumer.Errors():
+		assert.True(t, errors.Is(err, tcr.ErrChunksExpired))
+	case <-time.After(5 * time.Second):
+		t.Fatal("chunks did not expire")
+	}
+
+	require.Eventually(t, counter.equals(&counter.rejects, 1), 5*time.Second, 10*time.Millisecond)
+}
+
+func TestChunksOverMemoryCapAreRejected(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = bytes.Repeat([]byte("x"), 100)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	_, err := publisher.PublishChunksWithConfirmation(context.Background(), letter, 30)
+	require.NoError(t, err)
+
+	counter := &settleCounter{}
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetObserver(counter)
+	tcr.NewChunkedConsumer(consumer, 0, 64).StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		t.Error("a letter over the memory cap was handled")
+		return nil
+	})
+
+	select {
+	case err := <-consumer.Errors():
+		assert.True(t, errors.Is(err, tcr.ErrChunkBufferFull))
+	case <-time.After(5 * time.Second):
+		t.Fatal("chunks were not refused")
+	}
+
+	// the letter never fits, its chunks aren't requeued
+	require.Eventually(t, func() bool { return counter.count(&counter.nacks)() >= 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestChunksCountingTooManyAreRejected(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), newChunkLetter(0, math.MaxInt32, "one of many")))
+
+	counter := &settleCounter{}
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetObserver(counter)
+	tcr.NewChunkedConsumer(consumer, 0, 0).StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		t.Error("a chunk counting too many was handled")
+		return nil
+	})
+
+	select {
+	case err := <-consumer.Errors():
+		assert.Contains(t, err.Error(), tcr.ChunkCountHeader)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the chunk was not refused")
+	}
+
+	require.Eventually(t, counter.equals(&counter.rejects, 1), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestChunksWithFloatHeadersAreReassembled(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	letterID := tcr.CreateMockRandomLetter("TcrTestQueue").LetterID // the chunks of a letter share its LetterID
+	for index, body := range []string{"decoded ", "from JSON"} {
+		letter := newChunkLetter(0, 0, body)
+		letter.LetterID = letterID
+		letter.Envelope.Headers = map[string]interface{}{tcr.ChunkIndexHeader: float64(index), tcr.ChunkCountHeader: float64(2)}
+		require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), letter))
+	}
+
+	received := make(chan string, 1)
+	consumer := newTestConsumer(t, broker, cp)
+	tcr.NewChunkedConsumer(consumer, 0, 0).StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		received <- string(msg.Body)
+		return nil
+	})
+
+	select {
+	case body := <-received:
+		assert.Equal(t, "decoded from JSON", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the chunks were not reassembled")
+	}
+}
+
+func TestChunksWithInvalidSize(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	_, err := publisher.PublishChunksWithConfirmation(context.Background(), tcr.CreateMockRandomLetter("TcrTestQueue"), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,166 @@
+package memory_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type circuitEvents struct {
+	events []tcr.CircuitEvent
+	lock   *sync.Mutex
+}
+
+func newCircuitPublisher(t *testing.T, broker *tcrtest.Broker, config *tcr.CircuitBreakerConfig) (*tcr.Publisher, *circuitEvents) {
+
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetCircuitBreaker(config)
+
+	recorded := &circuitEvents{lock: &sync.Mutex{}}
+	publisher.SetCircuitBreakerHandler(func(event tcr.CircuitEvent) {
+		recorded.lock.Lock()
+		defer recorded.lock.Unlock()
+		recorded.events = append(recorded.events, event)
+	})
+
+	return publisher, recorded
+}
+
+func (ce *circuitEvents) states() []tcr.CircuitState {
+	ce.lock.Lock()
+	defer ce.lock.Unlock()
+
+	states := make([]tcr.CircuitState, 0, len(ce.events))
+	for _, event := range ce.events {
+		states = append(states, event.To)
+	}
+	return states
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher, recorded := newCircuitPublisher(t, broker, &tcr.CircuitBreakerConfig{FailureThreshold: 3, OpenInterval: 60000})
+
+	broker.NackNext(1000)
+	err := publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0)
+	assert.True(t, errors.Is(err, tcr.ErrCircuitOpen)) // stops republishing the nacks
+	assert.Equal(t, tcr.CircuitOpen, publisher.CircuitState())
+
+	require.Len(t, recorded.events, 1)
+	assert.Equal(t, tcr.CircuitClosed, recorded.events[0].From)
+	assert.Equal(t, tcr.CircuitOpen, recorded.events[0].To)
+	assert.Equal(t, 3, recorded.events[0].Failures)
+	assert.Error(t, recorded.events[0].Err)
+
+	broker.NackNext(0)
+	assert.True(t, errors.Is(publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true), tcr.ErrCircuitOpen))
+	assert.True(t, errors.Is(publisher.PublishWithTransient(tcr.CreateMockRandomLetter("TcrTestQueue")), tcr.ErrCircuitOpen))
+
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), 0)
+	receipt := nextReceipt(t, publisher)
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrCircuitOpen))
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+
+	publisher.SetCircuitBreaker(nil)
+	assert.Equal(t, tcr.CircuitClosed, publisher.CircuitState())
+	require.NoError(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0))
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher, recorded := newCircuitPublisher(t, broker, &tcr.CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenInterval:     100,
+		HalfOpenProbes:   2,
+	})
+
+	broker.DropConfirms(true)
+	for i := 0; i < 2; i++ {
+		assert.Error(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 50*time.Millisecond))
+	}
+	assert.Equal(t, tcr.CircuitOpen, publisher.CircuitState()) // confirmation timeouts count as failures
+
+	// A failed probe opens the circuit again.
+	time.Sleep(150 * time.Millisecond)
+	assert.Error(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 50*time.Millisecond))
+	assert.Equal(t, tcr.CircuitOpen, publisher.CircuitState())
+
+	// Every probe has to succeed to close it.
+	broker.DropConfirms(false)
+	time.Sleep(150 * time.Millisecond)
+	require.NoError(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0))
+	assert.Equal(t, tcr.CircuitHalfOpen, publisher.CircuitState())
+	require.NoError(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0))
+	assert.Equal(t, tcr.CircuitClosed, publisher.CircuitState())
+
+	assert.Equal(t, []tcr.CircuitState{
+		tcr.CircuitOpen,
+		tcr.CircuitHalfOpen,
+		tcr.CircuitOpen,
+		tcr.CircuitHalfOpen,
+		tcr.CircuitClosed,
+	}, recorded.states())
+}
+
+func TestCircuitBreakerFailureWindow(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher, _ := newCircuitPublisher(t, broker, &tcr.CircuitBreakerConfig{FailureThreshold: 2, FailureWindow: 50})
+
+	broker.DropConfirms(true)
+	assert.Error(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 10*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.Error(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 10*time.Millisecond))
+	assert.Equal(t, tcr.CircuitClosed, publisher.CircuitState()) // the first failure fell out of the window
+
+	assert.Error(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 10*time.Millisecond))
+	assert.Equal(t, tcr.CircuitOpen, publisher.CircuitState())
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher, recorded := newCircuitPublisher(t, broker, &tcr.CircuitBreakerConfig{FailureThreshold: 3})
+
+	for i := 0; i < 5; i++ {
+		broker.NackNext(2)
+		require.NoError(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0))
+	}
+
+	assert.Equal(t, tcr.CircuitClosed, publisher.CircuitState())
+	assert.Empty(t, recorded.states())
+	assert.Equal(t, 5, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestCircuitBreakerAutoPublishWaitsForClose(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher, _ := newCircuitPublisher(t, broker, &tcr.CircuitBreakerConfig{FailureThreshold: 1, OpenInterval: 200})
+
+	broker.NackNext(1)
+	assert.True(t, errors.Is(publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0), tcr.ErrCircuitOpen))
+
+	opened := time.Now()
+	require.True(t, publisher.QueueLetters(newTestBatch(10)))
+	publisher.StartAutoPublishing()
+	defer publisher.Shutdown(false)
+
+	for i := 0; i < 10; i++ {
+		receipt := nextReceipt(t, publisher)
+		require.True(t, receipt.Success, "%v", receipt.Error) // queued letters wait instead of failing
+	}
+	assert.GreaterOrEqual(t, time.Since(opened), 150*time.Millisecond)
+	assert.Equal(t, tcr.CircuitClosed, publisher.CircuitState())
+	assert.Equal(t, 10, broker.MessageCount("TcrTestQueue"))
+}
@@ -0,0 +1,237 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextMessage(t *testing.T, consumer *tcr.Consumer) *tcr.ReceivedMessage {
+
+	select {
+	case msg := <-consumer.ReceivedMessages():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestClaimCheckOffloadsAndRehydrates(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	directory := t.TempDir()
+	store, err := tcr.NewFileClaimCheckStore(directory)
+	require.NoError(t, err)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetClaimCheck(store, 64)
+
+	report := bytes.Repeat([]byte("quarterly carrot report "), 100)
+	large := tcr.CreateMockRandomLetter("TcrTestQueue")
+	large.Body = report
+	small := tcr.CreateMockRandomLetter("TcrTestQueue")
+	small.Body = []byte("a short memo")
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), large))
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), small))
+
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 2)
+	assert.Less(t, len(published[0].Body), len(report))
+	assert.NotContains(t, published[1].Headers, tcr.ClaimCheckHeader)
+	assert.Equal(t, report, large.Body) // the letter is left as is
+	assert.NotContains(t, large.Envelope.Headers, tcr.ClaimCheckHeader)
+
+	wrappedBody, err := tcr.ReadWrappedBodyFromJSONBytes(published[0].Body)
+	require.NoError(t, err)
+	assert.Equal(t, large.LetterID, wrappedBody.LetterID)
+	assert.Equal(t, wrappedBody.ClaimCheck, published[0].Headers[tcr.ClaimCheckHeader])
+	assert.True(t, strings.HasPrefix(wrappedBody.ClaimCheck, large.LetterID.String()))
+	assert.FileExists(t, filepath.Join(directory, wrappedBody.ClaimCheck))
+
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetClaimCheck(store, true)
+	consumer.StartConsuming()
+
+	msg := nextMessage(t, consumer)
+	assert.Equal(t, report, msg.Body)
+	assert.Equal(t, report, msg.Delivery.Body)
+	assert.FileExists(t, filepath.Join(directory, wrappedBody.ClaimCheck)) // until acked
+	require.NoError(t, msg.Acknowledge())
+	assert.NoFileExists(t, filepath.Join(directory, wrappedBody.ClaimCheck))
+
+	msg = nextMessage(t, consumer)
+	assert.Equal(t, []byte("a short memo"), msg.Body)
+	require.NoError(t, msg.Acknowledge())
+}
+
+func TestClaimCheckMissingBodyIsRejected(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	directory := t.TempDir()
+	store, err := tcr.NewFileClaimCheckStore(directory)
+	require.NoError(t, err)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetClaimCheck(store, 0)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), letter))
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 1)
+	require.NoError(t, store.Delete(context.Background(), published[0].Headers[tcr.ClaimCheckHeader].(string)))
+
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetClaimCheck(store, false)
+	consumer.StartConsuming()
+
+	select {
+	case err := <-consumer.Errors():
+		assert.True(t, errors.Is(err, tcr.ErrClaimCheckNotFound))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rehydration error received")
+	}
+
+	assert.Empty(t, consumer.ReceivedMessages())
+	require.Eventually(t, func() bool { return broker.MessageCount("TcrTestQueue") == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClaimCheckLeavesSharedEnvelopesAlone(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	store, err := tcr.NewFileClaimCheckStore(t.TempDir())
+	require.NoError(t, err)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetClaimCheck(store, 64)
+
+	first := tcr.CreateMockRandomLetter("TcrTestQueue")
+	first.Body = bytes.Repeat([]byte("carrots "), 20)
+	second := tcr.CreateMockRandomLetter("TcrTestQueue")
+	second.Body = bytes.Repeat([]byte("turnips "), 20)
+	second.Envelope = first.Envelope
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), first))
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), second))
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), first)) // republished as is
+
+	assert.NotContains(t, first.Envelope.Headers, tcr.ClaimCheckHeader)
+	assert.Equal(t, bytes.Repeat([]byte("carrots "), 20), first.Body)
+
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetClaimCheck(store, false)
+	consumer.StartConsuming()
+
+	for _, body := range [][]byte{first.Body, second.Body, first.Body} {
+		msg := nextMessage(t, consumer)
+		assert.Equal(t, body, msg.Body)
+		require.NoError(t, msg.Acknowledge())
+	}
+}
+
+func TestClaimCheckKeepsEveryChunk(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	store, err := tcr.NewFileClaimCheckStore(t.TempDir())
+	require.NoError(t, err)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetClaimCheck(store, 64)
+
+	report := bytes.Repeat([]byte("0123456789"), 40)
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = report
+	result, err := publisher.PublishChunksWithConfirmation(context.Background(), letter, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Acked)
+
+	consumer := newTestConsumer(t, broker, cp)
+	consumer.SetClaimCheck(store, true)
+	chunked := tcr.NewChunkedConsumer(consumer, 0, 0)
+
+	received := make(chan *tcr.ReceivedMessage, 1)
+	chunked.StartConsuming(func(msg *tcr.ReceivedMessage) error {
+		received <- msg
+		return nil
+	})
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, report, msg.Body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("chunks were not reassembled")
+	}
+}
+
+func TestFileClaimCheckStoreStaysInItsDirectory(t *testing.T) {
+
+	directory := t.TempDir()
+	store, err := tcr.NewFileClaimCheckStore(filepath.Join(directory, "claims"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(directory, "secret"), []byte("hops"), 0600))
+
+	_, err = store.Retrieve(context.Background(), "../secret")
+	assert.Error(t, err)
+	_, err = store.Store(context.Background(), "../secret", []byte("carrots"))
+	assert.Error(t, err)
+
+	_, err = store.Retrieve(context.Background(), "unknown")
+	assert.True(t, errors.Is(err, tcr.ErrClaimCheckNotFound))
+	assert.NoError(t, store.Delete(context.Background(), "unknown"))
+}
+
+func TestServiceClaimCheckConfig(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	seasoning := newTestSeasoning(broker)
+	seasoning.ClaimCheckConfig = &tcr.ClaimCheckConfig{Enabled: true, Directory: t.TempDir(), Threshold: 64, DeleteAfterAck: true}
+
+	service, err := tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	require.NoError(t, err)
+	defer service.Shutdown(true)
+	require.NoError(t, service.Topologer.CreateQueue("TcrTestQueue", false, false, false, false, false, nil))
+
+	report := bytes.Repeat([]byte("lettuce "), 100)
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = report
+	require.NoError(t, service.QueueLetter(letter))
+
+	consumer, err := service.GetConsumer("TcrTestConsumer")
+	require.NoError(t, err)
+	consumer.StartConsuming()
+	defer func() { _ = consumer.StopConsuming(true, true) }()
+
+	msg := nextMessage(t, consumer)
+	assert.Equal(t, report, msg.Body)
+	reference, ok := msg.Delivery.Headers[tcr.ClaimCheckHeader].(string)
+	require.True(t, ok)
+	assert.FileExists(t, filepath.Join(seasoning.ClaimCheckConfig.Directory, reference))
+	require.NoError(t, msg.Acknowledge())
+	assert.NoFileExists(t, filepath.Join(seasoning.ClaimCheckConfig.Directory, reference))
+
+	seasoning = newTestSeasoning(broker)
+	seasoning.ClaimCheckConfig = &tcr.ClaimCheckConfig{Enabled: true}
+	_, err = tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	assert.Error(t, err)
+}
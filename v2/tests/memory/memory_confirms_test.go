@@ -0,0 +1,148 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func publishDeferred(t *testing.T, chanHost *tcr.ChannelHost) *tcr.DeferredConfirmation {
+
+	confirmation, err := chanHost.PublishWithDeferredConfirmation("", "TcrTestQueue", false, false, amqp.Publishing{Body: []byte("confirm me")})
+	require.NoError(t, err)
+	return confirmation
+}
+
+func waitConfirmed(t *testing.T, confirmation *tcr.DeferredConfirmation) bool {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	acked, err := confirmation.Wait(ctx)
+	require.NoError(t, err)
+	return acked
+}
+
+func TestDeferredConfirmationsPipelineOnOneChannel(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	chanHost := cp.GetChannelFromPool(true)
+	defer cp.ReturnChannel(chanHost, false)
+
+	broker.HoldConfirms(true)
+
+	confirmations := make([]*tcr.DeferredConfirmation, 10)
+	for i := range confirmations {
+		confirmations[i] = publishDeferred(t, chanHost)
+		assert.Equal(t, uint64(i+1), confirmations[i].DeliveryTag)
+	}
+	assert.Equal(t, 10, chanHost.PendingConfirmations())
+
+	select {
+	case <-confirmations[0].Done():
+		t.Fatal("confirmed while the broker holds confirms")
+	default:
+	}
+
+	broker.HoldConfirms(false) // a single multiple ack for delivery tag 10
+	for _, confirmation := range confirmations {
+		assert.True(t, waitConfirmed(t, confirmation))
+	}
+	assert.Equal(t, 0, chanHost.PendingConfirmations())
+}
+
+func TestDeferredConfirmationsResolveNacksByDeliveryTag(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	chanHost := cp.GetChannelFromPool(true)
+	defer cp.ReturnChannel(chanHost, false)
+
+	broker.HoldConfirms(true)
+	first := publishDeferred(t, chanHost)
+	broker.NackNext(1)
+	second := publishDeferred(t, chanHost)
+	third := publishDeferred(t, chanHost)
+	broker.HoldConfirms(false)
+
+	assert.True(t, waitConfirmed(t, first))
+	assert.False(t, waitConfirmed(t, second))
+	assert.NoError(t, second.Err())
+	assert.True(t, waitConfirmed(t, third))
+}
+
+func TestDeferredConfirmationsFailWhenChannelCloses(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	chanHost := cp.GetChannelFromPool(true)
+	defer cp.ReturnChannel(chanHost, true)
+
+	broker.DropConfirms(true)
+	confirmation := publishDeferred(t, chanHost)
+
+	chanHost.Close()
+	select {
+	case <-confirmation.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("confirmation did not resolve when its channel closed")
+	}
+	assert.False(t, confirmation.Acked())
+	assert.True(t, errors.Is(confirmation.Err(), tcr.ErrConfirmChannelClosed))
+	assert.True(t, errors.Is(confirmation.Err(), tcr.ErrChannelClosed))
+
+	_, err := chanHost.PublishWithDeferredConfirmation("", "TcrTestQueue", false, false, amqp.Publishing{})
+	assert.Error(t, err)
+
+	plain := cp.GetChannelFromPool(false)
+	defer cp.ReturnChannel(plain, false)
+
+	_, err = plain.PublishWithDeferredConfirmation("", "TcrTestQueue", false, false, amqp.Publishing{})
+	assert.Error(t, err) // not in confirm mode
+}
+
+func TestPublishWithConfirmationSharesChannels(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	// Every ackable channel ends up awaiting several confirmations at once.
+	broker.HoldConfirms(true)
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(letter *tcr.Letter) {
+			defer wg.Done()
+			errs <- publisher.PublishWithConfirmationError(letter, 0)
+		}(tcr.CreateMockRandomLetter("TcrTestQueue"))
+	}
+
+	require.Eventually(t, func() bool { return broker.MessageCount("TcrTestQueue") == 50 }, 5*time.Second, 10*time.Millisecond)
+	broker.HoldConfirms(false)
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 50, broker.MessageCount("TcrTestQueue"))
+}
@@ -0,0 +1,74 @@
+package memory_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, cp *tcr.ConnectionPool, eventType tcr.ConnectionEventType) tcr.ConnectionEvent {
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-cp.Events():
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", eventType)
+		}
+	}
+}
+
+func TestPoolEvents(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	config := newTestSeasoning(broker).PoolConfig
+	config.BackoffPolicy = &tcr.BackoffPolicy{InitialDelay: 5, MaxAttempts: 2}
+
+	cp, err := tcr.NewConnectionPool(config)
+	require.NoError(t, err)
+	defer cp.Shutdown()
+
+	broker.Block("low on memory")
+	blocked := nextEvent(t, cp, tcr.ConnectionBlocked)
+	assert.Equal(t, "low on memory", blocked.Reason)
+	assert.False(t, blocked.Time.IsZero())
+
+	broker.Unblock()
+	nextEvent(t, cp, tcr.ConnectionUnblocked)
+
+	broker.KillConnections()
+	lost := nextEvent(t, cp, tcr.ConnectionLost)
+
+	var amqpErr *amqp.Error
+	require.True(t, errors.As(lost.Err, &amqpErr))
+	assert.Equal(t, amqp.ConnectionForced, amqpErr.Code)
+
+	chanHost := cp.GetChannelFromPool(false)
+	cp.ReturnChannel(chanHost, true)
+
+	recovered := nextEvent(t, cp, tcr.ConnectionRecovered)
+	assert.Equal(t, chanHost.ConnectionID, recovered.ConnectionID)
+
+	channelRecovered := nextEvent(t, cp, tcr.ChannelRecovered)
+	assert.Equal(t, chanHost.ID, channelRecovered.ChannelID)
+	assert.Equal(t, chanHost.ConnectionID, channelRecovered.ConnectionID)
+
+	broker.RefuseDials(true)
+	broker.KillConnections()
+
+	_, err = cp.GetConnection()
+	require.Error(t, err)
+
+	failed := nextEvent(t, cp, tcr.RecoveryFailed)
+	var exhaustedErr *tcr.RecoveryExhaustedError
+	assert.True(t, errors.As(failed.Err, &exhaustedErr))
+}
@@ -0,0 +1,96 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForBlocked(t *testing.T, cp *tcr.ConnectionPool, count int) {
+
+	require.Eventually(t, func() bool {
+		blocked := 0
+		for _, connStats := range cp.Stats().Connections {
+			if connStats.Blocked {
+				blocked++
+			}
+		}
+		return blocked == count
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestPublisherFlowControl(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, time.Second)
+	assert.Error(t, publisher.SetFlowControl("panic"))
+
+	broker.Block("low on memory")
+	waitForBlocked(t, cp, 2) // tracked in the background, no caller needed
+
+	require.NoError(t, publisher.SetFlowControl(tcr.FlowControlFailFast))
+	err := publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true)
+	assert.ErrorIs(t, err, tcr.ErrConnectionBlocked)
+
+	require.NoError(t, publisher.SetFlowControl(tcr.FlowControlWait))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = publisher.PublishContext(ctx, tcr.CreateMockRandomLetter("TcrTestQueue"), true)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	published := make(chan error, 1)
+	go func() {
+		published <- publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true)
+	}()
+
+	broker.Unblock()
+	select {
+	case err = <-published:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish did not resume after unblock")
+	}
+
+	waitForBlocked(t, cp, 0)
+	assert.Equal(t, 1, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestPublisherFlowControlRoutesToUnblockedConnection(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newClusterPool(t, broker, tcr.URISelectionRoundRobin, 2)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, time.Second)
+	require.NoError(t, publisher.SetFlowControl(tcr.FlowControlRoute))
+
+	broker.BlockNode("rabbit-0:5672", "low on disk")
+	waitForBlocked(t, cp, 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+			publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), time.Second)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing stalled on the blocked connection")
+	}
+
+	assert.Equal(t, 20, broker.MessageCount("TcrTestQueue"))
+	broker.UnblockNode("rabbit-0:5672")
+}
@@ -0,0 +1,137 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLanePublisher(t *testing.T, broker *tcrtest.Broker, lanes ...*tcr.LaneConfig) *tcr.Publisher {
+
+	seasoning := newTestSeasoning(broker)
+	seasoning.PoolConfig.MaxAckableChannelCount = 1 // auto-publishes one letter at a time, in the order they are taken
+	seasoning.PublisherConfig.Lanes = lanes
+
+	cp, err := tcr.NewConnectionPool(seasoning.PoolConfig)
+	require.NoError(t, err)
+	t.Cleanup(cp.Shutdown)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	return tcr.NewPublisherFromConfig(seasoning, cp)
+}
+
+func TestLanesPublishUrgentLettersFirst(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher := newLanePublisher(t, broker, &tcr.LaneConfig{Name: "urgent"}, &tcr.LaneConfig{Name: "bulk"})
+
+	require.True(t, publisher.QueueLetters(newTestBatch(10))) // the lowest priority lane
+	urgent := newTestBatch(3)
+	for _, letter := range urgent {
+		require.NoError(t, publisher.QueueLetterToLane(context.Background(), "urgent", letter))
+	}
+
+	publisher.StartAutoPublishing()
+	defer publisher.Shutdown(false)
+	require.Eventually(t, func() bool { return broker.MessageCount("TcrTestQueue") == 13 }, 5*time.Second, 10*time.Millisecond)
+
+	published := broker.Messages("TcrTestQueue")
+	for i, letter := range urgent {
+		assert.Equal(t, letter.LetterID.String(), published[i].MessageId)
+	}
+
+	err := publisher.QueueLetterToLane(context.Background(), "express", tcr.CreateMockRandomLetter("TcrTestQueue"))
+	assert.Error(t, err)
+}
+
+func TestLaneFailFastWhenFull(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher := newLanePublisher(t, broker, &tcr.LaneConfig{Name: "bulk", Capacity: 2, Overflow: tcr.OverflowFailFast})
+
+	require.True(t, publisher.QueueLetters(newTestBatch(2)))
+	err := publisher.QueueLetterContext(context.Background(), tcr.CreateMockRandomLetter("TcrTestQueue"))
+	assert.True(t, errors.Is(err, tcr.ErrQueueFull))
+	assert.False(t, publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report, err := publisher.ShutdownContext(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Unpublished)
+	assert.Equal(t, 2, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestLaneDropsOldestWhenFull(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher := newLanePublisher(t, broker, &tcr.LaneConfig{Name: "bulk", Capacity: 2, Overflow: tcr.OverflowDropOldest})
+
+	letters := newTestBatch(3)
+	require.True(t, publisher.QueueLetters(letters))
+
+	receipt := nextReceipt(t, publisher)
+	assert.False(t, receipt.Success)
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrQueueFull))
+	assert.Equal(t, letters[0].LetterID, receipt.LetterID)
+	assert.Same(t, letters[0], receipt.FailedLetter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := publisher.ShutdownContext(ctx)
+	require.NoError(t, err)
+
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 2)
+	assert.Equal(t, letters[1].LetterID.String(), published[0].MessageId)
+	assert.Equal(t, letters[2].LetterID.String(), published[1].MessageId)
+}
+
+func TestLaneBlocksUntilContextOrShutdown(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	publisher := newLanePublisher(t, broker, &tcr.LaneConfig{Capacity: 1})
+
+	require.True(t, publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := publisher.QueueLetterContext(ctx, tcr.CreateMockRandomLetter("TcrTestQueue"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	queued := make(chan error, 1)
+	go func() {
+		queued <- publisher.QueueLetterContext(context.Background(), tcr.CreateMockRandomLetter("TcrTestQueue"))
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	publisher.Shutdown(false)
+
+	select {
+	case err := <-queued:
+		assert.True(t, errors.Is(err, tcr.ErrShutdown))
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not wake up QueueLetterContext")
+	}
+}
+
+func TestServiceRejectsInvalidLanes(t *testing.T) {
+
+	seasoning := newTestSeasoning(tcrtest.NewBroker())
+	seasoning.PublisherConfig.Lanes = []*tcr.LaneConfig{{Name: "bulk"}, {Name: "bulk"}}
+
+	_, err := tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	assert.Error(t, err)
+
+	seasoning.PublisherConfig.Lanes = []*tcr.LaneConfig{{Name: "bulk", Overflow: "spill"}}
+	_, err = tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	assert.Error(t, err)
+}
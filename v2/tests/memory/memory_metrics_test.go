@@ -0,0 +1,140 @@
+package memory_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr/metrics"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, url string) string {
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestMetricsCollector(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service, err := tcr.NewRabbitService(newTestSeasoning(broker), "", "", nil, func(error) {})
+	require.NoError(t, err)
+	defer service.Shutdown(true)
+
+	require.NoError(t, service.Topologer.CreateQueue("TcrTestQueue", false, false, false, false, false, nil))
+
+	collector := metrics.NewCollector("")
+	require.NoError(t, collector.InstrumentRabbitService(service))
+
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	broker.NackNext(1)
+	service.Publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), time.Second)
+	require.NoError(t, service.Publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+
+	consumer, err := service.GetConsumer("TcrTestConsumer")
+	require.NoError(t, err)
+
+	handled := make(chan struct{}, 4)
+	consumer.StartConsumingWithAction(func(msg *tcr.ReceivedMessage) {
+		if msg.Delivery.Redelivered {
+			assert.NoError(t, msg.Acknowledge())
+		} else {
+			assert.NoError(t, msg.Nack(true))
+		}
+		handled <- struct{}{}
+	})
+
+	// Each message is nacked (requeued) once then acked on redelivery.
+	for i := 0; i < 4; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message was not consumed")
+		}
+	}
+	require.NoError(t, consumer.StopConsuming(false, false))
+
+	body := scrape(t, server.URL)
+	consumerName := consumer.ConsumerName
+
+	expected := []string{
+		"# TYPE tcr_publishes_total counter",
+		`tcr_publishes_total{result="success"} 3`,
+		"tcr_publish_retries_total 1",
+		`tcr_publish_confirmations_total{result="ack"} 1`,
+		`tcr_publish_confirmations_total{result="nack"} 1`,
+		"# TYPE tcr_publish_confirmation_latency_seconds histogram",
+		`tcr_publish_confirmation_latency_seconds_bucket{le="+Inf"} 2`,
+		"tcr_publish_confirmation_latency_seconds_count 2",
+		`tcr_consumer_deliveries_total{consumer="` + consumerName + `"} 4`,
+		`tcr_consumer_acknowledgements_total{consumer="` + consumerName + `",type="nack"} 2`,
+		`tcr_consumer_acknowledgements_total{consumer="` + consumerName + `",type="ack"} 2`,
+		`tcr_consumer_handler_duration_seconds_count{consumer="` + consumerName + `"} 4`,
+		`tcr_pool_connections{pool="TurboCookedRabbit"} 2`,
+		`tcr_pool_channels_cached{pool="TurboCookedRabbit"} 8`,
+		`tcr_pool_channel_wait_seconds{pool="TurboCookedRabbit",quantile="0.99"}`,
+	}
+
+	for _, line := range expected {
+		assert.Contains(t, body, line)
+	}
+}
+
+func TestMetricsCountPublishErrors(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	collector := metrics.NewCollector("")
+	require.NoError(t, collector.InstrumentConnectionPool(cp))
+	require.NoError(t, collector.InstrumentConnectionPool(cp)) // the same pool twice is a no-op
+
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	collector.InstrumentPublisher(publisher)
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{MessagesPerSecond: 1, Mode: tcr.RateLimitFailFast}))
+
+	publisher.PublishWithConfirmationContext(context.Background(), tcr.CreateMockRandomLetter("TcrTestQueue"))
+	publisher.PublishWithConfirmationContext(context.Background(), tcr.CreateMockRandomLetter("TcrTestQueue"))
+	first, second := nextReceipt(t, publisher), nextReceipt(t, publisher) // receipts are sent in no particular order
+	assert.NotEqual(t, first.Success, second.Success)
+
+	body := scrape(t, server.URL)
+	assert.Contains(t, body, `tcr_publishes_total{result="success"} 1`)
+	assert.Contains(t, body, `tcr_publishes_total{result="error"} 1`)
+	assert.Equal(t, 1, strings.Count(body, `tcr_pool_connections{pool="TurboCookedRabbit"}`))
+}
+
+func TestMetricsRejectPoolsWithTheSameName(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	collector := metrics.NewCollector("")
+	require.NoError(t, collector.InstrumentConnectionPool(newTestPool(t, broker)))
+	assert.Error(t, collector.InstrumentConnectionPool(newTestPool(t, broker)))
+
+	service := newTestService(t, broker)
+	defer service.Shutdown(true)
+	assert.Error(t, collector.InstrumentRabbitService(service))
+}
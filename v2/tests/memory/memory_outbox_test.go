@@ -0,0 +1,336 @@
+package memory_test
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func segmentCount(t *testing.T, directory string) int {
+
+	segments, err := filepath.Glob(filepath.Join(directory, "outbox-*.log"))
+	require.NoError(t, err)
+	return len(segments)
+}
+
+func TestOutboxReplaysUnconfirmedLetters(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 0, true)
+	require.NoError(t, err)
+
+	// Queued but never published, the process "crashes" without a shutdown.
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+
+	letters := newTestBatch(10)
+	require.True(t, publisher.QueueLetters(letters))
+	assert.Equal(t, 10, outbox.Pending())
+	require.NoError(t, outbox.Close())
+
+	outbox, err = tcr.OpenOutbox(directory, 0, true)
+	require.NoError(t, err)
+	assert.Equal(t, 10, outbox.Pending())
+
+	cp = newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher = tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report, err := publisher.ShutdownContext(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Unpublished)
+	assert.Equal(t, 10, broker.MessageCount("TcrTestQueue"))
+
+	published := make(map[string][]byte)
+	for _, publishing := range broker.Messages("TcrTestQueue") {
+		published[publishing.MessageId] = publishing.Body
+	}
+	for _, letter := range letters {
+		assert.Equal(t, letter.Body, published[letter.LetterID.String()])
+	}
+
+	// Everything was confirmed, nothing is replayed again.
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+	assert.Equal(t, 0, outbox.Pending())
+}
+
+func TestOutboxReplayKeepsHeaderTypes(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	headers := amqp.Table{
+		"x-delay":            int32(5000),
+		"x-tcr-count":        int64(7),
+		"x-tcr-ratio":        float64(0.5),
+		"x-tcr-flag":         true,
+		"x-tcr-name":         "carrot",
+		"x-tcr-bytes":        []byte{1, 2, 3},
+		"x-tcr-time":         time.Unix(1700000000, 0).UTC(),
+		"x-tcr-nested":       amqp.Table{"x-tcr-small": int16(3)},
+		"x-tcr-list":         []interface{}{int32(1), "two"},
+		tcr.ChunkIndexHeader: int32(0),
+	}
+
+	broker := tcrtest.NewBroker()
+	publisher := tcr.NewPublisher(newTestPool(t, broker), 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Envelope.Headers = headers
+	require.True(t, publisher.QueueLetter(letter))
+	require.NoError(t, outbox.Close())
+
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher = tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = publisher.ShutdownContext(ctx)
+	require.NoError(t, err)
+
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 1)
+	assert.Equal(t, headers, published[0].Headers) // same values, same types
+}
+
+func TestOutboxSkipsRequeuedAndDiscardedLetters(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	broker := tcrtest.NewBroker()
+	publisher := tcr.NewPublisher(newTestPool(t, broker), 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+
+	letters := newTestBatch(3)
+	require.True(t, publisher.QueueLetters(letters))
+	require.True(t, publisher.QueueLetter(letters[0])) // a requeue after a failed publish
+	assert.Equal(t, 3, outbox.Pending())
+
+	require.NoError(t, outbox.Discard(letters[1].LetterID))
+	assert.Equal(t, 2, outbox.Pending())
+	require.NoError(t, outbox.Close())
+	assert.False(t, publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+	assert.Equal(t, 2, outbox.Pending())
+}
+
+func TestOutboxDeletesDoneSegments(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 512, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+
+	require.True(t, publisher.QueueLetters(newTestBatch(50)))
+	assert.Greater(t, segmentCount(t, directory), 1)
+
+	publisher.StartAutoPublishing()
+	require.Eventually(t, func() bool { return outbox.Pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, segmentCount(t, directory)) // only the active segment is left
+}
+
+func TestOutboxTruncatesTornWrite(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	publisher := tcr.NewPublisher(newTestPool(t, tcrtest.NewBroker()), 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+	require.True(t, publisher.QueueLetters(newTestBatch(2)))
+	require.NoError(t, outbox.Close())
+
+	// A crash mid-write leaves half a record at the end of the last segment.
+	segments, err := filepath.Glob(filepath.Join(directory, "outbox-*.log"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segments[0], info.Size()-5))
+
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, outbox.Pending())
+	require.NoError(t, outbox.Close())
+
+	// Corruption anywhere else is an error.
+	file, err := os.OpenFile(segments[0], os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte{0xFF}, 12)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = tcr.OpenOutbox(directory, 0, false)
+	assert.Error(t, err)
+}
+
+func TestOutboxBoundsRecordLength(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	publisher := tcr.NewPublisher(newTestPool(t, tcrtest.NewBroker()), 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+	require.True(t, publisher.QueueLetters(newTestBatch(2)))
+	require.NoError(t, outbox.Close())
+
+	segments, err := filepath.Glob(filepath.Join(directory, "outbox-*.log"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// A corrupt length on the last record claims 4GB, it is cut off instead of allocated.
+	data, err := os.ReadFile(segments[0])
+	require.NoError(t, err)
+	second := 8 + binary.BigEndian.Uint32(data[0:4])
+	binary.BigEndian.PutUint32(data[second:second+4], math.MaxUint32)
+	require.NoError(t, os.WriteFile(segments[0], data, 0600))
+
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+	assert.Equal(t, 1, outbox.Pending())
+}
+
+func TestOutboxReplayGivesUpOnShutdown(t *testing.T) {
+
+	directory := t.TempDir()
+	outbox, err := tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	broker := tcrtest.NewBroker()
+	publisher := tcr.NewPublisher(newTestPool(t, broker), 0, 0, 5*time.Second)
+	publisher.SetOutbox(outbox)
+	require.True(t, publisher.QueueLetters(newTestBatch(10)))
+	require.NoError(t, outbox.Close())
+
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+
+	// The lane holds 2 of the replayed letters, the rest wait for room that never comes.
+	publisher = newLanePublisher(t, broker, &tcr.LaneConfig{Name: "bulk", Capacity: 2})
+	publisher.SetOutbox(outbox)
+	publisher.Shutdown(false)
+
+	outbox, err = tcr.OpenOutbox(directory, 0, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+	assert.Equal(t, 10, outbox.Pending()) // replayed again next time
+}
+
+func TestServiceOutboxFromConfig(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.OutboxDirectory = t.TempDir()
+
+	service, err := tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	require.NoError(t, err)
+	require.NoError(t, service.Topologer.CreateQueue("TcrTestQueue", false, false, false, false, false, nil))
+
+	require.NoError(t, service.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = service.ShutdownContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, broker.MessageCount("TcrTestQueue"))
+
+	outbox, err := tcr.OpenOutbox(seasoning.PublisherConfig.OutboxDirectory, 0, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+	assert.Equal(t, 0, outbox.Pending())
+}
+
+func TestServiceOutboxDiscardsFailedLetters(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.OutboxDirectory = t.TempDir()
+	seasoning.PublisherConfig.PublishTimeOutInterval = 50
+	seasoning.PublisherConfig.MaxRetryCount = 1
+
+	errs := make(chan error, 10)
+	service, err := tcr.NewRabbitService(seasoning, "", "", nil, func(err error) { errs <- err })
+	require.NoError(t, err)
+	require.NoError(t, service.Topologer.CreateQueue("TcrTestQueue", false, false, false, false, false, nil))
+
+	unroutable := tcr.CreateMockRandomLetter("NoSuchQueue")
+	unroutable.Envelope.Mandatory = true
+	require.NoError(t, service.QueueLetter(unroutable))
+
+	broker.DropConfirms(true) // times out until it runs out of retries
+	require.NoError(t, service.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+
+	exhausted := 0
+	for exhausted < 2 {
+		select {
+		case err := <-errs:
+			if strings.Contains(err.Error(), "exhausted") {
+				exhausted++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("letters did not run out of retries")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = service.ShutdownContext(ctx)
+	require.NoError(t, err)
+	published := broker.MessageCount("TcrTestQueue")
+
+	// Restarted, the failed letters are not replayed.
+	broker.DropConfirms(false)
+	service, err = tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	require.NoError(t, err)
+
+	_, err = service.ShutdownContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, published, broker.MessageCount("TcrTestQueue"))
+
+	outbox, err := tcr.OpenOutbox(seasoning.PublisherConfig.OutboxDirectory, 0, false)
+	require.NoError(t, err)
+	defer outbox.Close()
+	assert.Equal(t, 0, outbox.Pending())
+}
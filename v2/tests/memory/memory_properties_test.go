@@ -0,0 +1,123 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPropertiesLetter(timestamp time.Time) *tcr.Letter {
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Envelope.ContentType = "application/json"
+	letter.Envelope.ContentEncoding = "gzip"
+	letter.Envelope.CorrelationID = "TcrCorrelation"
+	letter.Envelope.ReplyTo = "TcrReplyQueue"
+	letter.Envelope.Expiration = "60000"
+	letter.Envelope.MessageID = "TcrMessage"
+	letter.Envelope.Timestamp = timestamp
+	letter.Envelope.Type = "TcrType"
+	letter.Envelope.UserID = "guest"
+	letter.Envelope.AppID = "TcrApp"
+	letter.Envelope.Headers = amqp.Table{"x-tcr": "header"}
+	letter.Envelope.DeliveryMode = amqp.Persistent
+	letter.Envelope.Priority = 4
+	return letter
+}
+
+func TestEnvelopePropertiesOnEveryPublish(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	consumer := tcr.NewConsumerFromConfig(newTestSeasoning(broker).ConsumerConfigs["TcrTestConsumer"], cp)
+
+	publishes := map[string]func(*tcr.Letter) error{
+		"Publish": func(letter *tcr.Letter) error {
+			publisher.Publish(letter, true)
+			return nil
+		},
+		"PublishWithError": func(letter *tcr.Letter) error { return publisher.PublishWithError(letter, true) },
+		"PublishContext": func(letter *tcr.Letter) error {
+			return publisher.PublishContext(context.Background(), letter, true)
+		},
+		"PublishWithTransient": publisher.PublishWithTransient,
+		"PublishWithConfirmation": func(letter *tcr.Letter) error {
+			publisher.PublishWithConfirmation(letter, 0)
+			return nextReceipt(t, publisher).Error
+		},
+		"PublishWithConfirmationError": func(letter *tcr.Letter) error {
+			return publisher.PublishWithConfirmationError(letter, 0)
+		},
+		"PublishWithConfirmationContext": func(letter *tcr.Letter) error {
+			publisher.PublishWithConfirmationContext(context.Background(), letter)
+			return nextReceipt(t, publisher).Error
+		},
+		"PublishWithConfirmationContextError": func(letter *tcr.Letter) error {
+			return publisher.PublishWithConfirmationContextError(context.Background(), letter)
+		},
+		"PublishWithConfirmationTransient": func(letter *tcr.Letter) error {
+			publisher.PublishWithConfirmationTransient(letter, 0)
+			return nextReceipt(t, publisher).Error
+		},
+		"PublishBatchWithConfirmation": func(letter *tcr.Letter) error {
+			_, err := publisher.PublishBatchWithConfirmation(context.Background(), []*tcr.Letter{letter})
+			return err
+		},
+	}
+
+	timestamp := time.Date(2020, 2, 20, 12, 0, 0, 0, time.UTC)
+	for name, publish := range publishes {
+		t.Run(name, func(t *testing.T) {
+
+			require.NoError(t, publish(newPropertiesLetter(timestamp)))
+
+			delivery, err := consumer.Get("TcrTestQueue")
+			require.NoError(t, err)
+			require.NotNil(t, delivery)
+
+			msg := tcr.NewReceivedMessage(false, *delivery)
+			assert.Equal(t, "application/json", msg.ContentType())
+			assert.Equal(t, "gzip", msg.ContentEncoding())
+			assert.Equal(t, "TcrCorrelation", msg.CorrelationID())
+			assert.Equal(t, "TcrReplyQueue", msg.ReplyTo())
+			assert.Equal(t, time.Minute, msg.Expiration())
+			assert.Equal(t, "TcrMessage", msg.MessageID)
+			assert.True(t, timestamp.Equal(msg.Timestamp()))
+			assert.Equal(t, "TcrType", msg.Type())
+			assert.Equal(t, "guest", msg.UserID())
+			assert.Equal(t, "TcrApp", msg.ApplicationID)
+			assert.Equal(t, amqp.Table{"x-tcr": "header"}, msg.Headers())
+			assert.Equal(t, amqp.Persistent, msg.DeliveryMode())
+			assert.Equal(t, uint8(4), msg.Priority())
+		})
+	}
+}
+
+func TestEnvelopePropertiesDefaults(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	started := time.Now()
+	require.NoError(t, publisher.PublishWithConfirmationError(letter, 0))
+
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 1)
+	assert.Equal(t, letter.LetterID.String(), published[0].MessageId)
+	assert.Equal(t, "TurboCookedRabbit", published[0].AppId)
+	assert.False(t, published[0].Timestamp.Before(started.Truncate(time.Second)))
+
+	msg := tcr.NewReceivedMessage(false, amqp.Delivery{Expiration: "soon"})
+	assert.Equal(t, time.Duration(0), msg.Expiration())
+}
@@ -0,0 +1,178 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitWaits(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{MessagesPerSecond: 20}))
+
+	// The first second worth is a burst, the next 10 are spread over half a second.
+	letters := newTestBatch(30)
+	started := time.Now()
+	for _, letter := range letters {
+		require.NoError(t, publisher.PublishWithConfirmationError(letter, 0))
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 450*time.Millisecond)
+	assert.Equal(t, 30, broker.MessageCount("TcrTestQueue"))
+
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{MessagesPerSecond: 1}))
+	require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := publisher.PublishContext(ctx, tcr.CreateMockRandomLetter("TcrTestQueue"), true)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 31, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestRateLimitFailFast(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{BytesPerSecond: 4000, Mode: tcr.RateLimitFailFast}))
+
+	letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+	letter.Body = make([]byte, 1500)
+	require.NoError(t, publisher.PublishWithError(letter, true))
+	require.NoError(t, publisher.PublishWithConfirmationError(letter, 0))
+
+	err := publisher.PublishWithError(letter, true)
+	assert.True(t, errors.Is(err, tcr.ErrRateLimited))
+	err = publisher.PublishWithTransient(letter)
+	assert.True(t, errors.Is(err, tcr.ErrRateLimited))
+	assert.Equal(t, 2, broker.MessageCount("TcrTestQueue"))
+
+	// Bigger than a second worth, it waits for a full bucket instead of never getting through.
+	letter.Body = make([]byte, 10000)
+	require.Eventually(t, func() bool { return publisher.PublishWithError(letter, true) == nil }, 2*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, publisher.SetRateLimit(nil))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, publisher.PublishWithError(letter, true))
+	}
+}
+
+func TestRateLimitScopes(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	newTestQueue(t, cp, "TcrTestQueue2")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	require.Error(t, publisher.SetRateLimit(&tcr.RateLimitConfig{MessagesPerSecond: 1, Scope: "queue"}))
+	require.Error(t, publisher.SetRateLimit(&tcr.RateLimitConfig{MessagesPerSecond: 1, Mode: "drop"}))
+
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{
+		MessagesPerSecond: 1,
+		Scope:             tcr.RateLimitScopeRoutingKey,
+		Mode:              tcr.RateLimitFailFast,
+	}))
+
+	require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+	require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue2"), true))
+	assert.True(t, errors.Is(publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true), tcr.ErrRateLimited))
+
+	// Every routing key goes through the default exchange, which is the scope now.
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{
+		MessagesPerSecond: 1,
+		Scope:             tcr.RateLimitScopeExchange,
+		Mode:              tcr.RateLimitFailFast,
+	}))
+
+	require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+	assert.True(t, errors.Is(publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue2"), true), tcr.ErrRateLimited))
+}
+
+func TestRateLimitEvictsIdleScopes(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.SetRateLimit(&tcr.RateLimitConfig{
+		MessagesPerSecond: 1,
+		Scope:             tcr.RateLimitScopeRoutingKey,
+		Mode:              tcr.RateLimitFailFast,
+	}))
+
+	require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+	time.Sleep(1100 * time.Millisecond) // idle long enough to refill, it is evicted by the next sweep
+	require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+
+	// Enough routing keys to sweep the idle scopes, the busy one keeps its limit.
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, publisher.PublishWithError(tcr.CreateMockRandomLetter(fmt.Sprintf("TcrTestKey%d", i)), true))
+	}
+	assert.True(t, errors.Is(publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true), tcr.ErrRateLimited))
+}
+
+func TestRateLimitAutoPublishWaitsInFailFast(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.PublishTimeOutInterval = 5000
+	seasoning.PublisherConfig.RateLimit = &tcr.RateLimitConfig{MessagesPerSecond: 20, Mode: tcr.RateLimitFailFast}
+	publisher := tcr.NewPublisherFromConfig(seasoning, cp)
+
+	started := time.Now()
+	require.True(t, publisher.QueueLetters(newTestBatch(30)))
+	publisher.StartAutoPublishing()
+	defer publisher.Shutdown(false)
+
+	for i := 0; i < 30; i++ {
+		receipt := nextReceipt(t, publisher)
+		require.True(t, receipt.Success, "%v", receipt.Error) // queued letters wait for the limit instead of failing
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 450*time.Millisecond)
+	assert.Equal(t, 30, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestServiceRejectsInvalidRateLimit(t *testing.T) {
+
+	seasoning := newTestSeasoning(tcrtest.NewBroker())
+	seasoning.PublisherConfig.RateLimit = &tcr.RateLimitConfig{MessagesPerSecond: 10, Mode: "sometimes"}
+
+	_, err := tcr.NewRabbitService(seasoning, "", "", nil, func(error) {})
+	assert.Error(t, err)
+}
+
+func TestPublisherFromConfigWithErrorRejectsInvalidConfig(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.RateLimit = &tcr.RateLimitConfig{MessagesPerSecond: 10, Scope: "queue"}
+	_, err := tcr.NewPublisherFromConfigWithError(seasoning, cp)
+	assert.Error(t, err)
+
+	seasoning = newTestSeasoning(broker)
+	seasoning.PublisherConfig.FlowControl = "sometimes"
+	_, err = tcr.NewPublisherFromConfigWithError(seasoning, cp)
+	assert.Error(t, err)
+
+	publisher, err := tcr.NewPublisherFromConfigWithError(newTestSeasoning(broker), cp)
+	require.NoError(t, err)
+	assert.NotNil(t, publisher)
+}
@@ -0,0 +1,116 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishReceiptRecordsConfirmation(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	started := time.Now()
+	broker.NackNext(2)
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), 0)
+
+	receipt := nextReceipt(t, publisher)
+	require.True(t, receipt.Success, "%v", receipt.Error)
+	assert.Equal(t, 3, receipt.Attempts) // republished after both nacks
+	assert.False(t, receipt.PublishedAt.Before(started))
+	assert.False(t, receipt.ConfirmedAt.Before(receipt.PublishedAt))
+	assert.Equal(t, receipt.ConfirmedAt.Sub(receipt.PublishedAt), receipt.ConfirmLatency)
+
+	broker.NackNext(1)
+	publisher.PublishWithConfirmationTransient(tcr.CreateMockRandomLetter("TcrTestQueue"), 0)
+	receipt = nextReceipt(t, publisher)
+	require.True(t, receipt.Success, "%v", receipt.Error)
+	assert.Equal(t, 2, receipt.Attempts) // republished after the nack, on the same transient channel
+	assert.Equal(t, uint64(0), receipt.ChannelID)
+	assert.False(t, receipt.ConfirmedAt.IsZero())
+
+	publisher.Publish(tcr.CreateMockRandomLetter("TcrTestQueue"), false)
+	receipt = nextReceipt(t, publisher)
+	require.True(t, receipt.Success, "%v", receipt.Error)
+	assert.Equal(t, 1, receipt.Attempts)
+	assert.False(t, receipt.PublishedAt.IsZero())
+	assert.True(t, receipt.ConfirmedAt.IsZero()) // no confirmation without confirm mode
+}
+
+func TestPublishReceiptConfirmTimeout(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	broker.DropConfirms(true)
+	publisher.PublishWithConfirmation(tcr.CreateMockRandomLetter("TcrTestQueue"), 50*time.Millisecond)
+
+	receipt := nextReceipt(t, publisher)
+	assert.False(t, receipt.Success)
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrConfirmTimeout))
+	assert.NotNil(t, receipt.FailedLetter)
+	assert.Equal(t, 1, receipt.Attempts)
+	assert.True(t, receipt.ConfirmedAt.IsZero())
+
+	err := publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 50*time.Millisecond)
+	assert.True(t, errors.Is(err, tcr.ErrConfirmTimeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = publisher.PublishWithConfirmationContextError(ctx, tcr.CreateMockRandomLetter("TcrTestQueue"))
+	assert.True(t, errors.Is(err, tcr.ErrConfirmTimeout))
+}
+
+func TestBatchReceiptsNackedAfterRetries(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	seasoning := newTestSeasoning(broker)
+	seasoning.PublisherConfig.MaxRetryCount = 2
+	publisher := tcr.NewPublisherFromConfig(seasoning, cp)
+
+	broker.NackNext(1000)
+	result, err := publisher.PublishBatchWithConfirmation(context.Background(), newTestBatch(3))
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Failed)
+
+	for _, receipt := range result.Receipts {
+		assert.True(t, errors.Is(receipt.Error, tcr.ErrNacked))
+		assert.Equal(t, 3, receipt.Attempts)
+		assert.False(t, receipt.ConfirmedAt.IsZero())
+	}
+}
+
+func TestChannelErrorIsChannelClosed(t *testing.T) {
+
+	err := error(&tcr.ChannelError{ConnectionID: 1, ChannelID: 2, Err: amqp.ErrClosed})
+	assert.True(t, errors.Is(err, tcr.ErrChannelClosed))
+	assert.True(t, errors.Is(err, amqp.ErrClosed))
+
+	var channelError *tcr.ChannelError
+	require.True(t, errors.As(err, &channelError))
+	assert.Equal(t, uint64(2), channelError.ChannelID)
+}
+
+func TestServiceShutdownErrors(t *testing.T) {
+
+	service := newTestService(t, tcrtest.NewBroker())
+	service.Shutdown(true)
+
+	assert.True(t, errors.Is(service.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")), tcr.ErrShutdown))
+	assert.True(t, errors.Is(service.PublishLetter(tcr.CreateMockRandomLetter("TcrTestQueue")), tcr.ErrShutdown))
+}
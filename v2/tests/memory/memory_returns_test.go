@@ -0,0 +1,135 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMandatoryLetter(queueName string) *tcr.Letter {
+
+	letter := tcr.CreateMockRandomLetter(queueName)
+	letter.Envelope.Mandatory = true
+	return letter
+}
+
+func nextReturn(t *testing.T, publisher *tcr.Publisher) *tcr.ReturnMessage {
+
+	select {
+	case returned := <-publisher.Returns():
+		return returned
+	case <-time.After(5 * time.Second):
+		t.Fatal("no return received")
+		return nil
+	}
+}
+
+func nextReceipt(t *testing.T, publisher *tcr.Publisher) *tcr.PublishReceipt {
+
+	select {
+	case receipt := <-publisher.PublishReceipts():
+		return receipt
+	case <-time.After(5 * time.Second):
+		t.Fatal("no receipt received")
+		return nil
+	}
+}
+
+func TestMandatoryPublishWithConfirmationReturned(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	letter := newMandatoryLetter("NoSuchQueue")
+	err := publisher.PublishWithConfirmationError(letter, 0)
+	assert.True(t, errors.Is(err, tcr.ErrUnroutable))
+	assert.Equal(t, uint32(0), letter.RetryCount) // never republished
+
+	returned := nextReturn(t, publisher)
+	assert.Equal(t, letter.LetterID.String(), returned.MessageID)
+	assert.Equal(t, uint16(amqp.NoRoute), returned.ReplyCode)
+	assert.Equal(t, "NoSuchQueue", returned.RoutingKey)
+	assert.Equal(t, letter.Body, returned.Body)
+
+	// Routable mandatory letters, and unroutable letters that aren't mandatory, are not returned.
+	require.NoError(t, publisher.PublishWithConfirmationError(newMandatoryLetter("TcrTestQueue"), 0))
+	require.NoError(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("NoSuchQueue"), 0))
+	assert.Empty(t, publisher.Returns())
+
+	letter = newMandatoryLetter("NoSuchQueue")
+	publisher.PublishWithConfirmation(letter, 0)
+	receipt := nextReceipt(t, publisher)
+	assert.False(t, receipt.Success)
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrUnroutable))
+	assert.Equal(t, letter, receipt.FailedLetter)
+	nextReturn(t, publisher)
+
+	publisher.PublishWithConfirmationTransient(newMandatoryLetter("NoSuchQueue"), 0)
+	receipt = nextReceipt(t, publisher)
+	assert.True(t, errors.Is(receipt.Error, tcr.ErrUnroutable))
+	nextReturn(t, publisher)
+}
+
+func TestMandatoryPublishWithoutConfirmationReturned(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	letter := newMandatoryLetter("NoSuchQueue")
+	require.NoError(t, publisher.PublishWithError(letter, true))
+
+	returned := nextReturn(t, publisher)
+	assert.Equal(t, letter.LetterID.String(), returned.MessageID)
+}
+
+func TestDeferredConfirmationsCorrelateReturns(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	chanHost := cp.GetChannelFromPool(true)
+	defer cp.ReturnChannel(chanHost, false)
+
+	broker.HoldConfirms(true)
+	routed, err := chanHost.PublishWithDeferredConfirmation("", "TcrTestQueue", true, false, amqp.Publishing{MessageId: "routed"})
+	require.NoError(t, err)
+	unroutable, err := chanHost.PublishWithDeferredConfirmation("", "NoSuchQueue", true, false, amqp.Publishing{MessageId: "unroutable"})
+	require.NoError(t, err)
+	broker.HoldConfirms(false)
+
+	assert.True(t, waitConfirmed(t, routed))
+	assert.Nil(t, routed.Returned())
+
+	acked, err := unroutable.Wait(context.Background())
+	assert.False(t, acked)
+	assert.True(t, errors.Is(err, tcr.ErrUnroutable))
+	require.NotNil(t, unroutable.Returned())
+	assert.Equal(t, "unroutable", unroutable.Returned().MessageID)
+}
+
+func TestPublishBatchWithConfirmationFailsUnroutable(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+
+	letters := []*tcr.Letter{newMandatoryLetter("TcrTestQueue"), newMandatoryLetter("NoSuchQueue"), newMandatoryLetter("TcrTestQueue")}
+	result, err := publisher.PublishBatchWithConfirmation(context.Background(), letters)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Acked)
+	require.Len(t, result.Failures(), 1)
+	assert.Equal(t, letters[1].LetterID, result.Failures()[0].LetterID)
+	assert.True(t, errors.Is(result.Failures()[0].Error, tcr.ErrUnroutable))
+}
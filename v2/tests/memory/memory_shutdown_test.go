@@ -0,0 +1,201 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, broker *tcrtest.Broker) *tcr.RabbitService {
+
+	service, err := tcr.NewRabbitService(newTestSeasoning(broker), "", "", nil, func(error) {})
+	require.NoError(t, err)
+	require.NoError(t, service.Topologer.CreateQueue("TcrTestQueue", false, false, false, false, false, nil))
+
+	return service
+}
+
+func TestServiceShutdownContextDrainsBacklog(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+
+	for i := 0; i < 100; i++ {
+		require.True(t, service.Publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report, err := service.ShutdownContext(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Unpublished)
+	assert.Empty(t, report.InFlight)
+	assert.Equal(t, 100, broker.MessageCount("TcrTestQueue"))
+	assert.Equal(t, 0, broker.ConnectionCount())
+
+	assert.False(t, service.Publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+}
+
+func TestServiceShutdownContextReportsLettersLeftBehind(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+	broker.DropConfirms(true)
+
+	letters := make(map[*tcr.Letter]bool)
+	for i := 0; i < 20; i++ {
+		letter := tcr.CreateMockRandomLetter("TcrTestQueue")
+		letters[letter] = true
+		require.True(t, service.Publisher.QueueLetter(letter))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	report, err := service.ShutdownContext(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.NotEmpty(t, report.InFlight) // published, never confirmed
+
+	for _, letter := range append(report.Unpublished, report.InFlight...) {
+		assert.True(t, letters[letter])
+		delete(letters, letter)
+	}
+	assert.Empty(t, letters) // every letter is accounted for exactly once
+}
+
+func TestServiceRefusesPublishesOnceShuttingDown(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+	broker.DropConfirms(true)
+	require.True(t, service.Publisher.QueueLetter(tcr.CreateMockRandomLetter("TcrTestQueue")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = service.ShutdownContext(ctx)
+	}()
+
+	// The backlog is still draining (its confirmation never comes), new publishes are refused already.
+	require.Eventually(t, func() bool {
+		return errors.Is(service.PublishData([]byte("late"), "", "TcrTestQueue", nil), tcr.ErrShutdown)
+	}, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("shutdown finished before the publish was refused")
+	default:
+	}
+
+	_, err := service.PublishBatchWithConfirmation(context.Background(), []interface{}{"late"}, "", "TcrTestQueue", "", false, nil)
+	assert.True(t, errors.Is(err, tcr.ErrShutdown))
+	<-done
+}
+
+func TestServiceShutdownRefusesPublishesWhenItReturns(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+
+	service.Shutdown(true)
+	err := service.Publish("late", "", "TcrTestQueue", "", false, nil)
+	assert.True(t, errors.Is(err, tcr.ErrShutdown))
+}
+
+func TestConsumerStopConsumingContextWaitsForAcks(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+	defer service.Shutdown(false)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, service.Publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+	}
+
+	consumer, err := service.GetConsumer("TcrTestConsumer")
+	require.NoError(t, err)
+	consumer.StartConsuming()
+
+	first := <-consumer.ReceivedMessages()
+	require.Eventually(t, func() bool { return len(consumer.ReceivedMessages()) == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- consumer.StopConsumingContext(context.Background()) }()
+
+	// Messages buffered in ReceivedMessages are still handed out after the stop, none are dropped.
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-consumer.ReceivedMessages():
+			require.NoError(t, msg.Acknowledge())
+		case <-time.After(5 * time.Second):
+			t.Fatal("buffered message was dropped")
+		}
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("stopped before the first message was acknowledged")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, first.Acknowledge())
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+	assert.Equal(t, 0, broker.ConsumerCount("TcrTestQueue"))
+	assert.False(t, consumer.Started())
+}
+
+func TestConsumerStopConsumingContextGivesUp(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+	defer service.Shutdown(false)
+
+	require.NoError(t, service.Publisher.PublishWithError(tcr.CreateMockRandomLetter("TcrTestQueue"), true))
+
+	consumer, err := service.GetConsumer("TcrTestConsumer")
+	require.NoError(t, err)
+	received := make(chan struct{}, 1)
+	consumer.StartConsumingWithAction(func(*tcr.ReceivedMessage) { received <- struct{}{} }) // never acks
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = consumer.StopConsumingContext(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestConsumerStopConsumingContextWithoutConsumerName(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	config := *newTestSeasoning(broker).ConsumerConfigs["TcrTestConsumer"]
+	config.ConsumerName = "" // tagged by tcr, it can still be cancelled
+	consumer := tcr.NewConsumerFromConfig(&config, cp)
+	consumer.StartConsuming()
+	require.Eventually(t, func() bool { return broker.ConsumerCount("TcrTestQueue") == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, consumer.StopConsumingContext(ctx))
+	assert.Equal(t, 0, broker.ConsumerCount("TcrTestQueue"))
+}
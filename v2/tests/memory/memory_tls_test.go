@@ -0,0 +1,386 @@
+package memory_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The TLS tests dial a local TLS listener that speaks just enough AMQP 0-9-1 to open (and close) a connection.
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+type testPKI struct {
+	ca     *testCertificate
+	server *testCertificate
+	client *testCertificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+
+	ca := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "tcr-test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+
+	server := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rabbit.local"},
+		DNSNames:    []string{"rabbit.local"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+
+	client := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "tcr-client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	return &testPKI{ca: ca, server: server, client: client}
+}
+
+// amqpHandshake is what the test server saw of a client.
+type amqpHandshake struct {
+	ServerName string
+	Version    uint16
+	ClientCN   string
+	Mechanism  string
+	Response   string
+	Vhost      string
+}
+
+func newTLSServer(t *testing.T, pki *testPKI) (string, <-chan amqpHandshake) {
+
+	serverCert, err := tls.X509KeyPair(pki.server.certPEM, pki.server.keyPEM)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(pki.ca.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	handshakes := make(chan amqpHandshake, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveAMQP(conn.(*tls.Conn), handshakes)
+		}
+	}()
+
+	return listener.Addr().String(), handshakes
+}
+
+func serveAMQP(conn *tls.Conn, handshakes chan<- amqpHandshake) {
+	defer conn.Close()
+
+	if err := conn.Handshake(); err != nil {
+		return
+	}
+
+	state := conn.ConnectionState()
+	handshake := amqpHandshake{ServerName: state.ServerName, Version: state.Version}
+	if len(state.PeerCertificates) > 0 {
+		handshake.ClientCN = state.PeerCertificates[0].Subject.CommonName
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+
+	start := &bytes.Buffer{}
+	start.Write([]byte{0, 9})                // version
+	writeLongString(start, "")               // server properties, an empty table
+	writeLongString(start, "PLAIN EXTERNAL") // mechanisms
+	writeLongString(start, "en_US")          // locales
+	if writeMethod(conn, 10, 10, start.Bytes()) != nil {
+		return
+	}
+
+	args, ok := readMethod(conn, 10, 11) // start-ok
+	if !ok {
+		return
+	}
+	args = args[4+binary.BigEndian.Uint32(args):] // client properties
+	handshake.Mechanism, args = readShortString(args)
+	handshake.Response, _ = readLongString(args)
+
+	tune := make([]byte, 8)
+	binary.BigEndian.PutUint32(tune[2:], 131072) // frame max, no channel max or heartbeat
+	if writeMethod(conn, 10, 30, tune) != nil {
+		return
+	}
+
+	if _, ok = readMethod(conn, 10, 31); !ok { // tune-ok
+		return
+	}
+
+	if args, ok = readMethod(conn, 10, 40); !ok { // open
+		return
+	}
+	handshake.Vhost, _ = readShortString(args)
+
+	if writeMethod(conn, 10, 41, []byte{0}) != nil { // open-ok
+		return
+	}
+	handshakes <- handshake
+
+	if _, ok = readMethod(conn, 10, 50); ok { // close
+		_ = writeMethod(conn, 10, 51, nil) // close-ok
+	}
+}
+
+func writeMethod(w io.Writer, classID, methodID uint16, args []byte) error {
+
+	frame := &bytes.Buffer{}
+	frame.WriteByte(1) // method frame
+	_ = binary.Write(frame, binary.BigEndian, uint16(0))
+	_ = binary.Write(frame, binary.BigEndian, uint32(4+len(args)))
+	_ = binary.Write(frame, binary.BigEndian, classID)
+	_ = binary.Write(frame, binary.BigEndian, methodID)
+	frame.Write(args)
+	frame.WriteByte(0xCE)
+
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+// readMethod reads frames until a method frame, ok when it is the expected method.
+func readMethod(r io.Reader, classID, methodID uint16) ([]byte, bool) {
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, false
+		}
+
+		payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, false
+		}
+
+		if header[0] != 1 {
+			continue // heartbeats
+		}
+
+		ok := binary.BigEndian.Uint16(payload) == classID && binary.BigEndian.Uint16(payload[2:]) == methodID
+		return payload[4 : len(payload)-1], ok
+	}
+}
+
+func writeLongString(buffer *bytes.Buffer, value string) {
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(value)))
+	buffer.WriteString(value)
+}
+
+func readShortString(args []byte) (string, []byte) {
+	size := int(args[0])
+	return string(args[1 : 1+size]), args[1+size:]
+}
+
+func readLongString(args []byte) (string, []byte) {
+	size := int(binary.BigEndian.Uint32(args))
+	return string(args[4 : 4+size]), args[4+size:]
+}
+
+func connectTLS(t *testing.T, uri string, tlsConfig *tcr.TLSConfig) (*tcr.ConnectionHost, error) {
+
+	connHost, err := tcr.NewConnectionHostWithURIs(
+		[]string{uri},
+		tcr.URISelectionPreferFirst,
+		"TcrTLS",
+		0,
+		0,
+		5*time.Second,
+		tlsConfig,
+		nil)
+	if err == nil {
+		t.Cleanup(func() { _ = connHost.TransportConnection().Close() })
+	}
+
+	return connHost, err
+}
+
+func nextHandshake(t *testing.T, handshakes <-chan amqpHandshake) amqpHandshake {
+
+	select {
+	case handshake := <-handshakes:
+		return handshake
+	case <-time.After(5 * time.Second):
+		t.Fatal("no amqp handshake")
+		return amqpHandshake{}
+	}
+}
+
+func TestTLSWithClientCertificateFilesAndSASLExternal(t *testing.T) {
+
+	pki := newTestPKI(t)
+	addr, handshakes := newTLSServer(t, pki)
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"ca.pem":     pki.ca.certPEM,
+		"client.pem": pki.client.certPEM,
+		"client.key": pki.client.keyPEM,
+	}
+	for name, contents := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), contents, 0600))
+	}
+
+	_, err := connectTLS(t, "amqp://guest:guest@"+addr+"/tcr", &tcr.TLSConfig{
+		EnableTLS:          true,
+		PEMCertLocation:    filepath.Join(dir, "ca.pem"),
+		ClientCertLocation: filepath.Join(dir, "client.pem"),
+		ClientKeyLocation:  filepath.Join(dir, "client.key"),
+		CertServerName:     "rabbit.local",
+		MinVersion:         "1.3",
+		SASLExternal:       true,
+	})
+	require.NoError(t, err)
+
+	handshake := nextHandshake(t, handshakes)
+	assert.Equal(t, "rabbit.local", handshake.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), handshake.Version)
+	assert.Equal(t, "tcr-client", handshake.ClientCN)
+	assert.Equal(t, "EXTERNAL", handshake.Mechanism)
+	assert.Equal(t, "tcr", handshake.Vhost) // the configured URI is dialed, not just the server name
+}
+
+func TestTLSWithInMemoryPEM(t *testing.T) {
+
+	pki := newTestPKI(t)
+	addr, handshakes := newTLSServer(t, pki)
+
+	connHost, err := connectTLS(t, "amqps://guest:secret@"+addr+"/", &tcr.TLSConfig{
+		EnableTLS:     true,
+		CACertPEM:     string(pki.ca.certPEM),
+		ClientCertPEM: string(pki.client.certPEM),
+		ClientKeyPEM:  string(pki.client.keyPEM),
+		MinVersion:    "1.2",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "amqps://guest:secret@"+addr+"/", connHost.CurrentURI())
+
+	handshake := nextHandshake(t, handshakes)
+	assert.Equal(t, "tcr-client", handshake.ClientCN)
+	assert.Equal(t, "PLAIN", handshake.Mechanism)
+	assert.Equal(t, "\x00guest\x00secret", handshake.Response) // credentials from the URI
+	assert.Equal(t, "/", handshake.Vhost)
+}
+
+func TestTLSVerification(t *testing.T) {
+
+	pki := newTestPKI(t)
+	addr, handshakes := newTLSServer(t, pki)
+	uri := "amqp://guest:guest@" + addr + "/"
+
+	_, err := connectTLS(t, uri, &tcr.TLSConfig{EnableTLS: true, CertServerName: "rabbit.local"})
+	assert.Error(t, err) // the test CA is not trusted by the system pool
+
+	_, err = connectTLS(t, uri, &tcr.TLSConfig{EnableTLS: true, CACertPEM: string(pki.ca.certPEM), CertServerName: "rabbit.remote"})
+	assert.Error(t, err) // wrong server name
+
+	_, err = connectTLS(t, uri, &tcr.TLSConfig{EnableTLS: true, InsecureSkipVerify: true})
+	require.NoError(t, err)
+
+	handshake := nextHandshake(t, handshakes)
+	assert.Empty(t, handshake.ClientCN)
+	assert.Equal(t, "PLAIN", handshake.Mechanism)
+}
+
+func TestNewTLSClientConfig(t *testing.T) {
+
+	pki := newTestPKI(t)
+
+	cfg, err := tcr.NewTLSClientConfig(&tcr.TLSConfig{
+		CACertPEM:     string(pki.ca.certPEM),
+		ClientCertPEM: string(pki.client.certPEM),
+		ClientKeyPEM:  string(pki.client.keyPEM),
+		MinVersion:    "1.2",
+		CipherSuites:  []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, cfg.CipherSuites)
+	assert.Len(t, cfg.Certificates, 1)
+
+	_, err = tcr.NewTLSClientConfig(&tcr.TLSConfig{SASLExternal: true})
+	assert.Error(t, err) // no client certificate
+
+	_, err = tcr.NewTLSClientConfig(&tcr.TLSConfig{MinVersion: "2.0"})
+	assert.Error(t, err)
+
+	_, err = tcr.NewTLSClientConfig(&tcr.TLSConfig{CipherSuites: []string{"TLS_MADE_UP"}})
+	assert.Error(t, err)
+
+	_, err = tcr.NewTLSClientConfig(&tcr.TLSConfig{CACertPEM: "not a certificate"})
+	assert.Error(t, err)
+}
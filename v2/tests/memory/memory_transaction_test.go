@@ -0,0 +1,88 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishInTransactionCommitsAcrossExchanges(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+	newTestQueue(t, cp, "TcrTestAuditQueue")
+
+	topologer := tcr.NewTopologer(cp)
+	require.NoError(t, topologer.CreateExchange("TcrTestAudit", "fanout", false, false, false, false, false, nil))
+	require.NoError(t, topologer.QueueBind(&tcr.QueueBinding{QueueName: "TcrTestAuditQueue", ExchangeName: "TcrTestAudit"}))
+
+	audit := tcr.CreateMockRandomLetter("")
+	audit.Envelope.Exchange = "TcrTestAudit"
+	letters := append(newTestBatch(3), audit)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.PublishInTransaction(context.Background(), letters))
+
+	assert.Equal(t, 3, broker.MessageCount("TcrTestQueue"))
+	assert.Equal(t, 1, broker.MessageCount("TcrTestAuditQueue"))
+	assert.NoError(t, publisher.PublishInTransaction(context.Background(), nil))
+}
+
+func TestPublishInTransactionPublishesNothingOnFailure(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	missing := tcr.CreateMockRandomLetter("TcrTestQueue")
+	missing.Envelope.Exchange = "TcrTestMissing"
+	letters := append(newTestBatch(2), missing, tcr.CreateMockRandomLetter("TcrTestQueue"))
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	err := publisher.PublishInTransaction(context.Background(), letters)
+	assert.True(t, errors.Is(err, tcr.ErrChannelClosed))
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+
+	// the pooled channels are untouched, a transactional channel never enters their caches
+	require.NoError(t, publisher.PublishWithConfirmationContextError(context.Background(), tcr.CreateMockRandomLetter("TcrTestQueue")))
+	assert.Equal(t, 1, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestPublishInTransactionPublishesNothingWhenContextDone(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	err := publisher.PublishInTransaction(ctx, newTestBatch(3))
+	assert.Error(t, err)
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue"))
+}
+
+func TestPublishInTransactionReportsUnknownCommit(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	// The channel is closed by the time it commits, the publisher can't tell whether the commit happened.
+	missing := tcr.CreateMockRandomLetter("TcrTestQueue")
+	missing.Envelope.Exchange = "TcrTestMissing"
+	letters := append(newTestBatch(2), missing)
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	err := publisher.PublishInTransaction(context.Background(), letters)
+	assert.True(t, errors.Is(err, tcr.ErrTransactionUnknown), "%v", err)
+	assert.True(t, errors.Is(err, tcr.ErrChannelClosed))
+}
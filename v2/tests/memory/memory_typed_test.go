@@ -0,0 +1,166 @@
+package memory_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
+	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcrtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testOrder struct {
+	ID       int
+	Customer string
+	Items    []string
+}
+
+// textCodec encodes strings as plain text.
+type textCodec struct{}
+
+func (textCodec) ContentType() string { return "text/plain" }
+
+func (textCodec) Marshal(value interface{}) ([]byte, error) {
+	text, ok := value.(string)
+	if !ok {
+		return nil, errors.New("text codec only encodes strings")
+	}
+	return []byte(text), nil
+}
+
+func (textCodec) Unmarshal(data []byte, value interface{}) error {
+	text, ok := value.(*string)
+	if !ok {
+		return errors.New("text codec only decodes strings")
+	}
+	*text = string(data)
+	return nil
+}
+
+func newTestConsumer(t *testing.T, broker *tcrtest.Broker, cp *tcr.ConnectionPool) *tcr.Consumer {
+
+	consumer := tcr.NewConsumerFromConfig(newTestSeasoning(broker).ConsumerConfigs["TcrTestConsumer"], cp)
+	t.Cleanup(func() { _ = consumer.StopConsuming(true, true) })
+	return consumer
+}
+
+func TestTypedPublisherAndConsumer(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	compression := &tcr.CompressionConfig{Enabled: true, Type: tcr.ZstdCompressionType}
+	encryption := &tcr.EncryptionConfig{
+		Enabled: true,
+		Type:    tcr.AesSymmetricType,
+		Hashkey: tcr.GetHashWithArgon("TcrPassphrase", "TcrSalt", 1, 12, 1, 32),
+	}
+
+	publisher := tcr.NewTypedPublisher[testOrder](tcr.NewPublisher(cp, 0, 0, 5*time.Second), nil, compression, encryption)
+	order := testOrder{ID: 7, Customer: "Hops", Items: []string{"carrot", "lettuce"}}
+	require.NoError(t, publisher.PublishWithConfirmation(order, "", "TcrTestQueue", 0))
+
+	published := broker.Messages("TcrTestQueue")
+	require.Len(t, published, 1)
+	assert.Equal(t, tcr.JSONContentType, published[0].ContentType)
+	assert.NotContains(t, string(published[0].Body), "carrot") // compressed and encrypted
+
+	consumer := tcr.NewTypedConsumer[testOrder](newTestConsumer(t, broker, cp), nil, compression, encryption)
+	received := make(chan testOrder, 1)
+	consumer.StartConsuming(func(value testOrder, msg *tcr.ReceivedMessage) {
+		assert.NoError(t, msg.Acknowledge())
+		received <- value
+	})
+
+	select {
+	case value := <-received:
+		assert.Equal(t, order, value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("order was not consumed")
+	}
+}
+
+func TestTypedConsumerReadsServicePayloads(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	service := newTestService(t, broker)
+	defer service.Shutdown(true)
+
+	order := testOrder{ID: 8, Customer: "Clover"}
+	require.NoError(t, service.PublishWithConfirmation(order, "", "TcrTestQueue", "", false, nil))
+
+	consumer, err := service.GetConsumer("TcrTestConsumer")
+	require.NoError(t, err)
+	typed := tcr.NewTypedConsumer[testOrder](consumer, nil, service.Config.CompressionConfig, service.Config.EncryptionConfig)
+
+	received := make(chan testOrder, 1)
+	typed.StartConsuming(func(value testOrder, msg *tcr.ReceivedMessage) {
+		assert.NoError(t, msg.Acknowledge())
+		received <- value
+	})
+	defer func() { _ = consumer.StopConsuming(true, true) }()
+
+	select {
+	case value := <-received:
+		assert.Equal(t, order, value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("order was not consumed")
+	}
+}
+
+func TestTypedConsumerPicksRegisteredCodec(t *testing.T) {
+
+	tcr.RegisterCodec(textCodec{})
+	assert.NotNil(t, tcr.GetCodec("Text/Plain; charset=utf-8"))
+	assert.Nil(t, tcr.GetCodec("application/x-unknown"))
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewTypedPublisher[string](tcr.NewPublisher(cp, 0, 0, 5*time.Second), textCodec{}, nil, nil)
+	require.NoError(t, publisher.Publish("hello rabbit", "", "TcrTestQueue", true))
+	assert.Equal(t, "hello rabbit", string(broker.Messages("TcrTestQueue")[0].Body))
+
+	// Not JSON, so it only decodes with the codec registered for its content type.
+	consumer := tcr.NewTypedConsumer[string](newTestConsumer(t, broker, cp), nil, nil, nil)
+	received := make(chan string, 1)
+	consumer.StartConsuming(func(value string, msg *tcr.ReceivedMessage) {
+		assert.NoError(t, msg.Acknowledge())
+		received <- value
+	})
+
+	select {
+	case value := <-received:
+		assert.Equal(t, "hello rabbit", value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("text was not consumed")
+	}
+}
+
+func TestTypedConsumerRejectsUndecodableMessages(t *testing.T) {
+
+	broker := tcrtest.NewBroker()
+	cp := newTestPool(t, broker)
+	newTestQueue(t, cp, "TcrTestQueue")
+
+	publisher := tcr.NewPublisher(cp, 0, 0, 5*time.Second)
+	require.NoError(t, publisher.PublishWithConfirmationError(tcr.CreateMockRandomLetter("TcrTestQueue"), 0)) // random bytes
+
+	consumer := newTestConsumer(t, broker, cp)
+	typed := tcr.NewTypedConsumer[testOrder](consumer, tcr.JSONCodec{}, nil, nil)
+	typed.StartConsuming(func(testOrder, *tcr.ReceivedMessage) {
+		t.Error("an undecodable message reached the handler")
+	})
+
+	select {
+	case err := <-consumer.Errors():
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("decode error was not reported")
+	}
+	assert.Equal(t, 0, broker.MessageCount("TcrTestQueue")) // rejected without requeueing
+}